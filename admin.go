@@ -0,0 +1,729 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"pageknock-backend/dynamo"
+)
+
+const (
+	roleAdmin     = "admin"
+	roleModerator = "moderator"
+	// roleSiteOwner is limited to the site domains listed on its RoleItem.
+	roleSiteOwner = "site_owner"
+)
+
+const (
+	permRemoveComment   = "comment.remove"
+	permModerateComment = "comment.moderate"
+	permBanUser         = "user.ban"
+	permBanIp           = "ip.ban"
+	permBlockDomain     = "domain.block"
+	permAssignRole      = "role.assign"
+	permReadAudit       = "audit.read"
+	permManageOutbox    = "outbox.manage"
+)
+
+var rolePermissions = map[string][]string{
+	roleAdmin: {
+		permRemoveComment, permModerateComment, permBanUser, permBanIp,
+		permBlockDomain, permAssignRole, permReadAudit, permManageOutbox,
+	},
+	roleModerator: {permRemoveComment, permModerateComment, permBanUser},
+	roleSiteOwner: {permRemoveComment},
+}
+
+// banPermissions is the permission needed to add or lift each kind of ban.
+var banPermissions = map[string]string{
+	"user":   permBanUser,
+	"ip":     permBanIp,
+	"domain": permBlockDomain,
+}
+
+// adminActor is a user holding a role on the admin API.
+type adminActor struct {
+	UserID      string
+	Role        string
+	SiteDomains []string
+}
+
+// can reports whether the actor has permission on siteDomain. Site owners
+// only have their permissions on their own sites.
+func (a adminActor) can(permission string, siteDomain string) bool {
+	if !slices.Contains(rolePermissions[a.Role], permission) {
+		return false
+	}
+	return a.Role != roleSiteOwner || slices.Contains(a.SiteDomains, siteDomain)
+}
+
+// lookupActor returns the role of userId. ADMIN_USER_IDS and
+// MODERATOR_USER_IDS take precedence over the role table, so the first admin
+// can be bootstrapped and cannot lock themselves out.
+func lookupActor(userId string) (adminActor, bool, error) {
+	if adminUserIds[userId] {
+		return adminActor{UserID: userId, Role: roleAdmin}, true, nil
+	}
+	if moderatorUserIds[userId] {
+		return adminActor{UserID: userId, Role: roleModerator}, true, nil
+	}
+
+	item, ok, err := roleRepo.GetRole(userId)
+	if err != nil || !ok {
+		return adminActor{}, false, err
+	}
+	return adminActor{UserID: userId, Role: item.Role, SiteDomains: item.SiteDomains}, true, nil
+}
+
+// requireAdminActor returns the bearer token's user when they hold any role,
+// and answers 401 or 403 otherwise.
+func requireAdminActor(w http.ResponseWriter, r *http.Request) (adminActor, bool) {
+	userId, err := authenticator.BearerUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return adminActor{}, false
+	}
+
+	actor, ok, err := lookupActor(userId)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch role: %w", err))
+		return adminActor{}, false
+	}
+	if !ok {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return adminActor{}, false
+	}
+	return actor, true
+}
+
+// authorize is requireAdminActor for actions that are not tied to a site.
+func authorize(w http.ResponseWriter, r *http.Request, permission string) (adminActor, bool) {
+	actor, ok := requireAdminActor(w, r)
+	if !ok {
+		return adminActor{}, false
+	}
+	if !actor.can(permission, "") {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return adminActor{}, false
+	}
+	return actor, true
+}
+
+// writeAudit appends action to the admin audit log. Handlers call it before
+// carrying out the action and answer 500 without acting when it fails, so
+// that no action is ever missing from the log. An entry therefore records an
+// attempt; when the action then fails, auditFailure appends a second entry
+// saying so.
+func writeAudit(w http.ResponseWriter, r *http.Request, actor adminActor, action string, target string, detail string) bool {
+	if err := putAudit(r, actor, action, target, detail); err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to write audit log: %w", err))
+		return false
+	}
+	return true
+}
+
+// auditFailure appends "<action>.failed" to the admin audit log after an
+// audited action has failed. A failure to do so is only logged, the request
+// having failed already.
+func auditFailure(r *http.Request, actor adminActor, action string, target string) {
+	if err := putAudit(r, actor, action+".failed", target, ""); err != nil {
+		log.Printf("request %s: admin audit write failed: %s %s by %s: %v", requestId(r), action+".failed", target, actor.UserID, err)
+	}
+}
+
+func putAudit(r *http.Request, actor adminActor, action string, target string, detail string) error {
+	now := dynamo.GetUnixMillsecound()
+	return adminAuditRepo.PutAdminAudit(dynamo.AdminAuditItem{
+		GlobalKey: "GLOBAL",
+		AuditId:   dynamo.GenerateAuditId(now),
+		UnixTime:  now,
+		ActorID:   actor.UserID,
+		Role:      actor.Role,
+		Action:    action,
+		Target:    target,
+		Detail:    detail,
+		Ip:        clientIp(r),
+	})
+}
+
+func writeAdminResult(w http.ResponseWriter, message string) {
+	resp := map[string]string{
+		"message": message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAdminRemoveComment removes any comment the caller may remove,
+// including its moderation queue entry.
+func handleAdminRemoveComment(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	actor, ok := requireAdminActor(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Url       string `json:"url"`
+		UnixTime  int64  `json:"unixTime"`
+		CommentId string `json:"commentId"`
+		Reason    string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"url": req.Url != "", "unixTime": req.UnixTime != 0, "commentId": req.CommentId != ""}) {
+		return
+	}
+
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(req.Url)
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
+	}
+
+	domain, err := dynamo.GetDomainWithScheme(canonicalUrl)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("URL conversion failed: %w", err))
+		return
+	}
+
+	if !actor.can(permRemoveComment, domain) {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return
+	}
+
+	comment, ok, err := commentRepo.GetComment(canonicalUrl, req.UnixTime)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+		return
+	}
+	if !ok || comment.CommentId != req.CommentId {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+
+	now := dynamo.GetUnixMillsecound()
+	logItem := dynamo.CommentLogItem{
+		GlobalKey: "GLOBAL",
+		LogId:     dynamo.CommentLogId(now, comment.CommentId, "remove"),
+		UnixTime:  now,
+		CommentId: comment.CommentId,
+		Ip:        clientIp(r),
+		UserAgent: dynamo.GetUserAgent(r),
+		Action:    "remove",
+		UserID:    actor.UserID,
+	}
+
+	if !writeAudit(w, r, actor, "comment.remove", comment.CommentId, req.Reason) {
+		return
+	}
+
+	err = commentTransactionRepo.DeleteComment(comment, domain, logItem)
+	if err != nil {
+		auditFailure(r, actor, "comment.remove", comment.CommentId)
+	}
+	var canceled *dynamo.TransactionCanceledError
+	if errors.As(err, &canceled) && canceled.ConditionFailed(0) {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+	if errors.Is(err, dynamo.ErrTransactionCanceled) {
+		writeError(w, r, http.StatusConflict, codeConflict)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	writeAdminResult(w, "Remove succeeded!")
+}
+
+// normalizeBanValue returns the form bans of kind are stored under: IP bans
+// as a masked CIDR prefix (a single address becomes /32 or /128) and domain
+// blocks as a canonical site domain.
+func normalizeBanValue(kind string, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("empty value")
+	}
+
+	switch kind {
+	case "ip":
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return "", err
+			}
+			return prefix.Masked().String(), nil
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return "", err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+	case "domain":
+		return canonicalizeAdminSiteDomain(value)
+	default:
+		return value, nil
+	}
+}
+
+// canonicalizeAdminSiteDomain canonicalizes a site domain given to the admin
+// API, where a bare host such as "example.com" means its https site.
+func canonicalizeAdminSiteDomain(raw string) (string, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	return urlCanonicalizer.CanonicalizeSiteDomain(raw)
+}
+
+// handleAdminGetBans lists the bans of one kind (?kind=user|ip|domain),
+// expired ones included until DynamoDB's TTL removes them.
+func handleAdminGetBans(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	actor, ok := requireAdminActor(w, r)
+	if !ok {
+		return
+	}
+
+	kind := r.URL.Query().Get("kind")
+	permission, known := banPermissions[kind]
+	if !known {
+		writeInvalidField(w, r, "kind", fieldUnknownValue)
+		return
+	}
+	if !actor.can(permission, "") {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return
+	}
+
+	scope := "adminBans:" + kind
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := banRepo.GetBans(kind, page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch bans: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.BanListResponse{
+		Bans:       make([]dynamo.BanResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		response.Bans = append(response.Bans, dynamo.BanResponse{
+			Kind:      rec.Kind,
+			Value:     rec.Value,
+			Reason:    rec.Reason,
+			CreatedBy: rec.CreatedBy,
+			UnixTime:  rec.UnixTime,
+			ExpiresAt: rec.ExpiresAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminAddBan bans a user, an IP range or a site domain, replacing an
+// existing ban of the same value. expiresAt is in unix seconds; 0 bans
+// permanently.
+func handleAdminAddBan(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	// Which permission is needed depends on the body, so only holding a role
+	// is checked before reading it.
+	actor, ok := requireAdminActor(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Kind      string `json:"kind"`
+		Value     string `json:"value"`
+		Reason    string `json:"reason"`
+		ExpiresAt int64  `json:"expiresAt"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	permission, known := banPermissions[req.Kind]
+	if !known {
+		writeInvalidField(w, r, "kind", fieldUnknownValue)
+		return
+	}
+	if !actor.can(permission, "") {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return
+	}
+
+	value, err := normalizeBanValue(req.Kind, req.Value)
+	if err != nil {
+		writeInvalidField(w, r, "value", fieldInvalid)
+		return
+	}
+	if req.ExpiresAt != 0 && req.ExpiresAt <= time.Now().Unix() {
+		writeInvalidField(w, r, "expiresAt", fieldInPast)
+		return
+	}
+
+	target := req.Kind + ":" + value
+	if !writeAudit(w, r, actor, "ban.add", target, req.Reason) {
+		return
+	}
+
+	err = banRepo.PutBan(dynamo.BanItem{
+		Kind:      req.Kind,
+		Value:     value,
+		Reason:    req.Reason,
+		CreatedBy: actor.UserID,
+		UnixTime:  dynamo.GetUnixMillsecound(),
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		auditFailure(r, actor, "ban.add", target)
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+	if req.Kind == "ip" {
+		ipBans.invalidate()
+	}
+
+	writeAdminResult(w, "Ban succeeded!")
+}
+
+// handleAdminRemoveBan lifts a ban. Lifting a ban that does not exist
+// succeeds.
+func handleAdminRemoveBan(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	// As for handleAdminAddBan, the permission depends on the body.
+	actor, ok := requireAdminActor(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	permission, known := banPermissions[req.Kind]
+	if !known {
+		writeInvalidField(w, r, "kind", fieldUnknownValue)
+		return
+	}
+	if !actor.can(permission, "") {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return
+	}
+
+	value, err := normalizeBanValue(req.Kind, req.Value)
+	if err != nil {
+		writeInvalidField(w, r, "value", fieldInvalid)
+		return
+	}
+
+	target := req.Kind + ":" + value
+	if !writeAudit(w, r, actor, "ban.remove", target, "") {
+		return
+	}
+
+	if err := banRepo.DeleteBan(req.Kind, value); err != nil {
+		auditFailure(r, actor, "ban.remove", target)
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+	if req.Kind == "ip" {
+		ipBans.invalidate()
+	}
+
+	writeAdminResult(w, "Unban succeeded!")
+}
+
+// handleAdminGetRoles lists the roles in the role table. Roles granted by
+// ADMIN_USER_IDS and MODERATOR_USER_IDS are not included.
+func handleAdminGetRoles(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	if _, ok := authorize(w, r, permAssignRole); !ok {
+		return
+	}
+
+	scope := "adminRoles"
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := roleRepo.GetRoles(page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch roles: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.RoleListResponse{
+		Roles:      make([]dynamo.RoleResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		response.Roles = append(response.Roles, dynamo.RoleResponse{
+			UserID:      rec.UserID,
+			Role:        rec.Role,
+			SiteDomains: rec.SiteDomains,
+			UnixTime:    rec.UnixTime,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminAssignRole grants a user a role, replacing the one they had.
+// site_owner requires the siteDomains it applies to.
+func handleAdminAssignRole(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	actor, ok := authorize(w, r, permAssignRole)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserId      string   `json:"userId"`
+		Role        string   `json:"role"`
+		SiteDomains []string `json:"siteDomains"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"userId": req.UserId != "", "role": req.Role != ""}) {
+		return
+	}
+	if _, known := rolePermissions[req.Role]; !known {
+		writeInvalidField(w, r, "role", fieldUnknownValue)
+		return
+	}
+
+	var siteDomains []string
+	if req.Role == roleSiteOwner {
+		if !requireFields(w, r, map[string]bool{"siteDomains": len(req.SiteDomains) > 0}) {
+			return
+		}
+		for _, raw := range req.SiteDomains {
+			domain, err := canonicalizeAdminSiteDomain(raw)
+			if err != nil {
+				writeInvalidField(w, r, "siteDomains", fieldInvalid)
+				return
+			}
+			siteDomains = append(siteDomains, domain)
+		}
+	}
+
+	if !writeAudit(w, r, actor, "role.assign", req.UserId, strings.Join(append([]string{req.Role}, siteDomains...), " ")) {
+		return
+	}
+
+	err := roleRepo.PutRole(dynamo.RoleItem{
+		UserID:      req.UserId,
+		Role:        req.Role,
+		SiteDomains: siteDomains,
+		UnixTime:    dynamo.GetUnixMillsecound(),
+	})
+	if err != nil {
+		auditFailure(r, actor, "role.assign", req.UserId)
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	writeAdminResult(w, "Role assigned!")
+}
+
+// handleAdminRevokeRole removes a user's role from the role table.
+func handleAdminRevokeRole(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	actor, ok := authorize(w, r, permAssignRole)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserId string `json:"userId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"userId": req.UserId != ""}) {
+		return
+	}
+
+	if !writeAudit(w, r, actor, "role.revoke", req.UserId, "") {
+		return
+	}
+
+	if err := roleRepo.DeleteRole(req.UserId); err != nil {
+		auditFailure(r, actor, "role.revoke", req.UserId)
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	writeAdminResult(w, "Role revoked!")
+}
+
+// handleAdminGetAudit lists the admin audit log, newest first.
+func handleAdminGetAudit(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	if _, ok := authorize(w, r, permReadAudit); !ok {
+		return
+	}
+
+	scope := "adminAudit"
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := adminAuditRepo.GetAdminAudit(page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch audit log: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.AdminAuditListResponse{
+		Entries:    make([]dynamo.AdminAuditResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		response.Entries = append(response.Entries, dynamo.AdminAuditResponse{
+			AuditId:  rec.AuditId,
+			UnixTime: rec.UnixTime,
+			ActorID:  rec.ActorID,
+			Role:     rec.Role,
+			Action:   rec.Action,
+			Target:   rec.Target,
+			Detail:   rec.Detail,
+			Ip:       rec.Ip,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
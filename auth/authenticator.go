@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// LinkedUserLookup returns the account a device has been linked to, if any.
+type LinkedUserLookup func(deviceID string) (userID string, ok bool, err error)
+
+// Identity is the resolved caller of a request. DeviceID is set whenever the
+// request presented (or was just issued) a device identity; NewDevice says
+// it was just issued.
+type Identity struct {
+	UserID    string
+	DeviceID  string
+	NewDevice bool
+}
+
+// Authenticator resolves a request to a user ID from its bearer token.
+// Requests without a token are attributed to a pseudonymous device ID, but
+// only when anonymous posting has been enabled explicitly. A device cookie
+// never stands in for an account: once the device has been linked to one,
+// requests from it must carry that account's bearer token.
+type Authenticator struct {
+	verifier       *Verifier
+	devices        *DeviceIdentities
+	linkedUser     LinkedUserLookup
+	allowAnonymous bool
+}
+
+func NewAuthenticator(verifier *Verifier, devices *DeviceIdentities, linkedUser LinkedUserLookup, allowAnonymous bool) *Authenticator {
+	return &Authenticator{
+		verifier:       verifier,
+		devices:        devices,
+		linkedUser:     linkedUser,
+		allowAnonymous: allowAnonymous,
+	}
+}
+
+// Identify returns the caller's identity. A request carrying an invalid token
+// is rejected even when anonymous posting is enabled, and so is one from a
+// linked device without a token. Anonymous callers without a device identity
+// are issued one through w.
+func (a *Authenticator) Identify(w http.ResponseWriter, r *http.Request) (Identity, error) {
+	deviceID, hasDevice := a.devices.Read(r)
+
+	if r.Header.Get("Authorization") != "" {
+		userID, err := a.bearerUserID(r)
+		if err != nil {
+			return Identity{}, err
+		}
+		return Identity{UserID: userID, DeviceID: deviceID}, nil
+	}
+
+	if !a.allowAnonymous {
+		return Identity{}, ErrMissingToken
+	}
+
+	if !hasDevice {
+		deviceID = a.devices.Issue(w, r)
+		return Identity{UserID: deviceID, DeviceID: deviceID, NewDevice: true}, nil
+	}
+
+	_, linked, err := a.linkedUser(deviceID)
+	if err != nil {
+		return Identity{}, err
+	}
+	if linked {
+		return Identity{}, ErrMissingToken
+	}
+	return Identity{UserID: deviceID, DeviceID: deviceID}, nil
+}
+
+// Device returns the caller's device ID, issuing one when the request has
+// none. It does not look at bearer tokens.
+func (a *Authenticator) Device(w http.ResponseWriter, r *http.Request) string {
+	if deviceID, ok := a.devices.Read(r); ok {
+		return deviceID
+	}
+	return a.devices.Issue(w, r)
+}
+
+// PresentedDevice returns the device ID the request carries, without issuing
+// a new one.
+func (a *Authenticator) PresentedDevice(r *http.Request) (string, bool) {
+	return a.devices.Read(r)
+}
+
+// BearerUserID returns the user ID of the request's bearer token, which is
+// required.
+func (a *Authenticator) BearerUserID(r *http.Request) (string, error) {
+	if r.Header.Get("Authorization") == "" {
+		return "", ErrMissingToken
+	}
+	return a.bearerUserID(r)
+}
+
+func (a *Authenticator) bearerUserID(r *http.Request) (string, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", ErrInvalidToken
+	}
+
+	claims, err := a.verifier.Verify(strings.TrimSpace(token))
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(claims.Subject, DeviceIDPrefix) {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DeviceIDPrefix starts every device ID, keeping them apart from the user
+	// IDs of real accounts.
+	DeviceIDPrefix = "device-"
+
+	DeviceCookieName = "pk_device"
+	// DeviceHeaderName carries the same signed value as the cookie for clients
+	// that cannot keep cookies, such as extension background scripts.
+	DeviceHeaderName = "X-Device-Id"
+
+	deviceCookieMaxAge = 400 * 24 * time.Hour
+)
+
+// DeviceIdentities mints and verifies pseudonymous device IDs. A device ID is
+// handed to the client as "<id>.<HMAC of id>", so clients can keep it but not
+// forge another device's ID.
+type DeviceIdentities struct {
+	secret []byte
+}
+
+func NewDeviceIdentities(secret []byte) *DeviceIdentities {
+	return &DeviceIdentities{secret: secret}
+}
+
+// Read returns the device ID presented by the request in the device cookie or
+// header, if it carries a valid signature.
+func (d *DeviceIdentities) Read(r *http.Request) (string, bool) {
+	value := r.Header.Get(DeviceHeaderName)
+	if value == "" {
+		cookie, err := r.Cookie(DeviceCookieName)
+		if err != nil {
+			return "", false
+		}
+		value = cookie.Value
+	}
+
+	deviceID, encodedMac, ok := strings.Cut(value, ".")
+	if !ok || !strings.HasPrefix(deviceID, DeviceIDPrefix) {
+		return "", false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil || !hmac.Equal(mac, d.sign(deviceID)) {
+		return "", false
+	}
+	return deviceID, true
+}
+
+// Issue mints a new device ID and hands it to the client both as a cookie and
+// in the device header.
+func (d *DeviceIdentities) Issue(w http.ResponseWriter, r *http.Request) string {
+	deviceID := DeviceIDPrefix + uuid.New().String()
+	value := deviceID + "." + base64.RawURLEncoding.EncodeToString(d.sign(deviceID))
+
+	cookie := &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(deviceCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+	w.Header().Set(DeviceHeaderName, value)
+
+	return deviceID
+}
+
+func (d *DeviceIdentities) sign(deviceID string) []byte {
+	mac := hmac.New(sha256.New, d.secret)
+	mac.Write([]byte(deviceID))
+	return mac.Sum(nil)
+}
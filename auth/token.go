@@ -0,0 +1,138 @@
+// Package auth resolves the caller of a request to a user ID.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Claims are the registered JWT claims this server relies on.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// audience accepts both forms the JWT spec allows for "aud": a single string
+// or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Verifier checks HS256-signed JWTs against a locally configured key and the
+// expected issuer and audience.
+type Verifier struct {
+	secret   []byte
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+func NewVerifier(secret []byte, issuer string, audience string) *Verifier {
+	return &Verifier{
+		secret:   secret,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   time.Minute,
+		now:      time.Now,
+	}
+}
+
+// Verify returns the claims of token when its signature, issuer, audience and
+// validity period are all correct. Every failure wraps ErrInvalidToken.
+// Without an expected issuer and audience every token is rejected, as one
+// minted for any other service sharing the key would be accepted otherwise.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	if len(v.secret) == 0 {
+		return Claims{}, fmt.Errorf("%w: no verification key configured", ErrInvalidToken)
+	}
+	if v.issuer == "" || v.audience == "" {
+		return Claims{}, fmt.Errorf("%w: no issuer or audience configured", ErrInvalidToken)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	if header.Alg != "HS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+
+	now := v.now()
+	switch {
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	case claims.Issuer != v.issuer:
+		return Claims{}, fmt.Errorf("%w: unexpected iss", ErrInvalidToken)
+	case !slices.Contains(claims.Audience, v.audience):
+		return Claims{}, fmt.Errorf("%w: unexpected aud", ErrInvalidToken)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(v.leeway)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(claims.NotBefore, 0)):
+		return Claims{}, fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidToken)
+	}
+	return nil
+}
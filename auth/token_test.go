@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("secret")
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func newTestVerifier(issuer string, audience string) *Verifier {
+	v := NewVerifier(testSecret, issuer, audience)
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+// sign returns a JWT with header and claims, signed with HS256 under secret.
+func sign(t *testing.T, secret []byte, header map[string]any, claims map[string]any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(header) + "." + segment(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub": "user-1",
+		"iss": "issuer",
+		"aud": "audience",
+		"exp": testNow.Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerifyAcceptsValidToken(t *testing.T) {
+	v := newTestVerifier("issuer", "audience")
+
+	claims := validClaims()
+	claims["aud"] = []string{"other", "audience"}
+	got, err := v.Verify(sign(t, testSecret, map[string]any{"alg": "HS256"}, claims))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.Subject != "user-1" {
+		t.Errorf("Subject = %q, want user-1", got.Subject)
+	}
+}
+
+func TestVerifyRejectsInvalidToken(t *testing.T) {
+	hs256 := map[string]any{"alg": "HS256"}
+	with := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(t, testSecret, hs256, with("exp", testNow.Add(-2*time.Minute).Unix()))},
+		{"no expiry", sign(t, testSecret, hs256, with("exp", nil))},
+		{"not yet valid", sign(t, testSecret, hs256, with("nbf", testNow.Add(2*time.Minute).Unix()))},
+		{"alg none", sign(t, testSecret, map[string]any{"alg": "none"}, validClaims())},
+		{"alg RS256", sign(t, testSecret, map[string]any{"alg": "RS256"}, validClaims())},
+		{"wrong issuer", sign(t, testSecret, hs256, with("iss", "other"))},
+		{"no issuer", sign(t, testSecret, hs256, with("iss", nil))},
+		{"wrong audience", sign(t, testSecret, hs256, with("aud", "other"))},
+		{"no audience", sign(t, testSecret, hs256, with("aud", nil))},
+		{"no subject", sign(t, testSecret, hs256, with("sub", nil))},
+		{"wrong key", sign(t, []byte("other"), hs256, validClaims())},
+		{"malformed", "not-a-token"},
+	}
+	v := newTestVerifier("issuer", "audience")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAllowsLeeway(t *testing.T) {
+	v := newTestVerifier("issuer", "audience")
+
+	token := sign(t, testSecret, map[string]any{"alg": "HS256"}, map[string]any{
+		"sub": "user-1",
+		"iss": "issuer",
+		"aud": "audience",
+		"exp": testNow.Add(-30 * time.Second).Unix(),
+	})
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("Verify of a token expired within the leeway: %v", err)
+	}
+}
+
+func TestVerifyRequiresIssuerAndAudience(t *testing.T) {
+	// A token without iss or aud would match a verifier expecting none.
+	token := sign(t, testSecret, map[string]any{"alg": "HS256"}, map[string]any{
+		"sub": "user-1",
+		"exp": testNow.Add(time.Hour).Unix(),
+	})
+	for _, v := range []*Verifier{newTestVerifier("", "audience"), newTestVerifier("issuer", ""), newTestVerifier("", "")} {
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify with issuer %q, audience %q = %v, want ErrInvalidToken", v.issuer, v.audience, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"pageknock-backend/dynamo"
+)
+
+// ipBanRefresh is how long the IP ban list is cached. A ban added on another
+// instance takes effect here within this time.
+const ipBanRefresh = 30 * time.Second
+
+var ipBans = &ipBanList{}
+
+type ipBan struct {
+	prefix    netip.Prefix
+	expiresAt int64
+}
+
+// ipBanList caches every IP ban, since a ban on a range cannot be looked up
+// by the address being checked.
+type ipBanList struct {
+	mu       sync.Mutex
+	bans     []ipBan
+	loadedAt time.Time
+}
+
+func (l *ipBanList) invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.loadedAt = time.Time{}
+}
+
+// banned reports whether ip falls in an active IP ban. An address that does
+// not parse is an error rather than "not banned", so that a malformed client
+// address cannot slip past every ban.
+func (l *ipBanList) banned(ip string, now time.Time) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, fmt.Errorf("invalid client address %q: %w", ip, err)
+	}
+	addr = addr.Unmap()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.loadedAt) > ipBanRefresh {
+		if err := l.load(); err != nil {
+			return false, err
+		}
+		l.loadedAt = now
+	}
+
+	for _, ban := range l.bans {
+		if banActive(ban.expiresAt, now) && ban.prefix.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (l *ipBanList) load() error {
+	var bans []ipBan
+	page := dynamo.PageRequest{}
+	for {
+		items, lastKey, err := banRepo.GetBans("ip", page)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			prefix, err := netip.ParsePrefix(item.Value)
+			if err != nil {
+				continue
+			}
+			bans = append(bans, ipBan{prefix: prefix, expiresAt: item.ExpiresAt})
+		}
+		if lastKey == nil {
+			break
+		}
+		page.StartKey = lastKey
+	}
+	l.bans = bans
+	return nil
+}
+
+// banActive reports whether a ban expiring at expiresAt (unix seconds, 0 for
+// never) is in force. DynamoDB's TTL deletes expired bans only eventually.
+func banActive(expiresAt int64, now time.Time) bool {
+	return expiresAt == 0 || expiresAt > now.Unix()
+}
+
+// checkBans answers 403 and returns false when the user, the IP address or
+// the site domain is banned.
+func checkBans(w http.ResponseWriter, r *http.Request, userId string, ip string, siteDomain string) bool {
+	now := time.Now()
+
+	for _, key := range []struct{ kind, value string }{
+		{"user", userId},
+		{"domain", siteDomain},
+	} {
+		ban, ok, err := banRepo.GetBan(key.kind, key.value)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch ban: %w", err))
+			return false
+		}
+		if ok && banActive(ban.ExpiresAt, now) {
+			writeError(w, r, http.StatusForbidden, codeBanned)
+			return false
+		}
+	}
+
+	banned, err := ipBans.banned(ip, now)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to check IP ban: %w", err))
+		return false
+	}
+	if banned {
+		writeError(w, r, http.StatusForbidden, codeBanned)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+// Command reconcile recomputes PageStructure, PageGlobalStructure,
+// RecentGlobalComment and RecentDomainComment from the Comment table and
+// reports every row that differs. By default it only reports; pass -apply to
+// rewrite the differing rows after reviewing a dry run.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/reconcile"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	apply := flag.Bool("apply", false, "rewrite the differing derived rows instead of only reporting them")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using system environment")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := dynamodb.NewFromConfig(cfg)
+
+	stores := reconcile.Stores{
+		Comment:             dynamo.NewCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_COMMENT"), dynamo.CommentIndexNames{}),
+		PageGlobalStructure: dynamo.NewPageGlobalStructureRepository(client, os.Getenv("DYNAMO_TABLE_NAME_PAGEGLOBALSTRUCTURE")),
+		PageStructure:       dynamo.NewPageStructureRepository(client, os.Getenv("DYNAMO_TABLE_NAME_PAGESTRUCTURE")),
+		RecentDomainComment: dynamo.NewRecentDomainCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_RECENTDOMAINCOMMENT")),
+		RecentGlobalComment: dynamo.NewRecentGlobalCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_RECENTGLOBALCOMMENT")),
+	}
+
+	diffs, err := reconcile.Compute(stores)
+	if err != nil {
+		log.Fatalf("failed to compute differences: %v", err)
+	}
+
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	fmt.Printf("%d differences\n", len(diffs))
+
+	if len(diffs) == 0 {
+		return
+	}
+	if !*apply {
+		fmt.Println("dry run: nothing was written, rerun with -apply to repair")
+		return
+	}
+
+	if err := reconcile.Apply(diffs); err != nil {
+		log.Fatalf("failed to apply: %v", err)
+	}
+	fmt.Printf("repaired %d rows\n", len(diffs))
+}
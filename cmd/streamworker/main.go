@@ -0,0 +1,70 @@
+// Command streamworker reads the Comment table's DynamoDB stream and keeps
+// RecentGlobalComment, RecentDomainComment, PageStructure and
+// PageGlobalStructure up to date, for servers run with DERIVED_TABLES=stream.
+// The stream must be enabled with the NEW_AND_OLD_IMAGES view type, and only
+// one worker may run per stream. It stops on SIGINT or SIGTERM.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pageknock-backend/commentstream"
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	interval := flag.Duration("interval", time.Second, "how often every shard is polled")
+	maxAttempts := flag.Int("max-attempts", 5, "polls a failing record is retried on before it is skipped")
+	flag.Parse()
+	if *maxAttempts < 1 {
+		log.Fatal("-max-attempts must be at least 1")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using system environment")
+	}
+
+	streamArn := os.Getenv("COMMENT_STREAM_ARN")
+	if streamArn == "" {
+		log.Fatal("COMMENT_STREAM_ARN is not set")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := dynamodb.NewFromConfig(cfg)
+
+	tables := dynamo.TableNames{
+		Comment:             os.Getenv("DYNAMO_TABLE_NAME_COMMENT"),
+		PageGlobalStructure: os.Getenv("DYNAMO_TABLE_NAME_PAGEGLOBALSTRUCTURE"),
+		PageStructure:       os.Getenv("DYNAMO_TABLE_NAME_PAGESTRUCTURE"),
+		RecentDomainComment: os.Getenv("DYNAMO_TABLE_NAME_RECENTDOMAINCOMMENT"),
+		RecentGlobalComment: os.Getenv("DYNAMO_TABLE_NAME_RECENTGLOBALCOMMENT"),
+		AppliedCommentEvent: os.Getenv("DYNAMO_TABLE_NAME_APPLIEDCOMMENTEVENT"),
+	}
+	processor := commentstream.NewProcessor(dynamo.NewCommentTransactionRepository(client, tables))
+	checkpoints := dynamo.NewStreamCheckpointRepository(client, os.Getenv("DYNAMO_TABLE_NAME_STREAMCHECKPOINT"))
+	worker := commentstream.NewWorker(dynamodbstreams.NewFromConfig(cfg), streamArn, processor, checkpoints, *interval, *maxAttempts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("reading %s", streamArn)
+	if err := worker.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("worker stopped: %v", err)
+	}
+	log.Println("stopped")
+}
@@ -0,0 +1,117 @@
+// Package commentstream reads the Comment table's DynamoDB stream and applies
+// it to the tables derived from it (RecentGlobalComment, RecentDomainComment,
+// PageStructure and PageGlobalStructure), so that a request only has to write
+// the Comment table.
+package commentstream
+
+import (
+	"fmt"
+	"reflect"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	streamstypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+const (
+	EventInsert = "INSERT"
+	EventModify = "MODIFY"
+	EventRemove = "REMOVE"
+)
+
+// Record is a Comment table stream record. OldImage is nil for an insert and
+// NewImage is nil for a removal; the stream must use NEW_AND_OLD_IMAGES.
+type Record struct {
+	EventName      string
+	SequenceNumber string
+	OldImage       *dynamo.CommentItem
+	NewImage       *dynamo.CommentItem
+}
+
+// RecordFromStream converts a record as returned by GetRecords.
+func RecordFromStream(rec streamstypes.Record) (Record, error) {
+	if rec.Dynamodb == nil || rec.Dynamodb.SequenceNumber == nil {
+		return Record{}, fmt.Errorf("stream record without sequence number")
+	}
+	out := Record{
+		EventName:      string(rec.EventName),
+		SequenceNumber: *rec.Dynamodb.SequenceNumber,
+	}
+
+	var err error
+	if out.OldImage, err = commentFromImage(rec.Dynamodb.OldImage); err != nil {
+		return Record{}, err
+	}
+	if out.NewImage, err = commentFromImage(rec.Dynamodb.NewImage); err != nil {
+		return Record{}, err
+	}
+	return out, nil
+}
+
+func commentFromImage(image map[string]streamstypes.AttributeValue) (*dynamo.CommentItem, error) {
+	if image == nil {
+		return nil, nil
+	}
+	av, err := attributevalue.FromDynamoDBStreamsMap(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert image: %w", err)
+	}
+	var item dynamo.CommentItem
+	if err := attributevalue.UnmarshalMap(av, &item); err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+	return &item, nil
+}
+
+type Processor struct {
+	store dynamo.CommentStreamStore
+}
+
+func NewProcessor(store dynamo.CommentStreamStore) *Processor {
+	return &Processor{store: store}
+}
+
+// Process applies one record. Records may be delivered more than once; the
+// store skips those applied before.
+func (p *Processor) Process(rec Record) error {
+	switch rec.EventName {
+	case EventInsert:
+		if rec.NewImage == nil {
+			return fmt.Errorf("%s %s: missing new image", rec.EventName, rec.SequenceNumber)
+		}
+		siteDomain, err := dynamo.GetDomainWithScheme(rec.NewImage.Url)
+		if err != nil {
+			return err
+		}
+		return p.store.ApplyCommentInsert(*rec.NewImage, siteDomain)
+
+	case EventModify:
+		if rec.OldImage == nil || rec.NewImage == nil {
+			return fmt.Errorf("%s %s: missing image", rec.EventName, rec.SequenceNumber)
+		}
+		siteDomain, err := dynamo.GetDomainWithScheme(rec.NewImage.Url)
+		if err != nil {
+			return err
+		}
+		// Many modifications (reply and report counts) change
+		// nothing the Recent* rows hold.
+		oldGlobal, oldDomain := dynamo.RecentCommentItemsFromComment(*rec.OldImage, siteDomain)
+		newGlobal, newDomain := dynamo.RecentCommentItemsFromComment(*rec.NewImage, siteDomain)
+		if reflect.DeepEqual(oldGlobal, newGlobal) && reflect.DeepEqual(oldDomain, newDomain) {
+			return nil
+		}
+		return p.store.ApplyCommentModify(*rec.NewImage, siteDomain)
+
+	case EventRemove:
+		if rec.OldImage == nil {
+			return fmt.Errorf("%s %s: missing old image", rec.EventName, rec.SequenceNumber)
+		}
+		siteDomain, err := dynamo.GetDomainWithScheme(rec.OldImage.Url)
+		if err != nil {
+			return err
+		}
+		return p.store.ApplyCommentRemove(*rec.OldImage, siteDomain)
+	}
+	return fmt.Errorf("unknown event %q", rec.EventName)
+}
@@ -0,0 +1,271 @@
+package commentstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamstypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// recordsLimit is the most records read from a shard per poll.
+const recordsLimit = 1000
+
+// API is the part of the DynamoDB Streams client the worker uses.
+type API interface {
+	DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+var _ API = (*dynamodbstreams.Client)(nil)
+
+// Worker reads every shard of a stream and hands its records to a Processor.
+// How far each shard was read is kept in a StreamCheckpointStore, so a
+// restarted worker resumes after the last record it checkpointed; records
+// processed after that checkpoint are delivered again.
+//
+// A shard is read only after its parent has been read to its end, so the
+// records of one comment are applied in order. Run a single worker per
+// stream.
+//
+// A record that fails maxAttempts polls in a row is logged and skipped, so
+// that one bad record cannot hold up the rest of its shard forever;
+// reconcile repairs the counters it would have changed.
+type Worker struct {
+	api          API
+	streamArn    string
+	processor    *Processor
+	checkpoints  dynamo.StreamCheckpointStore
+	pollInterval time.Duration
+	maxAttempts  int
+
+	// iterators holds the next shard iterator of each shard being read.
+	iterators map[string]string
+	// failures holds the record each shard is stuck on.
+	failures map[string]recordFailure
+}
+
+type recordFailure struct {
+	sequenceNumber string
+	attempts       int
+}
+
+func NewWorker(api API, streamArn string, processor *Processor, checkpoints dynamo.StreamCheckpointStore, pollInterval time.Duration, maxAttempts int) *Worker {
+	return &Worker{
+		api:          api,
+		streamArn:    streamArn,
+		processor:    processor,
+		checkpoints:  checkpoints,
+		pollInterval: pollInterval,
+		maxAttempts:  maxAttempts,
+		iterators:    map[string]string{},
+		failures:     map[string]recordFailure{},
+	}
+}
+
+// Run polls until ctx is done. Failures are logged and retried on the next
+// poll.
+func (w *Worker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Poll(ctx); err != nil {
+			log.Printf("stream poll failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads one batch of records from every shard that is ready to be read.
+func (w *Worker) Poll(ctx context.Context) error {
+	shards, err := w.listShards(ctx)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	finished := map[string]bool{}
+	checkpoints := map[string]dynamo.StreamCheckpointItem{}
+	for _, shard := range shards {
+		shardId := aws.ToString(shard.ShardId)
+		listed[shardId] = true
+		checkpoint, ok, err := w.checkpoints.GetCheckpoint(shardId)
+		if err != nil {
+			return fmt.Errorf("failed to get checkpoint: %w", err)
+		}
+		if ok {
+			checkpoints[shardId] = checkpoint
+			finished[shardId] = checkpoint.Finished
+		}
+	}
+	for shardId := range w.iterators {
+		if !listed[shardId] {
+			delete(w.iterators, shardId)
+		}
+	}
+	for shardId := range w.failures {
+		if !listed[shardId] {
+			delete(w.failures, shardId)
+		}
+	}
+
+	// DescribeStream lists parents before their children, so a child whose
+	// parent finishes here is read in the same poll.
+	var errs []error
+	for _, shard := range shards {
+		shardId := aws.ToString(shard.ShardId)
+		if finished[shardId] {
+			continue
+		}
+		if parent := aws.ToString(shard.ParentShardId); parent != "" && listed[parent] && !finished[parent] {
+			continue
+		}
+
+		done, err := w.readShard(ctx, shardId, checkpoints[shardId])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", shardId, err))
+			continue
+		}
+		finished[shardId] = done
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Worker) listShards(ctx context.Context) ([]streamstypes.Shard, error) {
+	var shards []streamstypes.Shard
+	var startShardId *string
+	for {
+		out, err := w.api.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(w.streamArn),
+			ExclusiveStartShardId: startShardId,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe stream: %w", err)
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if out.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		startShardId = out.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// readShard processes one batch of the shard's records and checkpoints the
+// last one processed. It reports whether the shard is closed and read to its
+// end.
+func (w *Worker) readShard(ctx context.Context, shardId string, checkpoint dynamo.StreamCheckpointItem) (bool, error) {
+	iterator, ok := w.iterators[shardId]
+	if !ok {
+		input := &dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         aws.String(w.streamArn),
+			ShardId:           aws.String(shardId),
+			ShardIteratorType: streamstypes.ShardIteratorTypeTrimHorizon,
+		}
+		if checkpoint.SequenceNumber != "" {
+			input.ShardIteratorType = streamstypes.ShardIteratorTypeAfterSequenceNumber
+			input.SequenceNumber = aws.String(checkpoint.SequenceNumber)
+		}
+		out, err := w.api.GetShardIterator(ctx, input)
+		if err != nil {
+			return false, fmt.Errorf("failed to get shard iterator: %w", err)
+		}
+		iterator = aws.ToString(out.ShardIterator)
+	}
+
+	out, err := w.api.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+		ShardIterator: aws.String(iterator),
+		Limit:         aws.Int32(recordsLimit),
+	})
+	if err != nil {
+		// An expired iterator is replaced from the checkpoint next time.
+		delete(w.iterators, shardId)
+		return false, fmt.Errorf("failed to get records: %w", err)
+	}
+
+	sequenceNumber := checkpoint.SequenceNumber
+	for _, streamRecord := range out.Records {
+		rec, err := RecordFromStream(streamRecord)
+		if err == nil {
+			err = w.processor.Process(rec)
+		}
+		if err != nil && w.giveUp(shardId, streamRecord, err) {
+			sequenceNumber = aws.ToString(streamRecord.Dynamodb.SequenceNumber)
+			continue
+		}
+		if err != nil {
+			// Start over after the last processed record next time.
+			delete(w.iterators, shardId)
+			if sequenceNumber != checkpoint.SequenceNumber {
+				if cerr := w.putCheckpoint(shardId, sequenceNumber, false); cerr != nil {
+					return false, errors.Join(err, cerr)
+				}
+			}
+			return false, err
+		}
+		delete(w.failures, shardId)
+		sequenceNumber = rec.SequenceNumber
+	}
+
+	done := out.NextShardIterator == nil
+	if done {
+		delete(w.iterators, shardId)
+	} else {
+		w.iterators[shardId] = aws.ToString(out.NextShardIterator)
+	}
+	if done || sequenceNumber != checkpoint.SequenceNumber {
+		if err := w.putCheckpoint(shardId, sequenceNumber, done); err != nil {
+			return false, err
+		}
+	}
+	return done, nil
+}
+
+// giveUp counts a failure of the record and reports whether it has now
+// failed maxAttempts times, in which case it is logged to be skipped. A
+// record without a sequence number cannot be skipped past.
+func (w *Worker) giveUp(shardId string, streamRecord streamstypes.Record, err error) bool {
+	if streamRecord.Dynamodb == nil || streamRecord.Dynamodb.SequenceNumber == nil {
+		return false
+	}
+	sequenceNumber := *streamRecord.Dynamodb.SequenceNumber
+
+	failure := w.failures[shardId]
+	if failure.sequenceNumber != sequenceNumber {
+		failure = recordFailure{sequenceNumber: sequenceNumber}
+	}
+	failure.attempts++
+	if failure.attempts < w.maxAttempts {
+		w.failures[shardId] = failure
+		return false
+	}
+
+	delete(w.failures, shardId)
+	log.Printf("skipping stream record %s of shard %s (%s) after %d attempts: %v",
+		sequenceNumber, shardId, streamRecord.EventName, failure.attempts, err)
+	return true
+}
+
+func (w *Worker) putCheckpoint(shardId string, sequenceNumber string, finished bool) error {
+	err := w.checkpoints.PutCheckpoint(dynamo.StreamCheckpointItem{
+		ShardId:        shardId,
+		SequenceNumber: sequenceNumber,
+		Finished:       finished,
+		UpdatedAt:      dynamo.GetUnixMillsecound(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package commentstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/memory"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamstypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+const (
+	testStreamArn   = "arn:aws:dynamodb:local:0:table/Comment/stream/test"
+	testSiteDomain  = "https://example.com"
+	testMaxAttempts = 3
+)
+
+// fakeShard is a shard of fakeStream. Records are appended as the test goes.
+type fakeShard struct {
+	id       string
+	parentId string
+	records  []streamstypes.Record
+	closed   bool
+}
+
+// fakeStream serves synthetic records through the DynamoDB Streams API.
+// Shard iterators are "shardId/position".
+type fakeStream struct {
+	shards       []*fakeShard
+	nextSequence int
+}
+
+func (s *fakeStream) shard(id string) *fakeShard {
+	for _, shard := range s.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+func (s *fakeStream) addShard(id string, parentId string) *fakeShard {
+	shard := &fakeShard{id: id, parentId: parentId}
+	s.shards = append(s.shards, shard)
+	return shard
+}
+
+func (s *fakeStream) append(shard *fakeShard, event string, oldImage *dynamo.CommentItem, newImage *dynamo.CommentItem) {
+	s.nextSequence++
+	record := &streamstypes.StreamRecord{
+		SequenceNumber: aws.String(fmt.Sprintf("%021d", s.nextSequence)),
+		OldImage:       streamImage(oldImage),
+		NewImage:       streamImage(newImage),
+	}
+	shard.records = append(shard.records, streamstypes.Record{
+		EventName: streamstypes.OperationType(event),
+		Dynamodb:  record,
+	})
+}
+
+func streamImage(item *dynamo.CommentItem) map[string]streamstypes.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		panic(err)
+	}
+	return streamMap(av)
+}
+
+// streamMap converts the attribute kinds a CommentItem marshals to.
+func streamMap(av map[string]types.AttributeValue) map[string]streamstypes.AttributeValue {
+	out := map[string]streamstypes.AttributeValue{}
+	for k, v := range av {
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			out[k] = &streamstypes.AttributeValueMemberS{Value: v.Value}
+		case *types.AttributeValueMemberN:
+			out[k] = &streamstypes.AttributeValueMemberN{Value: v.Value}
+		case *types.AttributeValueMemberBOOL:
+			out[k] = &streamstypes.AttributeValueMemberBOOL{Value: v.Value}
+		case *types.AttributeValueMemberM:
+			out[k] = &streamstypes.AttributeValueMemberM{Value: streamMap(v.Value)}
+		default:
+			panic(fmt.Sprintf("unsupported attribute %T", v))
+		}
+	}
+	return out
+}
+
+func (s *fakeStream) DescribeStream(ctx context.Context, params *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	// One shard per page, to exercise paging.
+	start := 0
+	if params.ExclusiveStartShardId != nil {
+		for i, shard := range s.shards {
+			if shard.id == *params.ExclusiveStartShardId {
+				start = i + 1
+			}
+		}
+	}
+	description := &streamstypes.StreamDescription{StreamArn: params.StreamArn}
+	if start < len(s.shards) {
+		shard := s.shards[start]
+		listed := streamstypes.Shard{ShardId: aws.String(shard.id)}
+		if shard.parentId != "" {
+			listed.ParentShardId = aws.String(shard.parentId)
+		}
+		description.Shards = []streamstypes.Shard{listed}
+		if start+1 < len(s.shards) {
+			description.LastEvaluatedShardId = aws.String(shard.id)
+		}
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: description}, nil
+}
+
+func (s *fakeStream) GetShardIterator(ctx context.Context, params *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	shard := s.shard(*params.ShardId)
+	if shard == nil {
+		return nil, fmt.Errorf("no shard %s", *params.ShardId)
+	}
+	position := 0
+	if params.ShardIteratorType == streamstypes.ShardIteratorTypeAfterSequenceNumber {
+		for i, record := range shard.records {
+			if *record.Dynamodb.SequenceNumber == *params.SequenceNumber {
+				position = i + 1
+			}
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{
+		ShardIterator: aws.String(fmt.Sprintf("%s/%d", shard.id, position)),
+	}, nil
+}
+
+func (s *fakeStream) GetRecords(ctx context.Context, params *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	shardId, p, _ := strings.Cut(*params.ShardIterator, "/")
+	position, _ := strconv.Atoi(p)
+	shard := s.shard(shardId)
+
+	end := min(len(shard.records), position+int(aws.ToInt32(params.Limit)))
+	out := &dynamodbstreams.GetRecordsOutput{Records: shard.records[position:end]}
+	if !shard.closed || end < len(shard.records) {
+		out.NextShardIterator = aws.String(fmt.Sprintf("%s/%d", shard.id, end))
+	}
+	return out, nil
+}
+
+// recordingStore counts the records applied to the wrapped store. Inserts of
+// the comment failing fail without being applied.
+type recordingStore struct {
+	dynamo.CommentStreamStore
+	applied []string
+	failing string
+}
+
+func (s *recordingStore) ApplyCommentInsert(item dynamo.CommentItem, siteDomain string) error {
+	s.applied = append(s.applied, "insert "+item.CommentId)
+	if item.CommentId == s.failing {
+		return errors.New("insert failed")
+	}
+	return s.CommentStreamStore.ApplyCommentInsert(item, siteDomain)
+}
+
+func (s *recordingStore) ApplyCommentModify(item dynamo.CommentItem, siteDomain string) error {
+	s.applied = append(s.applied, "modify "+item.CommentId)
+	return s.CommentStreamStore.ApplyCommentModify(item, siteDomain)
+}
+
+func (s *recordingStore) ApplyCommentRemove(item dynamo.CommentItem, siteDomain string) error {
+	s.applied = append(s.applied, "remove "+item.CommentId)
+	return s.CommentStreamStore.ApplyCommentRemove(item, siteDomain)
+}
+
+type harness struct {
+	db          *memory.DB
+	stream      *fakeStream
+	store       *recordingStore
+	checkpoints *memory.StreamCheckpointRepository
+}
+
+func newHarness() *harness {
+	db := memory.NewDB()
+	return &harness{
+		db:          db,
+		stream:      &fakeStream{},
+		store:       &recordingStore{CommentStreamStore: memory.NewCommentTransactionRepository(db)},
+		checkpoints: memory.NewStreamCheckpointRepository(db),
+	}
+}
+
+// worker returns a new worker, as after a restart.
+func (h *harness) worker() *Worker {
+	return NewWorker(h.stream, testStreamArn, NewProcessor(h.store), h.checkpoints, 0, testMaxAttempts)
+}
+
+func (h *harness) poll(t *testing.T, w *Worker) {
+	t.Helper()
+	if err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+}
+
+func (h *harness) assertDerived(t *testing.T, commentIds []string, commentCount int, urlCount int) {
+	t.Helper()
+
+	recentGlobal, _, err := memory.NewRecentGlobalCommentRepository(h.db).GetRecentGlobalComment(dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetRecentGlobalComment: %v", err)
+	}
+	var globalIds []string
+	for _, item := range recentGlobal {
+		globalIds = append(globalIds, item.CommentId)
+	}
+	if fmt.Sprint(globalIds) != fmt.Sprint(commentIds) {
+		t.Errorf("RecentGlobalComment = %v, want %v", globalIds, commentIds)
+	}
+
+	recentDomain, _, err := memory.NewRecentDomainCommentRepository(h.db).GetRecentDomainComment(testSiteDomain, dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetRecentDomainComment: %v", err)
+	}
+	if len(recentDomain) != len(commentIds) {
+		t.Errorf("RecentDomainComment has %d rows, want %d", len(recentDomain), len(commentIds))
+	}
+
+	structures, _, err := memory.NewPageStructureRepository(h.db).GetStructureBySiteDomain(testSiteDomain, dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetStructureBySiteDomain: %v", err)
+	}
+	gotCommentCount := 0
+	for _, s := range structures {
+		gotCommentCount += s.CommentCount
+	}
+	if gotCommentCount != commentCount {
+		t.Errorf("PageStructure commentCount = %d, want %d", gotCommentCount, commentCount)
+	}
+
+	globalStructures, _, err := memory.NewPageGlobalStructureRepository(h.db).GetGlobalStructure(dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetGlobalStructure: %v", err)
+	}
+	gotUrlCount := 0
+	for _, s := range globalStructures {
+		gotUrlCount += s.UrlCount
+	}
+	if gotUrlCount != urlCount {
+		t.Errorf("PageGlobalStructure urlCount = %d, want %d", gotUrlCount, urlCount)
+	}
+}
+
+func comment(id string, path string, unixTime int64) *dynamo.CommentItem {
+	return &dynamo.CommentItem{
+		Url:       testSiteDomain + path,
+		UnixTime:  unixTime,
+		Comment:   "comment " + id,
+		CommentId: id,
+		UserID:    "1",
+		Reactions: map[string]int{},
+	}
+}
+
+func TestWorkerMaintainsDerivedTables(t *testing.T) {
+	h := newHarness()
+	shard := h.stream.addShard("shard-1", "")
+
+	a, b, c := comment("a", "/1", 1), comment("b", "/1", 2), comment("c", "/2", 3)
+	h.stream.append(shard, EventInsert, nil, a)
+	h.stream.append(shard, EventInsert, nil, b)
+	h.stream.append(shard, EventInsert, nil, c)
+
+	w := h.worker()
+	h.poll(t, w)
+	h.assertDerived(t, []string{"c", "b", "a"}, 3, 2)
+
+	// A reply only changes the parent's replyCount, which no Recent row holds.
+	replied := *a
+	replied.ReplyCount = 1
+	h.stream.append(shard, EventModify, a, &replied)
+	edited := replied
+	edited.Comment = "edited"
+	h.stream.append(shard, EventModify, &replied, &edited)
+	h.stream.append(shard, EventRemove, b, nil)
+	h.stream.append(shard, EventRemove, c, nil)
+
+	h.poll(t, w)
+	h.assertDerived(t, []string{"a"}, 1, 1)
+
+	recentGlobal, _, _ := memory.NewRecentGlobalCommentRepository(h.db).GetRecentGlobalComment(dynamo.PageRequest{})
+	if recentGlobal[0].Comment != "edited" {
+		t.Errorf("RecentGlobalComment comment = %q, want %q", recentGlobal[0].Comment, "edited")
+	}
+	want := "[insert a insert b insert c modify a remove b remove c]"
+	if got := fmt.Sprint(h.store.applied); got != want {
+		t.Errorf("applied %s, want %s", got, want)
+	}
+}
+
+func TestWorkerRedeliveryIsIdempotent(t *testing.T) {
+	h := newHarness()
+	shard := h.stream.addShard("shard-1", "")
+
+	a, b := comment("a", "/1", 1), comment("b", "/1", 2)
+	h.stream.append(shard, EventInsert, nil, a)
+	h.stream.append(shard, EventInsert, nil, b)
+	h.stream.append(shard, EventRemove, b, nil)
+
+	h.poll(t, h.worker())
+
+	// Lose the checkpoint, as when a worker dies before writing it, so every
+	// record is delivered again.
+	if err := h.checkpoints.PutCheckpoint(dynamo.StreamCheckpointItem{ShardId: "shard-1"}); err != nil {
+		t.Fatalf("PutCheckpoint: %v", err)
+	}
+	h.poll(t, h.worker())
+
+	if len(h.store.applied) != 6 {
+		t.Fatalf("applied %v, want every record twice", h.store.applied)
+	}
+	h.assertDerived(t, []string{"a"}, 1, 1)
+}
+
+func TestWorkerResumesFromCheckpoint(t *testing.T) {
+	h := newHarness()
+	shard := h.stream.addShard("shard-1", "")
+
+	h.stream.append(shard, EventInsert, nil, comment("a", "/1", 1))
+	h.poll(t, h.worker())
+
+	h.stream.append(shard, EventInsert, nil, comment("b", "/1", 2))
+	h.poll(t, h.worker())
+
+	want := "[insert a insert b]"
+	if got := fmt.Sprint(h.store.applied); got != want {
+		t.Errorf("applied %s, want %s", got, want)
+	}
+	checkpoint, ok, err := h.checkpoints.GetCheckpoint("shard-1")
+	if err != nil || !ok {
+		t.Fatalf("GetCheckpoint: %v, %v", ok, err)
+	}
+	if checkpoint.SequenceNumber != *shard.records[1].Dynamodb.SequenceNumber || checkpoint.Finished {
+		t.Errorf("checkpoint = %+v, want the second record and not finished", checkpoint)
+	}
+	h.assertDerived(t, []string{"b", "a"}, 2, 1)
+}
+
+func TestWorkerReadsChildShardAfterParent(t *testing.T) {
+	h := newHarness()
+	parent := h.stream.addShard("shard-1", "")
+	child := h.stream.addShard("shard-2", "shard-1")
+
+	a := comment("a", "/1", 1)
+	h.stream.append(parent, EventInsert, nil, a)
+	h.stream.append(child, EventRemove, a, nil)
+
+	w := h.worker()
+	h.poll(t, w)
+	if want := "[insert a]"; fmt.Sprint(h.store.applied) != want {
+		t.Fatalf("applied %v, want %s while the parent is open", h.store.applied, want)
+	}
+
+	parent.closed = true
+	h.poll(t, w)
+	if want := "[insert a remove a]"; fmt.Sprint(h.store.applied) != want {
+		t.Fatalf("applied %v, want %s", h.store.applied, want)
+	}
+	checkpoint, _, _ := h.checkpoints.GetCheckpoint("shard-1")
+	if !checkpoint.Finished {
+		t.Errorf("parent checkpoint = %+v, want finished", checkpoint)
+	}
+	h.assertDerived(t, nil, 0, 0)
+}
+
+func TestWorkerSkipsRecordAfterMaxAttempts(t *testing.T) {
+	h := newHarness()
+	shard := h.stream.addShard("shard-1", "")
+	h.store.failing = "a"
+
+	h.stream.append(shard, EventInsert, nil, comment("a", "/1", 1))
+	h.stream.append(shard, EventInsert, nil, comment("b", "/1", 2))
+
+	w := h.worker()
+	for i := 1; i < testMaxAttempts; i++ {
+		if err := w.Poll(context.Background()); err == nil {
+			t.Fatalf("poll %d: Poll succeeded, want the failing record's error", i)
+		}
+	}
+	h.assertDerived(t, nil, 0, 0)
+
+	h.poll(t, w)
+	want := "[insert a insert a insert a insert b]"
+	if got := fmt.Sprint(h.store.applied); got != want {
+		t.Errorf("applied %s, want %s", got, want)
+	}
+	checkpoint, _, _ := h.checkpoints.GetCheckpoint("shard-1")
+	if checkpoint.SequenceNumber != *shard.records[1].Dynamodb.SequenceNumber {
+		t.Errorf("checkpoint = %+v, want past the skipped record", checkpoint)
+	}
+	h.assertDerived(t, []string{"b"}, 1, 1)
+}
+
+func TestWorkerRemovesCommentWithoutPageStructureRow(t *testing.T) {
+	h := newHarness()
+	shard := h.stream.addShard("shard-1", "")
+
+	a, b := comment("a", "/1", 1), comment("b", "/2", 2)
+	h.stream.append(shard, EventInsert, nil, a)
+	h.stream.append(shard, EventInsert, nil, b)
+	w := h.worker()
+	h.poll(t, w)
+
+	// Drift: the page of a has lost its PageStructure row.
+	structures := memory.NewPageStructureRepository(h.db)
+	if err := structures.DeleteStructure(testSiteDomain, a.Url); err != nil {
+		t.Fatalf("DeleteStructure: %v", err)
+	}
+
+	h.stream.append(shard, EventRemove, a, nil)
+	h.stream.append(shard, EventRemove, b, nil)
+	h.poll(t, w)
+
+	// The removal of a leaves the domain's urlCount to reconcile.
+	h.assertDerived(t, nil, 0, 1)
+}
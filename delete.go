@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"pageknock-backend/dynamo"
+)
+
+// loadAdminUserIds reads ADMIN_USER_IDS, the comma-separated accounts that
+// hold the admin role whatever the role table says.
+func loadAdminUserIds() map[string]bool {
+	admins := map[string]bool{}
+	for _, userId := range splitList(os.Getenv("ADMIN_USER_IDS")) {
+		admins[userId] = true
+	}
+	return admins
+}
+
+// handleDeleteComment deletes a comment on behalf of its author or anyone
+// allowed to remove comments on the site.
+// The comment is identified by its url and unixTime, and its commentId must
+// match so a stale client cannot delete a different comment.
+func handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	identity, err := authenticator.Identify(w, r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	var req struct {
+		Url       string `json:"url"`
+		UnixTime  int64  `json:"unixTime"`
+		CommentId string `json:"commentId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"url": req.Url != "", "unixTime": req.UnixTime != 0, "commentId": req.CommentId != ""}) {
+		return
+	}
+
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(req.Url)
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
+	}
+
+	domain, err := dynamo.GetDomainWithScheme(canonicalUrl)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("URL conversion failed: %w", err))
+		return
+	}
+
+	comment, ok, err := commentRepo.GetComment(canonicalUrl, req.UnixTime)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+		return
+	}
+	if !ok || comment.CommentId != req.CommentId {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+	// actor is set when the caller removes someone else's comment, which is
+	// an admin action and audited as such.
+	var actor *adminActor
+	if comment.UserID != identity.UserID {
+		found, ok, err := lookupActor(identity.UserID)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch role: %w", err))
+			return
+		}
+		if !ok || !found.can(permRemoveComment, domain) {
+			writeError(w, r, http.StatusForbidden, codeForbidden)
+			return
+		}
+		actor = &found
+	}
+
+	now := dynamo.GetUnixMillsecound()
+	logItem := dynamo.CommentLogItem{
+		GlobalKey: "GLOBAL",
+		LogId:     dynamo.CommentLogId(now, comment.CommentId, "delete"),
+		UnixTime:  now,
+		CommentId: comment.CommentId,
+		Ip:        clientIp(r),
+		UserAgent: dynamo.GetUserAgent(r),
+		Action:    "delete",
+		UserID:    identity.UserID,
+	}
+
+	if actor != nil && !writeAudit(w, r, *actor, "comment.remove", comment.CommentId, "") {
+		return
+	}
+
+	err = commentTransactionRepo.DeleteComment(comment, domain, logItem)
+	if err != nil && actor != nil {
+		auditFailure(r, *actor, "comment.remove", comment.CommentId)
+	}
+	var canceled *dynamo.TransactionCanceledError
+	if errors.As(err, &canceled) && canceled.ConditionFailed(0) {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+	if errors.Is(err, dynamo.ErrTransactionCanceled) {
+		writeError(w, r, http.StatusConflict, codeConflict)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	resp := map[string]string{
+		"message": "Delete succeeded!",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"pageknock-backend/auth"
+	"pageknock-backend/dynamo"
+)
+
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, auth.ErrMissingToken) || errors.Is(err, auth.ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pageknock"`)
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized)
+		return
+	}
+	writeInternalError(w, r, fmt.Errorf("failed to authenticate: %w", err))
+}
+
+// handleGetDevice returns the caller's pseudonymous device ID, issuing one on
+// first contact.
+func handleGetDevice(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	resp := map[string]string{
+		"deviceId": authenticator.Device(w, r),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleLinkDevice links the caller's device to the account of their bearer
+// token and moves every comment posted under the device ID to that account.
+// It is idempotent, so a client can call it again after posting more
+// comments from a device before the link existed.
+func handleLinkDevice(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	userId, err := authenticator.BearerUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	deviceId, ok := authenticator.PresentedDevice(r)
+	if !ok {
+		writeInvalidField(w, r, "X-Device-Id", fieldRequired)
+		return
+	}
+
+	link, linked, err := deviceLinkRepo.GetDeviceLink(deviceId)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch device link: %w", err))
+		return
+	}
+	if linked && link.UserID != userId {
+		writeError(w, r, http.StatusConflict, codeDeviceAlreadyLinked)
+		return
+	}
+
+	if !linked {
+		err = deviceLinkRepo.PutDeviceLink(dynamo.DeviceLinkItem{
+			DeviceId: deviceId,
+			UserID:   userId,
+			UnixTime: dynamo.GetUnixMillsecound(),
+		})
+		if errors.Is(err, dynamo.ErrConditionFailed) {
+			// Another request linked the device first; only a link to this
+			// same account may go on.
+			link, linked, err = deviceLinkRepo.GetDeviceLink(deviceId)
+			if err != nil {
+				writeInternalError(w, r, fmt.Errorf("failed to fetch device link: %w", err))
+				return
+			}
+			if !linked || link.UserID != userId {
+				writeError(w, r, http.StatusConflict, codeDeviceAlreadyLinked)
+				return
+			}
+		} else if err != nil {
+			writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+			return
+		}
+	}
+
+	migrated, err := migrateDeviceComments(deviceId, userId)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to migrate comments: %w", err))
+		return
+	}
+
+	resp := map[string]any{
+		"userId":        userId,
+		"deviceId":      deviceId,
+		"migratedCount": migrated,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// migrateDeviceComments reassigns every comment of deviceId to userId. All
+// comments are listed before any is changed, so that the updates do not move
+// items under the paging of the userId index.
+func migrateDeviceComments(deviceId string, userId string) (int, error) {
+	var comments []dynamo.CommentItem
+	page := dynamo.PageRequest{}
+	for {
+		items, lastKey, err := commentRepo.GetCommentsByUserID(deviceId, page)
+		if err != nil {
+			return 0, err
+		}
+		comments = append(comments, items...)
+		if len(lastKey) == 0 {
+			break
+		}
+		page.StartKey = lastKey
+	}
+
+	migrated := 0
+	for _, comment := range comments {
+		siteDomain, err := dynamo.GetDomainWithScheme(comment.Url)
+		if err != nil {
+			return migrated, err
+		}
+		err = commentTransactionRepo.ReassignCommentUser(comment, siteDomain, userId)
+		if errors.Is(err, dynamo.ErrTransactionCanceled) {
+			// The comment no longer exists as listed; nothing to move.
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,63 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// AdminAuditRepository appends to the admin audit log. Entries are put under
+// the condition that they do not exist yet, so none is ever overwritten.
+// Deployments should also deny UpdateItem and DeleteItem on the table.
+type AdminAuditRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewAdminAuditRepository(client *dynamodb.Client, tableName string) *AdminAuditRepository {
+	return &AdminAuditRepository{client: client, tableName: tableName}
+}
+
+func (r *AdminAuditRepository) PutAdminAudit(item AdminAuditItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(r.tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(auditId)"),
+	})
+	return err
+}
+
+func (r *AdminAuditRepository) GetAdminAudit(page PageRequest) ([]AdminAuditItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("globalKey = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: "GLOBAL"},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var entries []AdminAuditItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &entries)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return entries, out.LastEvaluatedKey, nil
+}
@@ -0,0 +1,103 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type BanRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewBanRepository(client *dynamodb.Client, tableName string) *BanRepository {
+	return &BanRepository{client: client, tableName: tableName}
+}
+
+func (r *BanRepository) PutBan(item BanItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *BanRepository) GetBan(kind string, value string) (BanItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"kind":  &types.AttributeValueMemberS{Value: kind},
+			"value": &types.AttributeValueMemberS{Value: value},
+		},
+	})
+	if err != nil {
+		return BanItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return BanItem{}, false, nil
+	}
+
+	var item BanItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return BanItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+func (r *BanRepository) GetBans(kind string, page PageRequest) ([]BanItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("#kind = :u"),
+		ExpressionAttributeNames: map[string]string{
+			"#kind": "kind",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: kind},
+		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var bans []BanItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &bans)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return bans, out.LastEvaluatedKey, nil
+}
+
+func (r *BanRepository) DeleteBan(kind string, value string) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"kind":  &types.AttributeValueMemberS{Value: kind},
+			"value": &types.AttributeValueMemberS{Value: value},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete ban: %w", err)
+	}
+
+	return nil
+}
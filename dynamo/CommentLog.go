@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 type CommentLogRepository struct {
@@ -30,3 +31,31 @@ func (r *CommentLogRepository) PutCommentLog(item CommentLogItem) error {
 	})
 	return err
 }
+
+// GetCommentLog returns the "post" log row of commentId, which has the
+// comment's unixTime.
+func (r *CommentLogRepository) GetCommentLog(unixTime int64, commentId string) (CommentLogItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"logId":     &types.AttributeValueMemberS{Value: CommentLogId(unixTime, commentId, "post")},
+		},
+	})
+	if err != nil {
+		return CommentLogItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return CommentLogItem{}, false, nil
+	}
+
+	var item CommentLogItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return CommentLogItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
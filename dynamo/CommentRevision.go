@@ -0,0 +1,48 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CommentRevisionRepository reads the revision history of edited comments.
+// Revisions are written by CommentTransactionRepository.EditComment.
+type CommentRevisionRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewCommentRevisionRepository(client *dynamodb.Client, tableName string) *CommentRevisionRepository {
+	return &CommentRevisionRepository{client: client, tableName: tableName}
+}
+
+func (r *CommentRevisionRepository) GetCommentRevisions(commentId string, page PageRequest) ([]CommentRevisionItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("commentId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: commentId},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var revisions []CommentRevisionItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &revisions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return revisions, out.LastEvaluatedKey, nil
+}
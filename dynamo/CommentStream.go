@@ -0,0 +1,161 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// appliedEventRetention is how long AppliedCommentEvent markers are kept. It
+// exceeds the 24 hours DynamoDB Streams keeps a record, after which no record
+// can be delivered again.
+const appliedEventRetention = 7 * 24 * time.Hour
+
+// appliedEventPut marks event of commentId as applied, under the condition
+// that it was not yet.
+func (r *CommentTransactionRepository) appliedEventPut(commentId string, event string) (types.TransactWriteItem, error) {
+	now := time.Now()
+	av, err := attributevalue.MarshalMap(AppliedCommentEventItem{
+		CommentId: commentId,
+		Event:     event,
+		UnixTime:  now.UnixMilli(),
+		ExpiresAt: now.Add(appliedEventRetention).Unix(),
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("failed to marshal: %w", err)
+	}
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(r.tables.AppliedCommentEvent),
+			Item:                av,
+			ConditionExpression: aws.String("attribute_not_exists(commentId)"),
+		},
+	}, nil
+}
+
+// alreadyApplied reports whether err is the cancellation of a transaction
+// whose first item, the applied marker, already existed.
+func alreadyApplied(err error) bool {
+	var canceled *TransactionCanceledError
+	return errors.As(err, &canceled) && canceled.ConditionFailed(0)
+}
+
+// recentPuts returns the puts of a comment's Recent* rows, conditioned by
+// condition unless it is empty.
+func (r *CommentTransactionRepository) recentPuts(item CommentItem, siteDomain string, condition string) ([]types.TransactWriteItem, error) {
+	recentGlobal, recentDomain := RecentCommentItemsFromComment(item, siteDomain)
+
+	var items []types.TransactWriteItem
+	for _, p := range []struct {
+		tableName string
+		item      any
+	}{
+		{r.tables.RecentGlobalComment, recentGlobal},
+		{r.tables.RecentDomainComment, recentDomain},
+	} {
+		av, err := attributevalue.MarshalMap(p.item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal: %w", err)
+		}
+		put := &types.Put{
+			TableName: aws.String(p.tableName),
+			Item:      av,
+		}
+		if condition != "" {
+			put.ConditionExpression = aws.String(condition)
+			put.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":id": &types.AttributeValueMemberS{Value: item.CommentId},
+			}
+		}
+		items = append(items, types.TransactWriteItem{Put: put})
+	}
+	return items, nil
+}
+
+// structureAddition returns the records transactWithStructureAddition needs
+// to count one comment posted on url at unixTime.
+func structureAddition(url string, siteDomain string, unixTime int64) AllTableRecords {
+	return AllTableRecords{
+		PageStructureItem: PageStructureItem{
+			SiteDomain:     siteDomain,
+			Url:            url,
+			LatestUnixTime: unixTime,
+			CommentCount:   1,
+		},
+		PageGlobalStructureItem: PageGlobalStructureItem{
+			GlobalKey:  "GLOBAL",
+			SiteDomain: siteDomain,
+			UrlCount:   1,
+		},
+	}
+}
+
+// ApplyCommentInsert writes the Recent* rows of a new comment and counts it in
+// PageStructure and PageGlobalStructure, like PutAllTableRecords, together
+// with its applied marker. A comment whose insert was applied already is
+// skipped.
+func (r *CommentTransactionRepository) ApplyCommentInsert(item CommentItem, siteDomain string) error {
+	marker, err := r.appliedEventPut(item.CommentId, "insert")
+	if err != nil {
+		return err
+	}
+	puts, err := r.recentPuts(item, siteDomain, "")
+	if err != nil {
+		return err
+	}
+
+	records := structureAddition(item.Url, siteDomain, item.UnixTime)
+	err = r.transactWithStructureAddition(append([]types.TransactWriteItem{marker}, puts...), records)
+	if alreadyApplied(err) {
+		return nil
+	}
+	return err
+}
+
+// ApplyCommentModify rewrites the Recent* rows of a changed comment. Rows
+// that are missing or hold another comment from the same millisecond are left
+// alone, so a modification delivered after the comment's removal does not
+// bring it back.
+func (r *CommentTransactionRepository) ApplyCommentModify(item CommentItem, siteDomain string) error {
+	puts, err := r.recentPuts(item, siteDomain, "commentId = :id")
+	if err != nil {
+		return err
+	}
+
+	return r.transactWriteSkippingRecent(puts, 0)
+}
+
+// ApplyCommentRemove deletes the Recent* rows of a removed comment and
+// uncounts it in PageStructure and PageGlobalStructure, like DeleteComment,
+// together with its applied marker. A comment whose removal was applied
+// already is skipped.
+func (r *CommentTransactionRepository) ApplyCommentRemove(item CommentItem, siteDomain string) error {
+	marker, err := r.appliedEventPut(item.CommentId, "remove")
+	if err != nil {
+		return err
+	}
+
+	items := []types.TransactWriteItem{marker}
+	for _, k := range r.recentRowKeys(item.UnixTime, siteDomain) {
+		items = append(items, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName:           aws.String(k.tableName),
+				Key:                 k.key,
+				ConditionExpression: aws.String("commentId = :id"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":id": &types.AttributeValueMemberS{Value: item.CommentId},
+				},
+			},
+		})
+	}
+
+	err = r.transactWithStructureRemoval(items, 1, item.Url, siteDomain)
+	if alreadyApplied(err) {
+		return nil
+	}
+	return err
+}
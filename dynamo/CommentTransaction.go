@@ -0,0 +1,992 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrTransactionCanceled is returned when DynamoDB cancels a transaction.
+// Nothing from the transaction has been written in that case.
+var ErrTransactionCanceled = errors.New("transaction canceled")
+
+// TransactionCanceledError carries the cancellation reason code of every item
+// of a canceled transaction, in request order ("None" for items that were
+// not at fault).
+type TransactionCanceledError struct {
+	Reasons []string
+	Message string
+}
+
+func (e *TransactionCanceledError) Error() string {
+	var reasons []string
+	for i, code := range e.Reasons {
+		if code != "" && code != "None" {
+			reasons = append(reasons, fmt.Sprintf("item %d: %s", i, code))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("%v: %s", ErrTransactionCanceled, e.Message)
+	}
+	return fmt.Sprintf("%v: %s", ErrTransactionCanceled, strings.Join(reasons, ", "))
+}
+
+func (e *TransactionCanceledError) Is(target error) bool {
+	return target == ErrTransactionCanceled
+}
+
+// ConditionFailed reports whether item i was canceled by its condition.
+func (e *TransactionCanceledError) ConditionFailed(i int) bool {
+	return i < len(e.Reasons) && e.Reasons[i] == "ConditionalCheckFailed"
+}
+
+// maxStructureAttempts bounds how often PutAllTableRecords switches between
+// the new-URL and existing-URL transactions when a concurrent writer keeps
+// changing whether the PageStructure row exists.
+const maxStructureAttempts = 3
+
+type TableNames struct {
+	Comment             string
+	CommentLog          string
+	PageGlobalStructure string
+	PageStructure       string
+	RecentDomainComment string
+	RecentGlobalComment string
+	CommentRevision     string
+	Reaction            string
+	Report              string
+	ModerationQueue     string
+	AppliedCommentEvent string
+	Outbox              string
+}
+
+// CommentTransactionRepository writes to several tables in a single
+// TransactWriteItems call so a comment is either stored everywhere or nowhere.
+type CommentTransactionRepository struct {
+	client *dynamodb.Client
+	tables TableNames
+	// streamDerived leaves PageStructure, PageGlobalStructure and the Recent*
+	// tables to the stream worker (see the Apply methods): the writes of the
+	// other methods stop at the Comment table and their own tables.
+	streamDerived bool
+	// outbox leaves PageStructure and PageGlobalStructure to the outbox
+	// worker: PutAllTableRecords and DeleteComment record an OutboxTaskItem
+	// in their transaction instead of writing the counters, which every
+	// comment on a page or domain contends for.
+	outbox bool
+}
+
+func NewCommentTransactionRepository(client *dynamodb.Client, tables TableNames) *CommentTransactionRepository {
+	return &CommentTransactionRepository{client: client, tables: tables}
+}
+
+// NewStreamDerivedCommentTransactionRepository returns a repository for
+// servers whose derived tables are maintained by cmd/streamworker.
+func NewStreamDerivedCommentTransactionRepository(client *dynamodb.Client, tables TableNames) *CommentTransactionRepository {
+	return &CommentTransactionRepository{client: client, tables: tables, streamDerived: true}
+}
+
+// NewOutboxCommentTransactionRepository returns a repository for servers
+// whose page counters are maintained by the outbox worker.
+func NewOutboxCommentTransactionRepository(client *dynamodb.Client, tables TableNames) *CommentTransactionRepository {
+	return &CommentTransactionRepository{client: client, tables: tables, outbox: true}
+}
+
+// PutAllTableRecords stores a new comment in every table and, for a reply,
+// counts it on the parent, which must still exist. The Comment row is put
+// under the condition that no comment on the URL has the same unixTime; if
+// one has, the TransactionCanceledError fails item 0. The PageStructure
+// row is updated under the condition that it exists; if it does not, the
+// transaction is retried creating it under the condition that it still does
+// not exist, together with the PageGlobalStructure urlCount increment. The
+// conditions make the new-URL decision atomic, so urlCount counts distinct
+// URLs even when first comments on a URL race.
+func (r *CommentTransactionRepository) PutAllTableRecords(records AllTableRecords) error {
+	items, err := r.commentPuts(records)
+	if err != nil {
+		return err
+	}
+	if r.streamDerived {
+		return r.transactWrite(items)
+	}
+	if r.outbox {
+		task, err := r.outboxTaskPut(OutboxStructureAdd, records.CommentItem, records.PageStructureItem.SiteDomain)
+		if err != nil {
+			return err
+		}
+		return r.transactWrite(append(items, task))
+	}
+
+	return r.transactWithStructureAddition(items, records)
+}
+
+// transactWithStructureAddition writes items together with the PageStructure
+// and PageGlobalStructure writes counting records.PageStructureItem, as
+// described on PutAllTableRecords.
+func (r *CommentTransactionRepository) transactWithStructureAddition(items []types.TransactWriteItem, records AllTableRecords) error {
+	return r.retryStructureAddition(r.transactWrite, items, records)
+}
+
+// retryStructureAddition is transactWithStructureAddition sending each
+// transaction with transact.
+func (r *CommentTransactionRepository) retryStructureAddition(transact func([]types.TransactWriteItem) error, items []types.TransactWriteItem, records AllTableRecords) error {
+	var err error
+	structureIndex := len(items)
+
+	newURL := false
+	for attempt := 0; attempt < maxStructureAttempts; attempt++ {
+		var structureItems []types.TransactWriteItem
+		if newURL {
+			structureItems, err = r.newStructureWrites(records)
+			if err != nil {
+				return err
+			}
+		} else {
+			structureItems = r.existingStructureWrites(records)
+		}
+
+		err = transact(append(items[:structureIndex:structureIndex], structureItems...))
+
+		var canceled *TransactionCanceledError
+		if errors.As(err, &canceled) && canceled.ConditionFailed(structureIndex) {
+			newURL = !newURL
+			continue
+		}
+		return err
+	}
+
+	return err
+}
+
+func (r *CommentTransactionRepository) commentPuts(records AllTableRecords) ([]types.TransactWriteItem, error) {
+	var items []types.TransactWriteItem
+
+	puts := []struct {
+		tableName string
+		item      any
+		condition string
+	}{
+		{r.tables.Comment, records.CommentItem, "attribute_not_exists(url)"},
+		{r.tables.RecentGlobalComment, records.RecentGlobalCommentItem, ""},
+		{r.tables.RecentDomainComment, records.RecentDomainCommentItem, ""},
+		{r.tables.CommentLog, records.CommentLogItem, ""},
+	}
+	if r.streamDerived {
+		// Comment and CommentLog only.
+		puts = slices.Delete(puts, 1, 3)
+	}
+	for _, p := range puts {
+		av, err := attributevalue.MarshalMap(p.item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal: %w", err)
+		}
+		put := &types.Put{
+			TableName: aws.String(p.tableName),
+			Item:      av,
+		}
+		if p.condition != "" {
+			put.ConditionExpression = aws.String(p.condition)
+		}
+		items = append(items, types.TransactWriteItem{Put: put})
+	}
+
+	if comment := records.CommentItem; comment.ParentCommentId != "" {
+		items = append(items, r.replyCountUpdate(comment, 1))
+	}
+
+	return items, nil
+}
+
+// replyCountUpdate changes the replyCount of reply's parent by delta, under
+// the condition that the parent still exists.
+func (r *CommentTransactionRepository) replyCountUpdate(reply CommentItem, delta int) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(r.tables.Comment),
+			Key: map[string]types.AttributeValue{
+				"url":      &types.AttributeValueMemberS{Value: reply.Url},
+				"unixTime": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", reply.ParentUnixTime)},
+			},
+			ConditionExpression: aws.String("commentId = :id"),
+			UpdateExpression:    aws.String("ADD replyCount :inc"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id":  &types.AttributeValueMemberS{Value: reply.ParentCommentId},
+				":inc": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", delta)},
+			},
+		},
+	}
+}
+
+// existingStructureWrites bumps the comment count of a page that already has
+// a PageStructure row.
+func (r *CommentTransactionRepository) existingStructureWrites(records AllTableRecords) []types.TransactWriteItem {
+	structure := records.PageStructureItem
+	return []types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName: aws.String(r.tables.PageStructure),
+			Key: map[string]types.AttributeValue{
+				"siteDomain": &types.AttributeValueMemberS{Value: structure.SiteDomain},
+				"url":        &types.AttributeValueMemberS{Value: structure.Url},
+			},
+			ConditionExpression: aws.String("attribute_exists(siteDomain)"),
+			UpdateExpression:    aws.String("ADD #c :inc SET #t = :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#c": "commentCount",
+				"#t": "latestUnixTime",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":inc": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", structure.CommentCount)},
+				":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", structure.LatestUnixTime)},
+			},
+		},
+	}}
+}
+
+// newStructureWrites creates the PageStructure row of a page's first comment
+// and counts the page in PageGlobalStructure.
+func (r *CommentTransactionRepository) newStructureWrites(records AllTableRecords) ([]types.TransactWriteItem, error) {
+	av, err := attributevalue.MarshalMap(records.PageStructureItem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	globalStructure := records.PageGlobalStructureItem
+	return []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName:           aws.String(r.tables.PageStructure),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(siteDomain)"),
+			},
+		},
+		{
+			Update: &types.Update{
+				TableName: aws.String(r.tables.PageGlobalStructure),
+				Key: map[string]types.AttributeValue{
+					"globalKey":  &types.AttributeValueMemberS{Value: globalStructure.GlobalKey},
+					"siteDomain": &types.AttributeValueMemberS{Value: globalStructure.SiteDomain},
+				},
+				UpdateExpression: aws.String("ADD #c :inc"),
+				ExpressionAttributeNames: map[string]string{
+					"#c": "urlCount",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":inc": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", globalStructure.UrlCount)},
+				},
+			},
+		},
+	}, nil
+}
+
+func (r *CommentTransactionRepository) transactWrite(items []types.TransactWriteItem) error {
+	_, err := r.client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		reasons := make([]string, 0, len(canceled.CancellationReasons))
+		for _, reason := range canceled.CancellationReasons {
+			reasons = append(reasons, aws.ToString(reason.Code))
+		}
+		return &TransactionCanceledError{Reasons: reasons, Message: canceled.ErrorMessage()}
+	}
+	if err != nil {
+		return fmt.Errorf("transact write failed: %w", err)
+	}
+
+	return nil
+}
+
+type tableKey struct {
+	tableName string
+	key       map[string]types.AttributeValue
+}
+
+// commentRowKeys returns the keys of the rows holding a comment: Comment,
+// RecentGlobalComment and RecentDomainComment, in that order. With
+// streamDerived only the Comment row is returned.
+func (r *CommentTransactionRepository) commentRowKeys(item CommentItem, siteDomain string) []tableKey {
+	commentKey := tableKey{r.tables.Comment, map[string]types.AttributeValue{
+		"url":      &types.AttributeValueMemberS{Value: item.Url},
+		"unixTime": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", item.UnixTime)},
+	}}
+	if r.streamDerived {
+		return []tableKey{commentKey}
+	}
+	return append([]tableKey{commentKey}, r.recentRowKeys(item.UnixTime, siteDomain)...)
+}
+
+// recentRowKeys returns the keys of a comment's RecentGlobalComment and
+// RecentDomainComment rows.
+func (r *CommentTransactionRepository) recentRowKeys(commentUnixTime int64, siteDomain string) []tableKey {
+	unixTime := &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", commentUnixTime)}
+	return []tableKey{
+		{r.tables.RecentGlobalComment, map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"unixTime":  unixTime,
+		}},
+		{r.tables.RecentDomainComment, map[string]types.AttributeValue{
+			"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
+			"unixTime":   unixTime,
+		}},
+	}
+}
+
+// transactWriteSkippingRecent writes items, of which the items from index
+// optional on are Recent* rows that may be missing or hold another comment
+// from the same millisecond. When the transaction is canceled only by the
+// conditions of such items, it is retried once without them.
+func (r *CommentTransactionRepository) transactWriteSkippingRecent(items []types.TransactWriteItem, optional int) error {
+	err := r.transactWrite(items)
+
+	var canceled *TransactionCanceledError
+	if !errors.As(err, &canceled) {
+		return err
+	}
+	for i := 0; i < optional; i++ {
+		if canceled.ConditionFailed(i) {
+			return err
+		}
+	}
+
+	var retry []types.TransactWriteItem
+	for i, it := range items {
+		if !canceled.ConditionFailed(i) {
+			retry = append(retry, it)
+		}
+	}
+	if len(retry) == 0 {
+		return nil
+	}
+	return r.transactWrite(retry)
+}
+
+// ReassignCommentUser changes the author of a stored comment in the Comment,
+// RecentGlobalComment and RecentDomainComment tables at once. Every update is
+// conditioned on the row still holding this comment; Recent* rows that are
+// missing or hold another comment from the same millisecond are left alone.
+func (r *CommentTransactionRepository) ReassignCommentUser(item CommentItem, siteDomain string, userId string) error {
+	keys := r.commentRowKeys(item, siteDomain)
+
+	var items []types.TransactWriteItem
+	for _, k := range keys {
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:           aws.String(k.tableName),
+				Key:                 k.key,
+				ConditionExpression: aws.String("commentId = :id"),
+				UpdateExpression:    aws.String("SET userId = :user"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":id":   &types.AttributeValueMemberS{Value: item.CommentId},
+					":user": &types.AttributeValueMemberS{Value: userId},
+				},
+			},
+		})
+	}
+
+	return r.transactWriteSkippingRecent(items, 1)
+}
+
+// structureRemoval says how deleting a comment changes the page counters.
+type structureRemoval int
+
+const (
+	// decrementComment lowers commentCount of a page with other comments.
+	decrementComment structureRemoval = iota
+	// removePage deletes the page's last PageStructure row and lowers the
+	// domain's urlCount.
+	removePage
+	// removeDomain deletes both the page's and the domain's last rows.
+	removeDomain
+	// skipStructure leaves the counters alone because the page has no
+	// PageStructure row, as after drift or while an outbox task or stream
+	// record adding it is still pending; reconcile repairs the counts.
+	skipStructure
+)
+
+// DeleteComment removes a comment from the Comment, RecentGlobalComment and
+// RecentDomainComment tables, lowers the page's commentCount and records log
+// in CommentLog, all in one transaction, which also drops the comment from
+// the moderation queue. A reply is uncounted on its parent, if the parent
+// still exists. When the page loses its last comment its PageStructure row
+// is deleted and the domain's urlCount lowered, and the PageGlobalStructure
+// row goes too when that was the domain's last page.
+//
+// Every delete but the moderation queue's is conditioned on the row holding
+// this comment. If the Comment row does not, the TransactionCanceledError is
+// returned; Recent* rows that do not are left alone. Which counter writes
+// apply is decided by conditions on the counters, switching when a
+// concurrent writer changes them, as in PutAllTableRecords. When the page has no PageStructure row the comment is
+// deleted without counter writes. latestUnixTime is not rewound.
+//
+// The comment's revisions are deleted once the transaction has succeeded,
+// as there may be more of them than one transaction can hold.
+func (r *CommentTransactionRepository) DeleteComment(item CommentItem, siteDomain string, log CommentLogItem) error {
+	if err := r.deleteCommentRows(item, siteDomain, log); err != nil {
+		return err
+	}
+	if err := r.deleteRevisions(item.CommentId); err != nil {
+		return fmt.Errorf("comment deleted but not its revisions: %w", err)
+	}
+	return nil
+}
+
+func (r *CommentTransactionRepository) deleteCommentRows(item CommentItem, siteDomain string, log CommentLogItem) error {
+	commentWrites, err := r.commentDeletes(item, siteDomain, log)
+	if err != nil {
+		return err
+	}
+	if r.streamDerived {
+		return r.transactWriteSkippingRecent(commentWrites, 1)
+	}
+	if r.outbox {
+		task, err := r.outboxTaskPut(OutboxStructureRemove, item, siteDomain)
+		if err != nil {
+			return err
+		}
+		return r.transactWriteSkippingRecent(append(commentWrites, task), 1)
+	}
+
+	return r.transactWithStructureRemoval(commentWrites, 1, item.Url, siteDomain)
+}
+
+// batchWriteLimit is the most requests one BatchWriteItem call takes.
+const batchWriteLimit = 25
+
+// maxBatchAttempts bounds the BatchWriteItem calls made for one batch while
+// DynamoDB keeps returning unprocessed items.
+const maxBatchAttempts = 5
+
+// deleteRevisions deletes every CommentRevision row of commentId.
+func (r *CommentTransactionRepository) deleteRevisions(commentId string) error {
+	revisions := NewCommentRevisionRepository(r.client, r.tables.CommentRevision)
+	page := PageRequest{Limit: batchWriteLimit}
+	for {
+		items, lastKey, err := revisions.GetCommentRevisions(commentId, page)
+		if err != nil {
+			return err
+		}
+
+		var requests []types.WriteRequest
+		for _, item := range items {
+			requests = append(requests, types.WriteRequest{
+				DeleteRequest: &types.DeleteRequest{
+					Key: map[string]types.AttributeValue{
+						"commentId": &types.AttributeValueMemberS{Value: item.CommentId},
+						"editedAt":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", item.EditedAt)},
+					},
+				},
+			})
+		}
+		if err := r.batchWrite(r.tables.CommentRevision, requests); err != nil {
+			return err
+		}
+
+		if len(lastKey) == 0 {
+			return nil
+		}
+		page.StartKey = lastKey
+	}
+}
+
+func (r *CommentTransactionRepository) batchWrite(tableName string, requests []types.WriteRequest) error {
+	for attempt := 0; len(requests) > 0; attempt++ {
+		if attempt == maxBatchAttempts {
+			return fmt.Errorf("%d writes left unprocessed", len(requests))
+		}
+		out, err := r.client.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{tableName: requests},
+		})
+		if err != nil {
+			return fmt.Errorf("batch write failed: %w", err)
+		}
+		requests = out.UnprocessedItems[tableName]
+	}
+	return nil
+}
+
+// transactWithStructureRemoval writes commentWrites together with the
+// PageStructure and PageGlobalStructure writes uncounting a comment on url,
+// as described on DeleteComment. A failed condition on one of the first
+// guards commentWrites returns the TransactionCanceledError; the other
+// commentWrites whose conditions fail are dropped.
+func (r *CommentTransactionRepository) transactWithStructureRemoval(commentWrites []types.TransactWriteItem, guards int, url string, siteDomain string) error {
+	var err error
+	removal := decrementComment
+	for attempt := 0; attempt < 2*maxStructureAttempts; attempt++ {
+		structureIndex := len(commentWrites)
+		items := append(commentWrites[:structureIndex:structureIndex], r.structureRemovalWrites(url, siteDomain, removal)...)
+
+		err = r.transactWrite(items)
+
+		var canceled *TransactionCanceledError
+		if !errors.As(err, &canceled) || guardFailed(canceled, guards) {
+			return err
+		}
+
+		// Drop the Recent* rows and the parent's replyCount update whose
+		// condition failed.
+		var kept []types.TransactWriteItem
+		for i, it := range commentWrites {
+			if !canceled.ConditionFailed(i) {
+				kept = append(kept, it)
+			}
+		}
+		commentWrites = kept
+
+		// Both counter conditions fail on a missing PageStructure row, so
+		// after decrementComment and removePage skipStructure is tried, which
+		// in turn fails if the row exists after all.
+		switch {
+		case canceled.ConditionFailed(structureIndex) && removal == decrementComment:
+			removal = removePage
+		case canceled.ConditionFailed(structureIndex) && removal == skipStructure:
+			removal = decrementComment
+		case canceled.ConditionFailed(structureIndex):
+			removal = skipStructure
+		case canceled.ConditionFailed(structureIndex+1) && removal == removePage:
+			removal = removeDomain
+		case canceled.ConditionFailed(structureIndex + 1):
+			removal = removePage
+		}
+	}
+
+	return err
+}
+
+// guardFailed reports whether one of the first guards items of a canceled
+// transaction failed its condition.
+func guardFailed(canceled *TransactionCanceledError, guards int) bool {
+	for i := 0; i < guards; i++ {
+		if canceled.ConditionFailed(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CommentTransactionRepository) commentDeletes(item CommentItem, siteDomain string, log CommentLogItem) ([]types.TransactWriteItem, error) {
+	keys := r.commentRowKeys(item, siteDomain)
+
+	var items []types.TransactWriteItem
+	for _, k := range keys {
+		items = append(items, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName:           aws.String(k.tableName),
+				Key:                 k.key,
+				ConditionExpression: aws.String("commentId = :id"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":id": &types.AttributeValueMemberS{Value: item.CommentId},
+				},
+			},
+		})
+	}
+
+	av, err := attributevalue.MarshalMap(log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %w", err)
+	}
+	items = append(items, types.TransactWriteItem{
+		Put: &types.Put{
+			TableName: aws.String(r.tables.CommentLog),
+			Item:      av,
+		},
+	}, types.TransactWriteItem{
+		Delete: &types.Delete{
+			TableName: aws.String(r.tables.ModerationQueue),
+			Key: map[string]types.AttributeValue{
+				"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+				"commentId": &types.AttributeValueMemberS{Value: item.CommentId},
+			},
+		},
+	})
+
+	if item.ParentCommentId != "" {
+		items = append(items, r.replyCountUpdate(item, -1))
+	}
+
+	return items, nil
+}
+
+func (r *CommentTransactionRepository) structureRemovalWrites(url string, siteDomain string, removal structureRemoval) []types.TransactWriteItem {
+	structureKey := map[string]types.AttributeValue{
+		"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
+		"url":        &types.AttributeValueMemberS{Value: url},
+	}
+	globalKey := map[string]types.AttributeValue{
+		"globalKey":  &types.AttributeValueMemberS{Value: "GLOBAL"},
+		"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
+	}
+
+	decrement := func(tableName string, key map[string]types.AttributeValue, counter string) types.TransactWriteItem {
+		return types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:           aws.String(tableName),
+				Key:                 key,
+				ConditionExpression: aws.String("#c > :one"),
+				UpdateExpression:    aws.String("ADD #c :dec"),
+				ExpressionAttributeNames: map[string]string{
+					"#c": counter,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+					":dec": &types.AttributeValueMemberN{Value: "-1"},
+				},
+			},
+		}
+	}
+	// remove deletes a row counting at most one. With missingOk a missing row
+	// passes too; a delete of it writes nothing.
+	remove := func(tableName string, key map[string]types.AttributeValue, counter string, missingOk bool) types.TransactWriteItem {
+		condition := "#c <= :one"
+		if missingOk {
+			condition = "attribute_not_exists(#c) OR " + condition
+		}
+		return types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName:           aws.String(tableName),
+				Key:                 key,
+				ConditionExpression: aws.String(condition),
+				ExpressionAttributeNames: map[string]string{
+					"#c": counter,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			},
+		}
+	}
+
+	switch removal {
+	case skipStructure:
+		return []types.TransactWriteItem{{
+			ConditionCheck: &types.ConditionCheck{
+				TableName:           aws.String(r.tables.PageStructure),
+				Key:                 structureKey,
+				ConditionExpression: aws.String("attribute_not_exists(siteDomain)"),
+			},
+		}}
+	case removePage:
+		return []types.TransactWriteItem{
+			remove(r.tables.PageStructure, structureKey, "commentCount", false),
+			decrement(r.tables.PageGlobalStructure, globalKey, "urlCount"),
+		}
+	case removeDomain:
+		return []types.TransactWriteItem{
+			remove(r.tables.PageStructure, structureKey, "commentCount", false),
+			// A domain whose PageGlobalStructure row has gone missing
+			// still loses its last page.
+			remove(r.tables.PageGlobalStructure, globalKey, "urlCount", true),
+		}
+	default:
+		return []types.TransactWriteItem{
+			decrement(r.tables.PageStructure, structureKey, "commentCount"),
+		}
+	}
+}
+
+// EditComment replaces the text of a stored comment in the Comment,
+// RecentGlobalComment and RecentDomainComment tables, stores the replaced
+// text as revision and records log in CommentLog, all in one transaction.
+// The Comment row must still hold item's text, so of two concurrent edits
+// only one succeeds and no revision is lost; Recent* rows that are missing or
+// hold another comment from the same millisecond are left alone.
+func (r *CommentTransactionRepository) EditComment(item CommentItem, siteDomain string, text string, revision CommentRevisionItem, log CommentLogItem) error {
+	keys := r.commentRowKeys(item, siteDomain)
+
+	var items []types.TransactWriteItem
+	for i, k := range keys {
+		update := &types.Update{
+			TableName:           aws.String(k.tableName),
+			Key:                 k.key,
+			ConditionExpression: aws.String("commentId = :id"),
+			UpdateExpression:    aws.String("SET #comment = :text, editedAt = :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#comment": "comment",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id":   &types.AttributeValueMemberS{Value: item.CommentId},
+				":text": &types.AttributeValueMemberS{Value: text},
+				":now":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", revision.EditedAt)},
+			},
+		}
+		if i == 0 {
+			update.ConditionExpression = aws.String("commentId = :id AND #comment = :prev")
+			update.ExpressionAttributeValues[":prev"] = &types.AttributeValueMemberS{Value: item.Comment}
+		}
+		items = append(items, types.TransactWriteItem{Update: update})
+	}
+
+	puts := []struct {
+		tableName string
+		item      any
+	}{
+		{r.tables.CommentRevision, revision},
+		{r.tables.CommentLog, log},
+	}
+	for _, p := range puts {
+		av, err := attributevalue.MarshalMap(p.item)
+		if err != nil {
+			return fmt.Errorf("failed to marshal: %w", err)
+		}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(p.tableName),
+				Item:      av,
+			},
+		})
+	}
+
+	return r.transactWriteSkippingRecent(items, 1)
+}
+
+// SetReaction adds (add true) or removes a reaction row and changes the
+// matching counter in the reactions map of the Comment, RecentGlobalComment
+// and RecentDomainComment rows by one, in one transaction. The reaction row
+// is conditioned on not existing (add) or existing (remove), so a reaction
+// is counted once however often it is toggled concurrently; a failed
+// condition on it returns the TransactionCanceledError.
+//
+// Comments written before reactions existed have no reactions map, which
+// cannot be updated in place; for those the Comment row's map is created
+// instead. Recent* rows without a map, or holding another comment, are left
+// alone until cmd/reconcile rewrites them.
+func (r *CommentTransactionRepository) SetReaction(item CommentItem, siteDomain string, reaction ReactionItem, add bool) error {
+	var reactionWrite types.TransactWriteItem
+	delta := -1
+	if add {
+		delta = 1
+		av, err := attributevalue.MarshalMap(reaction)
+		if err != nil {
+			return fmt.Errorf("failed to marshal: %w", err)
+		}
+		reactionWrite.Put = &types.Put{
+			TableName:           aws.String(r.tables.Reaction),
+			Item:                av,
+			ConditionExpression: aws.String("attribute_not_exists(commentId)"),
+		}
+	} else {
+		reactionWrite.Delete = &types.Delete{
+			TableName: aws.String(r.tables.Reaction),
+			Key: map[string]types.AttributeValue{
+				"commentId": &types.AttributeValueMemberS{Value: reaction.CommentId},
+				"key":       &types.AttributeValueMemberS{Value: reaction.Key},
+			},
+			ConditionExpression: aws.String("attribute_exists(commentId)"),
+		}
+	}
+
+	keys := r.commentRowKeys(item, siteDomain)
+
+	counterWrites := make([]types.TransactWriteItem, 0, len(keys))
+	for _, k := range keys {
+		counterWrites = append(counterWrites, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:           aws.String(k.tableName),
+				Key:                 k.key,
+				ConditionExpression: aws.String("commentId = :id AND attribute_exists(reactions)"),
+				UpdateExpression:    aws.String("SET reactions.#t = if_not_exists(reactions.#t, :zero) + :n"),
+				ExpressionAttributeNames: map[string]string{
+					"#t": reaction.Type,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":id":   &types.AttributeValueMemberS{Value: item.CommentId},
+					":zero": &types.AttributeValueMemberN{Value: "0"},
+					":n":    &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", delta)},
+				},
+			},
+		})
+	}
+	createMap := types.TransactWriteItem{
+		Update: &types.Update{
+			TableName:           aws.String(r.tables.Comment),
+			Key:                 keys[0].key,
+			ConditionExpression: aws.String("commentId = :id AND attribute_not_exists(reactions)"),
+			UpdateExpression:    aws.String("SET reactions = :m"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id": &types.AttributeValueMemberS{Value: item.CommentId},
+				":m": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+					reaction.Type: &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", delta)},
+				}},
+			},
+		},
+	}
+
+	commentWrite, recentWrites := counterWrites[0], counterWrites[1:]
+	var err error
+	for attempt := 0; attempt < maxStructureAttempts; attempt++ {
+		items := append([]types.TransactWriteItem{reactionWrite, commentWrite}, recentWrites...)
+		err = r.transactWrite(items)
+
+		var canceled *TransactionCanceledError
+		if !errors.As(err, &canceled) || canceled.ConditionFailed(0) {
+			return err
+		}
+
+		if canceled.ConditionFailed(1) {
+			if commentWrite.Update == createMap.Update {
+				commentWrite = counterWrites[0]
+			} else {
+				commentWrite = createMap
+			}
+		}
+
+		var kept []types.TransactWriteItem
+		for i, it := range recentWrites {
+			if !canceled.ConditionFailed(2 + i) {
+				kept = append(kept, it)
+			}
+		}
+		recentWrites = kept
+	}
+
+	return err
+}
+
+// ReportComment records report and sets the comment's reportCount to one more
+// than item.ReportCount, under the condition that the stored count is still
+// item.ReportCount, and adds or updates the comment's moderation queue entry.
+// With hide the comment is also hidden in the Comment and Recent* rows. The
+// report row is conditioned on not existing, so every reporter counts once.
+// Failed conditions on the report or the Comment row return the
+// TransactionCanceledError (items 1 and 0); the caller re-reads the comment
+// and tries again on the latter.
+func (r *CommentTransactionRepository) ReportComment(item CommentItem, siteDomain string, report ReportItem, hide bool) error {
+	keys := r.commentRowKeys(item, siteDomain)
+	next := item.ReportCount + 1
+	hidden := item.Hidden || hide
+
+	countCondition := "commentId = :id AND reportCount = :seen"
+	if item.ReportCount == 0 {
+		countCondition = "commentId = :id AND (reportCount = :seen OR attribute_not_exists(reportCount))"
+	}
+	countUpdate := "SET reportCount = :next"
+	if hide {
+		countUpdate += ", hidden = :true"
+	}
+	countValues := map[string]types.AttributeValue{
+		":id":   &types.AttributeValueMemberS{Value: item.CommentId},
+		":seen": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", item.ReportCount)},
+		":next": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", next)},
+	}
+	if hide {
+		countValues[":true"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+
+	reportAv, err := attributevalue.MarshalMap(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	queueAv, err := attributevalue.MarshalMap(ModerationQueueItem{
+		GlobalKey:      "GLOBAL",
+		CommentId:      item.CommentId,
+		Url:            item.Url,
+		UnixTime:       item.UnixTime,
+		SiteDomain:     siteDomain,
+		ReportCount:    next,
+		LatestReportAt: report.UnixTime,
+		Hidden:         hidden,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	items := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				TableName:                 aws.String(keys[0].tableName),
+				Key:                       keys[0].key,
+				ConditionExpression:       aws.String(countCondition),
+				UpdateExpression:          aws.String(countUpdate),
+				ExpressionAttributeValues: countValues,
+			},
+		},
+		{
+			Put: &types.Put{
+				TableName:           aws.String(r.tables.Report),
+				Item:                reportAv,
+				ConditionExpression: aws.String("attribute_not_exists(commentId)"),
+			},
+		},
+		{
+			Put: &types.Put{
+				TableName: aws.String(r.tables.ModerationQueue),
+				Item:      queueAv,
+			},
+		},
+	}
+	if hide {
+		for _, k := range keys[1:] {
+			items = append(items, types.TransactWriteItem{
+				Update: &types.Update{
+					TableName:           aws.String(k.tableName),
+					Key:                 k.key,
+					ConditionExpression: aws.String("commentId = :id"),
+					UpdateExpression:    aws.String("SET hidden = :true"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":id":   &types.AttributeValueMemberS{Value: item.CommentId},
+						":true": &types.AttributeValueMemberBOOL{Value: true},
+					},
+				},
+			})
+		}
+	}
+
+	return r.transactWriteSkippingRecent(items, 3)
+}
+
+// ApproveComment unhides a comment in the Comment and Recent* rows, resets
+// its reportCount, removes it from the moderation queue and records log in
+// CommentLog, in one transaction. Past reporters cannot report it again.
+func (r *CommentTransactionRepository) ApproveComment(item CommentItem, siteDomain string, log CommentLogItem) error {
+	logAv, err := attributevalue.MarshalMap(log)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	var items []types.TransactWriteItem
+	for i, k := range r.commentRowKeys(item, siteDomain) {
+		update := &types.Update{
+			TableName:           aws.String(k.tableName),
+			Key:                 k.key,
+			ConditionExpression: aws.String("commentId = :id"),
+			UpdateExpression:    aws.String("REMOVE hidden"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id": &types.AttributeValueMemberS{Value: item.CommentId},
+			},
+		}
+		if i == 0 {
+			update.UpdateExpression = aws.String("SET reportCount = :zero REMOVE hidden")
+			update.ExpressionAttributeValues[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+		}
+		items = append(items, types.TransactWriteItem{Update: update})
+	}
+
+	items = append(items,
+		types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(r.tables.ModerationQueue),
+				Key: map[string]types.AttributeValue{
+					"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+					"commentId": &types.AttributeValueMemberS{Value: item.CommentId},
+				},
+			},
+		},
+		types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(r.tables.CommentLog),
+				Item:      logAv,
+			},
+		},
+	)
+
+	return r.transactWriteSkippingRecent(items, 1)
+}
@@ -0,0 +1,73 @@
+package dynamo
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRetryStructureAddition(t *testing.T) {
+	tests := []struct {
+		name string
+		// failed lists, per attempt, the item whose condition fails; -1
+		// lets the attempt succeed.
+		failed   []int
+		want     []string
+		canceled bool
+	}{
+		{"existing URL", []int{-1}, []string{"existing"}, false},
+		{"new URL", []int{2, -1}, []string{"existing", "new"}, false},
+		{"URL created concurrently", []int{2, 2, -1}, []string{"existing", "new", "existing"}, false},
+		{"attempts exhausted", []int{2, 2, 2}, []string{"existing", "new", "existing"}, true},
+		{"comment key taken", []int{0}, []string{"existing"}, true},
+	}
+
+	r := &CommentTransactionRepository{tables: TableNames{Comment: "Comment", PageStructure: "PageStructure", PageGlobalStructure: "PageGlobalStructure"}}
+	records := GenerateAllTableRecords(BaseFieldDatas{
+		Comment:    "hello",
+		CommentId:  "comment-1",
+		SiteDomain: "https://example.com",
+		Now:        1,
+		Req:        httptest.NewRequest("POST", "/comment", nil),
+		Url:        "https://example.com/a",
+		UserId:     "1",
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := []types.TransactWriteItem{{Put: &types.Put{}}, {Put: &types.Put{}}}
+
+			var got []string
+			transact := func(sent []types.TransactWriteItem) error {
+				structure := sent[len(items)]
+				if structure.Put != nil {
+					got = append(got, "new")
+				} else {
+					got = append(got, "existing")
+				}
+
+				failed := tt.failed[len(got)-1]
+				if failed < 0 {
+					return nil
+				}
+				reasons := make([]string, len(sent))
+				for i := range reasons {
+					reasons[i] = "None"
+				}
+				reasons[failed] = "ConditionalCheckFailed"
+				return &TransactionCanceledError{Reasons: reasons}
+			}
+
+			err := r.retryStructureAddition(transact, items, records)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("attempts = %v, want %v", got, tt.want)
+			}
+			if errors.Is(err, ErrTransactionCanceled) != tt.canceled {
+				t.Errorf("err = %v, want canceled %v", err, tt.canceled)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type DeviceLinkRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewDeviceLinkRepository(client *dynamodb.Client, tableName string) *DeviceLinkRepository {
+	return &DeviceLinkRepository{client: client, tableName: tableName}
+}
+
+// PutDeviceLink stores item if the device is not linked yet, and returns
+// ErrConditionFailed otherwise, so that of two accounts linking the same
+// device concurrently only one succeeds.
+func (r *DeviceLinkRepository) PutDeviceLink(item DeviceLinkItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(r.tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(deviceId)"),
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrConditionFailed
+	}
+	return err
+}
+
+func (r *DeviceLinkRepository) GetDeviceLink(deviceId string) (DeviceLinkItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"deviceId": &types.AttributeValueMemberS{Value: deviceId},
+		},
+	})
+	if err != nil {
+		return DeviceLinkItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return DeviceLinkItem{}, false, nil
+	}
+
+	var item DeviceLinkItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return DeviceLinkItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
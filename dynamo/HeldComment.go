@@ -0,0 +1,118 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// HeldCommentRepository stores comments a filter held for review. They are
+// kept apart from the Comment table until a moderator publishes or discards
+// them.
+type HeldCommentRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewHeldCommentRepository(client *dynamodb.Client, tableName string) *HeldCommentRepository {
+	return &HeldCommentRepository{client: client, tableName: tableName}
+}
+
+func (r *HeldCommentRepository) PutHeldComment(item HeldCommentItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      av,
+	})
+	return err
+}
+
+// GetHeldComments lists held comments, oldest first.
+func (r *HeldCommentRepository) GetHeldComments(page PageRequest) ([]HeldCommentItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("globalKey = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: "GLOBAL"},
+		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var items []HeldCommentItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &items)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return items, out.LastEvaluatedKey, nil
+}
+
+func (r *HeldCommentRepository) GetHeldComment(unixTime int64) (HeldCommentItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"unixTime":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", unixTime)},
+		},
+	})
+	if err != nil {
+		return HeldCommentItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return HeldCommentItem{}, false, nil
+	}
+
+	var item HeldCommentItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return HeldCommentItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+// DeleteHeldComment deletes the held comment stored at unixTime if it is
+// commentId, and returns ErrConditionFailed otherwise, so that a moderator
+// deciding on one comment never removes another held in the same
+// millisecond.
+func (r *HeldCommentRepository) DeleteHeldComment(unixTime int64, commentId string) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"unixTime":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", unixTime)},
+		},
+		ConditionExpression: aws.String("commentId = :c"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":c": &types.AttributeValueMemberS{Value: commentId},
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrConditionFailed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete held comment: %w", err)
+	}
+
+	return nil
+}
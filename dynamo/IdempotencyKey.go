@@ -0,0 +1,86 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type IdempotencyKeyRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewIdempotencyKeyRepository(client *dynamodb.Client, tableName string) *IdempotencyKeyRepository {
+	return &IdempotencyKeyRepository{client: client, tableName: tableName}
+}
+
+// ReserveIdempotencyKey puts item under the condition that its key is free.
+// DynamoDB's TTL deletes expired items only eventually, so they are checked
+// for too.
+func (r *IdempotencyKeyRepository) ReserveIdempotencyKey(item IdempotencyKeyItem, now int64, staleBefore int64) (IdempotencyKeyItem, bool, error) {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return IdempotencyKeyItem{}, false, fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(r.tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(idempotencyKey) OR expiresAt <= :now OR (completed = :false AND createdAt < :stale)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+			":stale": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", staleBefore)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		var existing IdempotencyKeyItem
+		err = attributevalue.UnmarshalMap(conditionFailed.Item, &existing)
+		if err != nil {
+			return IdempotencyKeyItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+		}
+		return existing, false, nil
+	}
+	if err != nil {
+		return IdempotencyKeyItem{}, false, err
+	}
+
+	return item, true, nil
+}
+
+func (r *IdempotencyKeyRepository) PutIdempotencyKey(item IdempotencyKeyItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *IdempotencyKeyRepository) DeleteIdempotencyKey(key string) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"idempotencyKey": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ModerationQueueRepository reads and clears the moderation queue. Entries
+// are created by CommentTransactionRepository.ReportComment.
+type ModerationQueueRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewModerationQueueRepository(client *dynamodb.Client, tableName string) *ModerationQueueRepository {
+	return &ModerationQueueRepository{client: client, tableName: tableName}
+}
+
+func (r *ModerationQueueRepository) GetModerationQueue(page PageRequest) ([]ModerationQueueItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("globalKey = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: "GLOBAL"},
+		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var items []ModerationQueueItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &items)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return items, out.LastEvaluatedKey, nil
+}
+
+func (r *ModerationQueueRepository) GetModerationQueueItem(commentId string) (ModerationQueueItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"commentId": &types.AttributeValueMemberS{Value: commentId},
+		},
+	})
+	if err != nil {
+		return ModerationQueueItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return ModerationQueueItem{}, false, nil
+	}
+
+	var item ModerationQueueItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return ModerationQueueItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+func (r *ModerationQueueRepository) DeleteModerationQueueItem(commentId string) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"commentId": &types.AttributeValueMemberS{Value: commentId},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete moderation queue item: %w", err)
+	}
+
+	return nil
+}
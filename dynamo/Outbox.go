@@ -0,0 +1,358 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxDead    = "dead"
+
+	// OutboxStructureAdd counts a new comment in PageStructure and
+	// PageGlobalStructure.
+	OutboxStructureAdd = "structure.add"
+	// OutboxStructureRemove uncounts a deleted comment.
+	OutboxStructureRemove = "structure.remove"
+)
+
+type OutboxRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewOutboxRepository(client *dynamodb.Client, tableName string) *OutboxRepository {
+	return &OutboxRepository{client: client, tableName: tableName}
+}
+
+func outboxKey(status string, taskId string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"status": &types.AttributeValueMemberS{Value: status},
+		"taskId": &types.AttributeValueMemberS{Value: taskId},
+	}
+}
+
+func attemptsValue(task OutboxTaskItem) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(task.Attempts)}
+}
+
+// conditionFailed maps the failed condition of a single write or of a
+// transaction to ErrConditionFailed.
+func conditionFailed(err error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &conditionFailed) || errors.As(err, &canceled) {
+		return ErrConditionFailed
+	}
+	return err
+}
+
+func (r *OutboxRepository) GetDueOutboxTasks(now int64, limit int32) ([]OutboxTaskItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("#status = :s"),
+		FilterExpression:       aws.String("nextAttemptAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s":   &types.AttributeValueMemberS{Value: OutboxPending},
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
+		},
+		Limit: aws.Int32(limit),
+	}
+
+	// The filter applies after Limit, so read on until limit tasks are due.
+	var tasks []OutboxTaskItem
+	for {
+		out, err := r.client.Query(context.TODO(), input)
+		if err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+
+		var page []OutboxTaskItem
+		err = attributevalue.UnmarshalListOfMaps(out.Items, &page)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal failed: %w", err)
+		}
+		tasks = append(tasks, page...)
+
+		if len(tasks) >= int(limit) {
+			return tasks[:limit], nil
+		}
+		if out.LastEvaluatedKey == nil {
+			return tasks, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+func (r *OutboxRepository) GetOutboxTasks(status string, page PageRequest) ([]OutboxTaskItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("#status = :s"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: status},
+		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var tasks []OutboxTaskItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &tasks)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return tasks, out.LastEvaluatedKey, nil
+}
+
+func (r *OutboxRepository) ClaimOutboxTask(task OutboxTaskItem, nextAttemptAt int64) (OutboxTaskItem, error) {
+	claimed := task
+	claimed.Attempts++
+	claimed.NextAttemptAt = nextAttemptAt
+	claimed.UpdatedAt = GetUnixMillsecound()
+
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(r.tableName),
+		Key:                 outboxKey(OutboxPending, task.TaskId),
+		ConditionExpression: aws.String("attempts = :attempts"),
+		UpdateExpression:    aws.String("SET attempts = :next, nextAttemptAt = :at, updatedAt = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":attempts": attemptsValue(task),
+			":next":     attemptsValue(claimed),
+			":at":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", claimed.NextAttemptAt)},
+			":now":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", claimed.UpdatedAt)},
+		},
+	})
+	if err != nil {
+		return OutboxTaskItem{}, conditionFailed(err)
+	}
+
+	return claimed, nil
+}
+
+func (r *OutboxRepository) FailOutboxTask(task OutboxTaskItem, lastError string) error {
+	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(r.tableName),
+		Key:                 outboxKey(OutboxPending, task.TaskId),
+		ConditionExpression: aws.String("attempts = :attempts"),
+		UpdateExpression:    aws.String("SET lastError = :e, updatedAt = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":attempts": attemptsValue(task),
+			":e":        &types.AttributeValueMemberS{Value: lastError},
+			":now":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", GetUnixMillsecound())},
+		},
+	})
+	return conditionFailed(err)
+}
+
+func (r *OutboxRepository) CompleteOutboxTask(task OutboxTaskItem) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key:       outboxKey(OutboxPending, task.TaskId),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete outbox task: %w", err)
+	}
+
+	return nil
+}
+
+func (r *OutboxRepository) DeadLetterOutboxTask(task OutboxTaskItem, lastError string) error {
+	dead := task
+	dead.Status = OutboxDead
+	dead.LastError = lastError
+	dead.UpdatedAt = GetUnixMillsecound()
+
+	av, err := attributevalue.MarshalMap(dead)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	_, err = r.client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(r.tableName),
+					Key:                 outboxKey(OutboxPending, task.TaskId),
+					ConditionExpression: aws.String("attempts = :attempts"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":attempts": attemptsValue(task),
+					},
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName: aws.String(r.tableName),
+					Item:      av,
+				},
+			},
+		},
+	})
+	return conditionFailed(err)
+}
+
+func (r *OutboxRepository) ReplayOutboxTask(taskId string, now int64) (OutboxTaskItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String(r.tableName),
+		Key:            outboxKey(OutboxDead, taskId),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return OutboxTaskItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return OutboxTaskItem{}, false, nil
+	}
+
+	var task OutboxTaskItem
+	err = attributevalue.UnmarshalMap(out.Item, &task)
+	if err != nil {
+		return OutboxTaskItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	task.Status = OutboxPending
+	task.Attempts = 0
+	task.NextAttemptAt = now
+	task.UpdatedAt = now
+	av, err := attributevalue.MarshalMap(task)
+	if err != nil {
+		return OutboxTaskItem{}, false, fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	_, err = r.client.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(r.tableName),
+					Key:                 outboxKey(OutboxDead, taskId),
+					ConditionExpression: aws.String("attribute_exists(taskId)"),
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName: aws.String(r.tableName),
+					Item:      av,
+				},
+			},
+		},
+	})
+	if errors.Is(conditionFailed(err), ErrConditionFailed) {
+		// Replayed concurrently.
+		return OutboxTaskItem{}, false, nil
+	}
+	if err != nil {
+		return OutboxTaskItem{}, false, fmt.Errorf("transact write failed: %w", err)
+	}
+
+	return task, true, nil
+}
+
+// outboxTaskPut records a task of kind for the comment item, due now.
+func (r *CommentTransactionRepository) outboxTaskPut(kind string, item CommentItem, siteDomain string) (types.TransactWriteItem, error) {
+	now := GetUnixMillsecound()
+	av, err := attributevalue.MarshalMap(OutboxTaskItem{
+		Status:          OutboxPending,
+		TaskId:          GenerateOutboxTaskId(now),
+		Kind:            kind,
+		CommentId:       item.CommentId,
+		Url:             item.Url,
+		SiteDomain:      siteDomain,
+		CommentUnixTime: item.UnixTime,
+		NextAttemptAt:   now,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("failed to marshal: %w", err)
+	}
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName: aws.String(r.tables.Outbox),
+			Item:      av,
+		},
+	}, nil
+}
+
+// ApplyOutboxTask writes the PageStructure and PageGlobalStructure changes of
+// a task, as PutAllTableRecords or DeleteComment would have, together with
+// its applied marker. A task applied already is skipped. The add and remove
+// tasks of one comment may run in either order, the add being retried or
+// replayed later; a remove that runs first marks the add applied and
+// changes no counter, as there is nothing to uncount.
+func (r *CommentTransactionRepository) ApplyOutboxTask(task OutboxTaskItem) error {
+	marker, err := r.appliedEventPut(task.CommentId, task.Kind)
+	if err != nil {
+		return err
+	}
+	items := []types.TransactWriteItem{marker}
+
+	switch task.Kind {
+	case OutboxStructureAdd:
+		err = r.transactWithStructureAddition(items, structureAddition(task.Url, task.SiteDomain, task.CommentUnixTime))
+	case OutboxStructureRemove:
+		err = r.applyOutboxRemoval(marker, task)
+	default:
+		return fmt.Errorf("unknown outbox task kind %q", task.Kind)
+	}
+	if alreadyApplied(err) {
+		return nil
+	}
+	return err
+}
+
+// applyOutboxRemoval applies a structure.remove task, whose applied marker
+// is marker, as described on ApplyOutboxTask.
+func (r *CommentTransactionRepository) applyOutboxRemoval(marker types.TransactWriteItem, task OutboxTaskItem) error {
+	addApplied := types.TransactWriteItem{
+		ConditionCheck: &types.ConditionCheck{
+			TableName: aws.String(r.tables.AppliedCommentEvent),
+			Key: map[string]types.AttributeValue{
+				"commentId": &types.AttributeValueMemberS{Value: task.CommentId},
+				"event":     &types.AttributeValueMemberS{Value: OutboxStructureAdd},
+			},
+			ConditionExpression: aws.String("attribute_exists(commentId)"),
+		},
+	}
+	addMarker, err := r.appliedEventPut(task.CommentId, OutboxStructureAdd)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxStructureAttempts; attempt++ {
+		err = r.transactWithStructureRemoval([]types.TransactWriteItem{marker, addApplied}, 2, task.Url, task.SiteDomain)
+
+		var canceled *TransactionCanceledError
+		if !errors.As(err, &canceled) || !canceled.ConditionFailed(1) {
+			return err
+		}
+
+		// The add has not been applied; it is marked applied instead.
+		err = r.transactWrite([]types.TransactWriteItem{marker, addMarker})
+		if !errors.As(err, &canceled) || !canceled.ConditionFailed(1) {
+			return err
+		}
+		// The add was applied in the meantime.
+	}
+
+	return err
+}
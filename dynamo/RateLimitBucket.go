@@ -0,0 +1,84 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrConditionFailed is returned by conditional writes whose condition did
+// not hold.
+var ErrConditionFailed = errors.New("condition failed")
+
+// RateLimitBucketRepository stores token buckets shared by every server
+// instance. Writes are compare-and-set on version so concurrent takers never
+// both spend the same token.
+type RateLimitBucketRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewRateLimitBucketRepository(client *dynamodb.Client, tableName string) *RateLimitBucketRepository {
+	return &RateLimitBucketRepository{client: client, tableName: tableName}
+}
+
+func (r *RateLimitBucketRepository) GetBucket(bucketKey string) (RateLimitBucketItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"bucketKey": &types.AttributeValueMemberS{Value: bucketKey},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return RateLimitBucketItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return RateLimitBucketItem{}, false, nil
+	}
+
+	var item RateLimitBucketItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return RateLimitBucketItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+// PutBucket writes item if the stored bucket is still the one read: absent
+// when previousVersion is 0, or at previousVersion. Otherwise it returns
+// ErrConditionFailed.
+func (r *RateLimitBucketRepository) PutBucket(item RateLimitBucketItem, previousVersion int64) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(r.tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(bucketKey)"),
+	}
+	if previousVersion != 0 {
+		input.ConditionExpression = aws.String("version = :prev")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":prev": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", previousVersion)},
+		}
+	}
+
+	_, err = r.client.PutItem(context.TODO(), input)
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrConditionFailed
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ReactionRepository reads reaction rows. They are written together with the
+// comment counters by CommentTransactionRepository.SetReaction.
+type ReactionRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewReactionRepository(client *dynamodb.Client, tableName string) *ReactionRepository {
+	return &ReactionRepository{client: client, tableName: tableName}
+}
+
+func (r *ReactionRepository) GetReaction(commentId string, reactionType string, userId string) (ReactionItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"commentId": &types.AttributeValueMemberS{Value: commentId},
+			"key":       &types.AttributeValueMemberS{Value: ReactionKey(reactionType, userId)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return ReactionItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return ReactionItem{}, false, nil
+	}
+
+	var item ReactionItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return ReactionItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
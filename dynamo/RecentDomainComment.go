@@ -32,27 +32,64 @@ func (r *RecentDomainCommentRepository) PutRecentDomainComment(item RecentDomain
 	return err
 }
 
-func (r *RecentDomainCommentRepository) GetRecentDomainComment(siteDomain string) ([]RecentDomainCommentItem, error) {
+func (r *RecentDomainCommentRepository) GetRecentDomainComment(siteDomain string, page PageRequest) ([]RecentDomainCommentItem, map[string]types.AttributeValue, error) {
 	input := &dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
 		KeyConditionExpression: aws.String("siteDomain = :u"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":u": &types.AttributeValueMemberS{Value: siteDomain},
 		},
-		ScanIndexForward: aws.Bool(false),
-		Limit:            aws.Int32(100),
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
 	}
 
 	out, err := r.client.Query(context.TODO(), input)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
 	var comments []RecentDomainCommentItem
 	err = attributevalue.UnmarshalListOfMaps(out.Items, &comments)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshal failed: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
 	}
 
-	return comments, nil
+	return comments, out.LastEvaluatedKey, nil
+}
+
+func (r *RecentDomainCommentRepository) ScanRecentDomainComments(page PageRequest) ([]RecentDomainCommentItem, map[string]types.AttributeValue, error) {
+
+	out, err := r.client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:         aws.String(r.tableName),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("scan failed: %w", err)
+	}
+
+	var records []RecentDomainCommentItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &records)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return records, out.LastEvaluatedKey, nil
+}
+
+func (r *RecentDomainCommentRepository) DeleteRecentDomainComment(siteDomain string, unixTime int64) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
+			"unixTime":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", unixTime)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete recent domain comment: %w", err)
+	}
+
+	return nil
 }
@@ -32,27 +32,44 @@ func (r *RecentGlobalCommentRepository) PutRecentGlobalComment(item RecentGlobal
 	return err
 }
 
-func (r *RecentGlobalCommentRepository) GetRecentGlobalComment() ([]RecentGlobalCommentItem, error) {
+func (r *RecentGlobalCommentRepository) GetRecentGlobalComment(page PageRequest) ([]RecentGlobalCommentItem, map[string]types.AttributeValue, error) {
 	input := &dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
 		KeyConditionExpression: aws.String("globalKey = :u"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":u": &types.AttributeValueMemberS{Value: "GLOBAL"},
 		},
-		ScanIndexForward: aws.Bool(false),
-		Limit:            aws.Int32(100),
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
 	}
 
 	out, err := r.client.Query(context.TODO(), input)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
 	var comments []RecentGlobalCommentItem
 	err = attributevalue.UnmarshalListOfMaps(out.Items, &comments)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshal failed: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
 	}
 
-	return comments, nil
+	return comments, out.LastEvaluatedKey, nil
+}
+
+func (r *RecentGlobalCommentRepository) DeleteRecentGlobalComment(unixTime int64) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"globalKey": &types.AttributeValueMemberS{Value: "GLOBAL"},
+			"unixTime":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", unixTime)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete recent global comment: %w", err)
+	}
+
+	return nil
 }
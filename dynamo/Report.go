@@ -0,0 +1,47 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ReportRepository reads reports. They are written together with the
+// comment's reportCount by CommentTransactionRepository.ReportComment.
+type ReportRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewReportRepository(client *dynamodb.Client, tableName string) *ReportRepository {
+	return &ReportRepository{client: client, tableName: tableName}
+}
+
+func (r *ReportRepository) GetReports(commentId string, page PageRequest) ([]ReportItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("commentId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: commentId},
+		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var reports []ReportItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &reports)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return reports, out.LastEvaluatedKey, nil
+}
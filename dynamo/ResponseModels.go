@@ -5,16 +5,214 @@ type PageGlobalStructureResponse struct {
 	UrlCount   int    `json:"urlCount"`
 }
 
+type PageGlobalStructureListResponse struct {
+	Structures []PageGlobalStructureResponse `json:"structures"`
+	NextCursor string                        `json:"nextCursor,omitempty"`
+}
+
 type PageStructureResponse struct {
 	Url            string `json:"urlCount"`
 	CommentCount   int    `json:"commentCount"`
 	LatestUnixTime int64  `json:"latestUnixTime"`
 }
 
+type PageStructureListResponse struct {
+	Structures []PageStructureResponse `json:"structures"`
+	NextCursor string                  `json:"nextCursor,omitempty"`
+}
+
 type RecentGlobalCommentResponse struct {
-	UnixTime  int64  `dynamodbav:"unixTime"` //Sort
-	Comment   string `dynamodbav:"comment"`
-	CommentId string `dynamodbav:"commentId"`
-	Url       string `dynamodbav:"url"`
-	UserID    string `dynamodbav:"userId"`
+	UnixTime  int64          `dynamodbav:"unixTime"` //Sort
+	Comment   string         `dynamodbav:"comment"`
+	CommentId string         `dynamodbav:"commentId"`
+	Url       string         `dynamodbav:"url"`
+	UserID    string         `dynamodbav:"userId"`
+	EditedAt  int64          `dynamodbav:"editedAt"`
+	Reactions map[string]int `dynamodbav:"reactions"`
+}
+
+type CommentResponse struct {
+	UnixTime        int64          `json:"unixTime"`
+	Comment         string         `json:"comment"`
+	CommentId       string         `json:"commentId"`
+	UserID          string         `json:"userId"`
+	EditedAt        int64          `json:"editedAt,omitempty"`
+	ParentCommentId string         `json:"parentCommentId,omitempty"`
+	ReplyCount      int            `json:"replyCount"`
+	Reactions       map[string]int `json:"reactions"`
+}
+
+type CommentListResponse struct {
+	Comments   []CommentResponse `json:"comments"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+type RecentGlobalCommentListResponse struct {
+	Comments   []RecentGlobalCommentResponse `json:"comments"`
+	NextCursor string                        `json:"nextCursor,omitempty"`
+}
+
+type RecentDomainCommentListResponse struct {
+	Comments   []RecentGlobalCommentResponse `json:"comments"`
+	NextCursor string                        `json:"nextCursor,omitempty"`
+}
+
+type CommentRevisionResponse struct {
+	EditedAt int64  `json:"editedAt"`
+	Comment  string `json:"comment"`
+	EditorID string `json:"editorId"`
+}
+
+type CommentRevisionListResponse struct {
+	Revisions  []CommentRevisionResponse `json:"revisions"`
+	NextCursor string                    `json:"nextCursor,omitempty"`
+}
+
+// CommentTreeResponse is a comment with the first page of its replies, oldest
+// first. RepliesCursor continues the replies when there are more. Below the
+// depth limit, or once the request has used up its reply queries, Replies is
+// empty even when ReplyCount is not; the branch is then read starting from
+// this comment.
+type CommentTreeResponse struct {
+	CommentResponse
+	Replies       []CommentTreeResponse `json:"replies"`
+	RepliesCursor string                `json:"repliesCursor,omitempty"`
+}
+
+type CommentTreeListResponse struct {
+	Comments   []CommentTreeResponse `json:"comments"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+}
+
+type ReportResponse struct {
+	ReporterID string `json:"reporterId"`
+	Reason     string `json:"reason"`
+	Text       string `json:"text"`
+	UnixTime   int64  `json:"unixTime"`
+}
+
+// CommentLogResponse is the context a comment was posted in.
+type CommentLogResponse struct {
+	UnixTime  int64  `json:"unixTime"`
+	Ip        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+}
+
+// ModerationQueueResponse is a reported comment. Comment is nil when the
+// comment no longer exists and Log when no post log was found for it.
+type ModerationQueueResponse struct {
+	CommentId      string              `json:"commentId"`
+	Url            string              `json:"url"`
+	UnixTime       int64               `json:"unixTime"`
+	SiteDomain     string              `json:"siteDomain"`
+	ReportCount    int                 `json:"reportCount"`
+	LatestReportAt int64               `json:"latestReportAt"`
+	Hidden         bool                `json:"hidden"`
+	Comment        *CommentResponse    `json:"comment"`
+	Reports        []ReportResponse    `json:"reports"`
+	Log            *CommentLogResponse `json:"log"`
+}
+
+type ModerationQueueListResponse struct {
+	Entries    []ModerationQueueResponse `json:"entries"`
+	NextCursor string                    `json:"nextCursor,omitempty"`
+}
+
+// HeldCommentResponse is a comment a filter held for review, with the IP
+// and user agent it was posted with.
+type HeldCommentResponse struct {
+	CommentId       string `json:"commentId"`
+	Url             string `json:"url"`
+	UnixTime        int64  `json:"unixTime"`
+	SiteDomain      string `json:"siteDomain"`
+	Comment         string `json:"comment"`
+	UserID          string `json:"userId"`
+	ParentCommentId string `json:"parentCommentId,omitempty"`
+	Filter          string `json:"filter"`
+	Reason          string `json:"reason"`
+	Ip              string `json:"ip"`
+	UserAgent       string `json:"userAgent"`
+}
+
+type HeldCommentListResponse struct {
+	Entries    []HeldCommentResponse `json:"entries"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+}
+
+type RoleResponse struct {
+	UserID      string   `json:"userId"`
+	Role        string   `json:"role"`
+	SiteDomains []string `json:"siteDomains"`
+	UnixTime    int64    `json:"unixTime"`
+}
+
+type RoleListResponse struct {
+	Roles      []RoleResponse `json:"roles"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+type BanResponse struct {
+	Kind      string `json:"kind"`
+	Value     string `json:"value"`
+	Reason    string `json:"reason"`
+	CreatedBy string `json:"createdBy"`
+	UnixTime  int64  `json:"unixTime"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+type BanListResponse struct {
+	Bans       []BanResponse `json:"bans"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+type AdminAuditResponse struct {
+	AuditId  string `json:"auditId"`
+	UnixTime int64  `json:"unixTime"`
+	ActorID  string `json:"actorId"`
+	Role     string `json:"role"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	Detail   string `json:"detail"`
+	Ip       string `json:"ip"`
+}
+
+type AdminAuditListResponse struct {
+	Entries    []AdminAuditResponse `json:"entries"`
+	NextCursor string               `json:"nextCursor,omitempty"`
+}
+
+type OutboxTaskResponse struct {
+	TaskId          string `json:"taskId"`
+	Status          string `json:"status"`
+	Kind            string `json:"kind"`
+	CommentId       string `json:"commentId"`
+	Url             string `json:"url"`
+	SiteDomain      string `json:"siteDomain"`
+	CommentUnixTime int64  `json:"commentUnixTime"`
+	Attempts        int    `json:"attempts"`
+	NextAttemptAt   int64  `json:"nextAttemptAt"`
+	LastError       string `json:"lastError,omitempty"`
+	CreatedAt       int64  `json:"createdAt"`
+	UpdatedAt       int64  `json:"updatedAt"`
+}
+
+type OutboxTaskListResponse struct {
+	Tasks      []OutboxTaskResponse `json:"tasks"`
+	NextCursor string               `json:"nextCursor,omitempty"`
+}
+
+// CommentEventResponse is the data of a "comment" event on /stream.
+type CommentEventResponse struct {
+	CommentResponse
+	Url        string `json:"url"`
+	SiteDomain string `json:"siteDomain"`
+}
+
+// ReactionEventResponse is the data of a "reaction" event on /stream and
+// /live.
+type ReactionEventResponse struct {
+	CommentId string         `json:"commentId"`
+	Url       string         `json:"url"`
+	UnixTime  int64          `json:"unixTime"`
+	Reactions map[string]int `json:"reactions"`
 }
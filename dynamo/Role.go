@@ -0,0 +1,93 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type RoleRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewRoleRepository(client *dynamodb.Client, tableName string) *RoleRepository {
+	return &RoleRepository{client: client, tableName: tableName}
+}
+
+func (r *RoleRepository) PutRole(item RoleItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      av,
+	})
+	return err
+}
+
+func (r *RoleRepository) GetRole(userId string) (RoleItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return RoleItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return RoleItem{}, false, nil
+	}
+
+	var item RoleItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return RoleItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+func (r *RoleRepository) GetRoles(page PageRequest) ([]RoleItem, map[string]types.AttributeValue, error) {
+
+	out, err := r.client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:         aws.String(r.tableName),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("scan failed: %w", err)
+	}
+
+	var roles []RoleItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &roles)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return roles, out.LastEvaluatedKey, nil
+}
+
+func (r *RoleRepository) DeleteRole(userId string) error {
+
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	return nil
+}
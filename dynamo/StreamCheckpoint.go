@@ -0,0 +1,59 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type StreamCheckpointRepository struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+func NewStreamCheckpointRepository(client *dynamodb.Client, tableName string) *StreamCheckpointRepository {
+	return &StreamCheckpointRepository{client: client, tableName: tableName}
+}
+
+func (r *StreamCheckpointRepository) GetCheckpoint(shardId string) (StreamCheckpointItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"shardId": &types.AttributeValueMemberS{Value: shardId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return StreamCheckpointItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return StreamCheckpointItem{}, false, nil
+	}
+
+	var item StreamCheckpointItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return StreamCheckpointItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+func (r *StreamCheckpointRepository) PutCheckpoint(item StreamCheckpointItem) error {
+	av, err := attributevalue.MarshalMap(item)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	_, err = r.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(r.tableName),
+		Item:      av,
+	})
+	return err
+}
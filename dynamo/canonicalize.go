@@ -0,0 +1,244 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidURL = errors.New("invalid url")
+
+type TrailingSlashPolicy string
+
+const (
+	TrailingSlashStrip TrailingSlashPolicy = "strip"
+	TrailingSlashKeep  TrailingSlashPolicy = "keep"
+	TrailingSlashAdd   TrailingSlashPolicy = "add"
+)
+
+// DefaultTrackingParams are removed from query strings unless configured
+// otherwise. An entry ending in "*" matches every parameter with that prefix.
+var DefaultTrackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"yclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"igshid",
+	"_ga",
+}
+
+// URLCanonicalizer maps the many spellings of a page URL to one key, so that
+// e.g. "https://Example.com:443/a/?utm_source=x#top" and
+// "https://example.com/a" share a comment thread.
+type URLCanonicalizer struct {
+	trackingParams []string
+	trailingSlash  TrailingSlashPolicy
+}
+
+func NewURLCanonicalizer(trackingParams []string, trailingSlash TrailingSlashPolicy) *URLCanonicalizer {
+	params := make([]string, 0, len(trackingParams))
+	for _, p := range trackingParams {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			params = append(params, p)
+		}
+	}
+	if trailingSlash == "" {
+		trailingSlash = TrailingSlashStrip
+	}
+	return &URLCanonicalizer{trackingParams: params, trailingSlash: trailingSlash}
+}
+
+// Canonicalize returns the canonical form of an absolute http(s) URL: scheme
+// and host lower-cased, default port, trailing host dot, user info and
+// fragment removed, dot segments resolved (empty segments are kept),
+// percent-encoding normalized, tracking parameters removed,
+// remaining query parameters sorted, and the trailing slash handled according
+// to the configured policy.
+func (c *URLCanonicalizer) Canonicalize(rawURL string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("%w: unsupported scheme %q", ErrInvalidURL, parsed.Scheme)
+	}
+
+	host := canonicalHost(parsed.Scheme, parsed.Host)
+	if host == "" {
+		return "", fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+
+	canonical := parsed.Scheme + "://" + host + c.canonicalPath(parsed.EscapedPath())
+	if query := c.canonicalQuery(parsed.RawQuery); query != "" {
+		canonical += "?" + query
+	}
+	return canonical, nil
+}
+
+// CanonicalizeSiteDomain returns the canonical scheme://host form of a site
+// domain, matching what GetDomainWithScheme yields for canonical URLs.
+func (c *URLCanonicalizer) CanonicalizeSiteDomain(rawDomain string) (string, error) {
+	canonical, err := c.Canonicalize(rawDomain)
+	if err != nil {
+		return "", err
+	}
+	return GetDomainWithScheme(canonical)
+}
+
+func canonicalHost(scheme string, host string) string {
+	host = strings.ToLower(host)
+
+	name, port := host, ""
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		name, port = host[:i], host[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	if port == "" || (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		return name
+	}
+	return name + ":" + port
+}
+
+func (c *URLCanonicalizer) canonicalPath(escapedPath string) string {
+	if escapedPath == "" {
+		return "/"
+	}
+
+	resolved := removeDotSegments(normalizePercentEncoding(escapedPath))
+	if resolved == "/" {
+		return resolved
+	}
+	cleaned, hasTrailingSlash := strings.CutSuffix(resolved, "/")
+
+	switch c.trailingSlash {
+	case TrailingSlashAdd:
+		return cleaned + "/"
+	case TrailingSlashKeep:
+		if hasTrailingSlash {
+			return cleaned + "/"
+		}
+	}
+	return cleaned
+}
+
+// removeDotSegments resolves the "." and ".." segments of an absolute path
+// (RFC 3986 section 5.2.4). Unlike path.Clean it keeps empty segments, since
+// "/a//b" and "/a/b" may well be different pages.
+func removeDotSegments(p string) string {
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	resolved := make([]string, 0, len(segments))
+
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+		default:
+			resolved = append(resolved, segment)
+			continue
+		}
+		// A path ending in a dot segment names a directory.
+		if last {
+			resolved = append(resolved, "")
+		}
+	}
+	return "/" + strings.Join(resolved, "/")
+}
+
+func (c *URLCanonicalizer) canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		if c.isTrackingParam(name) {
+			continue
+		}
+		params = append(params, normalizePercentEncoding(param))
+	}
+
+	slices.SortStableFunc(params, func(a, b string) int {
+		nameA, _, _ := strings.Cut(a, "=")
+		nameB, _, _ := strings.Cut(b, "=")
+		if n := strings.Compare(nameA, nameB); n != 0 {
+			return n
+		}
+		return strings.Compare(a, b)
+	})
+	return strings.Join(params, "&")
+}
+
+func (c *URLCanonicalizer) isTrackingParam(name string) bool {
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.ToLower(name)
+
+	for _, p := range c.trackingParams {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and
+// upper-cases the hex digits of every other escape (RFC 3986 section 6.2.2).
+func normalizePercentEncoding(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(decoded) {
+			b.WriteByte(decoded)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -10,13 +10,25 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// CommentIndexNames are the global secondary indexes of the Comment table.
+type CommentIndexNames struct {
+	// UserID has partition key userId and sort key unixTime.
+	UserID string
+	// CommentID has partition key commentId.
+	CommentID string
+	// Parent has partition key parentCommentId and sort key unixTime. It is
+	// sparse: top-level comments have no parentCommentId.
+	Parent string
+}
+
 type CommentRepository struct {
 	client    *dynamodb.Client
 	tableName string
+	indexes   CommentIndexNames
 }
 
-func NewCommentRepository(client *dynamodb.Client, tableName string) *CommentRepository {
-	return &CommentRepository{client: client, tableName: tableName}
+func NewCommentRepository(client *dynamodb.Client, tableName string, indexes CommentIndexNames) *CommentRepository {
+	return &CommentRepository{client: client, tableName: tableName, indexes: indexes}
 }
 
 func (r *CommentRepository) PutComment(item CommentItem) error {
@@ -32,27 +44,195 @@ func (r *CommentRepository) PutComment(item CommentItem) error {
 	return err
 }
 
-func (r *CommentRepository) GetLatestCommentsByURL(url string) ([]CommentItem, error) {
+func (r *CommentRepository) GetLatestCommentsByURL(url string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error) {
 	input := &dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
-		KeyConditionExpression: aws.String("url = :u"),
+		KeyConditionExpression: aws.String("#url = :u"),
+		ExpressionAttributeNames: map[string]string{
+			"#url": "url",
+		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":u": &types.AttributeValueMemberS{Value: url},
 		},
-		ScanIndexForward: aws.Bool(false),
-		Limit:            aws.Int32(100),
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var comments []CommentItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &comments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return comments, out.LastEvaluatedKey, nil
+}
+
+func (r *CommentRepository) ScanComments(page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error) {
+
+	out, err := r.client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:         aws.String(r.tableName),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("scan failed: %w", err)
+	}
+
+	var records []CommentItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &records)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return records, out.LastEvaluatedKey, nil
+}
+
+func (r *CommentRepository) GetCommentsByUserID(userId string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String(r.indexes.UserID),
+		KeyConditionExpression: aws.String("userId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: userId},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var comments []CommentItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &comments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return comments, out.LastEvaluatedKey, nil
+}
+
+func (r *CommentRepository) GetComment(url string, unixTime int64) (CommentItem, bool, error) {
+
+	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]types.AttributeValue{
+			"url":      &types.AttributeValueMemberS{Value: url},
+			"unixTime": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", unixTime)},
+		},
+	})
+	if err != nil {
+		return CommentItem{}, false, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if out.Item == nil {
+		return CommentItem{}, false, nil
+	}
+
+	var item CommentItem
+	err = attributevalue.UnmarshalMap(out.Item, &item)
+	if err != nil {
+		return CommentItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+func (r *CommentRepository) GetCommentByID(commentId string) (CommentItem, bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String(r.indexes.CommentID),
+		KeyConditionExpression: aws.String("commentId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: commentId},
+		},
+		Limit: aws.Int32(1),
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return CommentItem{}, false, fmt.Errorf("query failed: %w", err)
+	}
+
+	if len(out.Items) == 0 {
+		return CommentItem{}, false, nil
+	}
+
+	var item CommentItem
+	err = attributevalue.UnmarshalMap(out.Items[0], &item)
+	if err != nil {
+		return CommentItem{}, false, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return item, true, nil
+}
+
+// GetRootCommentsByURL returns the top-level comments of a url, newest first.
+// Replies are filtered out after the limit is applied, so a page can hold
+// fewer items than the limit while LastEvaluatedKey still points further.
+func (r *CommentRepository) GetRootCommentsByURL(url string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		KeyConditionExpression: aws.String("#url = :u"),
+		FilterExpression:       aws.String("attribute_not_exists(parentCommentId)"),
+		ExpressionAttributeNames: map[string]string{
+			"#url": "url",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: url},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	}
+
+	out, err := r.client.Query(context.TODO(), input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	var comments []CommentItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &comments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return comments, out.LastEvaluatedKey, nil
+}
+
+// GetReplies returns the direct replies to a comment, oldest first. Replies
+// always share their parent's url, which the Parent index does not need.
+func (r *CommentRepository) GetReplies(url string, parentCommentId string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(r.tableName),
+		IndexName:              aws.String(r.indexes.Parent),
+		KeyConditionExpression: aws.String("parentCommentId = :u"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":u": &types.AttributeValueMemberS{Value: parentCommentId},
+		},
+		ScanIndexForward:  aws.Bool(true),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
 	}
 
 	out, err := r.client.Query(context.TODO(), input)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
 	var comments []CommentItem
 	err = attributevalue.UnmarshalListOfMaps(out.Items, &comments)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshal failed: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
 	}
 
-	return comments, nil
+	return comments, out.LastEvaluatedKey, nil
 }
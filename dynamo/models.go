@@ -8,8 +8,11 @@ type BaseFieldDatas struct {
 	SiteDomain string
 	Now        int64
 	Req        *http.Request
+	Ip         string // client address; Req's headers are not trusted for it
 	Url        string
 	UserId     string
+	// Parent is the comment being replied to, nil for a top-level comment.
+	Parent *CommentItem
 }
 
 type AllTableRecords struct {
@@ -27,14 +30,33 @@ type CommentItem struct {
 	Comment   string `dynamodbav:"comment"`
 	CommentId string `dynamodbav:"commentId"`
 	UserID    string `dynamodbav:"userId"`
+	EditedAt  int64  `dynamodbav:"editedAt,omitempty"`
+	// ParentCommentId and ParentUnixTime identify the comment replied to on
+	// the same url; both are empty for a top-level comment.
+	ParentCommentId string `dynamodbav:"parentCommentId,omitempty"`
+	ParentUnixTime  int64  `dynamodbav:"parentUnixTime,omitempty"`
+	ReplyCount      int    `dynamodbav:"replyCount"`
+	// Reactions counts reactions by type. New comments are written with an
+	// empty map so counters can be updated in place.
+	Reactions map[string]int `dynamodbav:"reactions"`
+	// ReportCount counts distinct reporters since the comment was last
+	// approved. Hidden comments are left out of every listing.
+	ReportCount int  `dynamodbav:"reportCount"`
+	Hidden      bool `dynamodbav:"hidden,omitempty"`
 }
 
+// CommentLogItem records who acted on a comment and from where. LogId is
+// built by CommentLogId so that entries written in the same millisecond for
+// different comments or actions never overwrite each other.
 type CommentLogItem struct {
 	GlobalKey string `dynamodbav:"globalKey"` //PartitionKey
-	UnixTime  int64  `dynamodbav:"unixTime"`  //Sort
+	LogId     string `dynamodbav:"logId"`     //Sort
+	UnixTime  int64  `dynamodbav:"unixTime"`
 	CommentId string `dynamodbav:"commentId"`
 	Ip        string `dynamodbav:"ip"`
 	UserAgent string `dynamodbav:"userAgent"`
+	Action    string `dynamodbav:"action"` // "post", "edit", "delete", "approve" or "remove"
+	UserID    string `dynamodbav:"userId"` // who performed the action
 }
 
 type PageGlobalStructureItem struct {
@@ -51,19 +73,190 @@ type PageStructureItem struct {
 }
 
 type RecentDomainCommentItem struct {
-	SiteDomain string `dynamodbav:"siteDomain"` //PartitionKey
-	UnixTime   int64  `dynamodbav:"unixTime"`   //Sort
+	SiteDomain string         `dynamodbav:"siteDomain"` //PartitionKey
+	UnixTime   int64          `dynamodbav:"unixTime"`   //Sort
+	Comment    string         `dynamodbav:"comment"`
+	CommentId  string         `dynamodbav:"commentId"`
+	Url        string         `dynamodbav:"url"`
+	UserID     string         `dynamodbav:"userId"`
+	EditedAt   int64          `dynamodbav:"editedAt,omitempty"`
+	Reactions  map[string]int `dynamodbav:"reactions"`
+	Hidden     bool           `dynamodbav:"hidden,omitempty"`
+}
+
+type RecentGlobalCommentItem struct {
+	GlobalKey string         `dynamodbav:"globalKey"` //PartitionKey
+	UnixTime  int64          `dynamodbav:"unixTime"`  //Sort
+	Comment   string         `dynamodbav:"comment"`
+	CommentId string         `dynamodbav:"commentId"`
+	Url       string         `dynamodbav:"url"`
+	UserID    string         `dynamodbav:"userId"`
+	EditedAt  int64          `dynamodbav:"editedAt,omitempty"`
+	Reactions map[string]int `dynamodbav:"reactions"`
+	Hidden    bool           `dynamodbav:"hidden,omitempty"`
+}
+
+type DeviceLinkItem struct {
+	DeviceId string `dynamodbav:"deviceId"` //PartitionKey
+	UserID   string `dynamodbav:"userId"`
+	UnixTime int64  `dynamodbav:"unixTime"`
+}
+
+type RateLimitBucketItem struct {
+	BucketKey string  `dynamodbav:"bucketKey"` //PartitionKey
+	Tokens    float64 `dynamodbav:"tokens"`
+	UpdatedAt int64   `dynamodbav:"updatedAt"`
+	Version   int64   `dynamodbav:"version"`
+	ExpiresAt int64   `dynamodbav:"expiresAt"` //TTL (unix seconds)
+}
+
+type HeldCommentItem struct {
+	GlobalKey  string `dynamodbav:"globalKey"` //PartitionKey
+	UnixTime   int64  `dynamodbav:"unixTime"`  //Sort
 	Comment    string `dynamodbav:"comment"`
 	CommentId  string `dynamodbav:"commentId"`
 	Url        string `dynamodbav:"url"`
+	SiteDomain string `dynamodbav:"siteDomain"`
 	UserID     string `dynamodbav:"userId"`
+	Ip         string `dynamodbav:"ip"`
+	UserAgent  string `dynamodbav:"userAgent"`
+	Filter     string `dynamodbav:"filter"`
+	Reason     string `dynamodbav:"reason"`
+
+	ParentCommentId string `dynamodbav:"parentCommentId,omitempty"`
 }
 
-type RecentGlobalCommentItem struct {
-	GlobalKey string `dynamodbav:"globalKey"` //PartitionKey
-	UnixTime  int64  `dynamodbav:"unixTime"`  //Sort
+// CommentRevisionItem is a comment text replaced by an edit. EditedAt is the
+// time of that edit and EditorID the user who made it.
+type CommentRevisionItem struct {
+	CommentId string `dynamodbav:"commentId"` //PartitionKey
+	EditedAt  int64  `dynamodbav:"editedAt"`  //Sort
 	Comment   string `dynamodbav:"comment"`
-	CommentId string `dynamodbav:"commentId"`
-	Url       string `dynamodbav:"url"`
+	EditorID  string `dynamodbav:"editorId"`
+}
+
+// ReactionItem is one user's reaction of one type to a comment.
+type ReactionItem struct {
+	CommentId string `dynamodbav:"commentId"` //PartitionKey
+	Key       string `dynamodbav:"key"`       //Sort (type#userId)
+	Type      string `dynamodbav:"type"`
 	UserID    string `dynamodbav:"userId"`
+	UnixTime  int64  `dynamodbav:"unixTime"`
+}
+
+func ReactionKey(reactionType string, userId string) string {
+	return reactionType + "#" + userId
+}
+
+// ReportItem is one user's report of a comment. Each user can report a
+// comment once.
+type ReportItem struct {
+	CommentId  string `dynamodbav:"commentId"`  //PartitionKey
+	ReporterID string `dynamodbav:"reporterId"` //Sort
+	Reason     string `dynamodbav:"reason"`
+	Text       string `dynamodbav:"text"`
+	UnixTime   int64  `dynamodbav:"unixTime"`
+}
+
+// ModerationQueueItem is a reported comment awaiting a moderator. Url and
+// UnixTime are the key of the comment.
+type ModerationQueueItem struct {
+	GlobalKey      string `dynamodbav:"globalKey"` //PartitionKey
+	CommentId      string `dynamodbav:"commentId"` //Sort
+	Url            string `dynamodbav:"url"`
+	UnixTime       int64  `dynamodbav:"unixTime"`
+	SiteDomain     string `dynamodbav:"siteDomain"`
+	ReportCount    int    `dynamodbav:"reportCount"`
+	LatestReportAt int64  `dynamodbav:"latestReportAt"`
+	Hidden         bool   `dynamodbav:"hidden"`
+}
+
+// RoleItem grants a user a role on the admin API. SiteDomains scopes the
+// site_owner role to those sites.
+type RoleItem struct {
+	UserID      string   `dynamodbav:"userId"` //PartitionKey
+	Role        string   `dynamodbav:"role"`
+	SiteDomains []string `dynamodbav:"siteDomains"`
+	UnixTime    int64    `dynamodbav:"unixTime"`
+}
+
+// BanItem bans a user (kind "user"), an IP range in CIDR notation ("ip") or a
+// site domain ("domain") from posting.
+type BanItem struct {
+	Kind      string `dynamodbav:"kind"`  //PartitionKey
+	Value     string `dynamodbav:"value"` //Sort
+	Reason    string `dynamodbav:"reason"`
+	CreatedBy string `dynamodbav:"createdBy"`
+	UnixTime  int64  `dynamodbav:"unixTime"`
+	ExpiresAt int64  `dynamodbav:"expiresAt,omitempty"` //TTL (unix seconds), 0 for permanent
+}
+
+// AdminAuditItem records one admin API action, written before the action is
+// carried out. An action that then fails is followed by an item whose Action
+// ends in ".failed". AuditId starts with the zero-padded unixTime so the log
+// sorts chronologically.
+type AdminAuditItem struct {
+	GlobalKey string `dynamodbav:"globalKey"` //PartitionKey
+	AuditId   string `dynamodbav:"auditId"`   //Sort
+	UnixTime  int64  `dynamodbav:"unixTime"`
+	ActorID   string `dynamodbav:"actorId"`
+	Role      string `dynamodbav:"role"`
+	Action    string `dynamodbav:"action"`
+	Target    string `dynamodbav:"target"`
+	Detail    string `dynamodbav:"detail"`
+	Ip        string `dynamodbav:"ip"`
+}
+
+// AppliedCommentEventItem marks a Comment table stream record as applied to
+// the derived tables, so that a record delivered again is skipped. Event is
+// "insert" or "remove"; modifications are idempotent by themselves. Outbox
+// tasks are marked the same way, with their kind as Event.
+type AppliedCommentEventItem struct {
+	CommentId string `dynamodbav:"commentId"` //PartitionKey
+	Event     string `dynamodbav:"event"`     //Sort
+	UnixTime  int64  `dynamodbav:"unixTime"`
+	ExpiresAt int64  `dynamodbav:"expiresAt"` //TTL (unix seconds)
+}
+
+// StreamCheckpointItem is how far the stream worker has read a shard.
+// Finished is set once the shard is closed and read to its end.
+type StreamCheckpointItem struct {
+	ShardId        string `dynamodbav:"shardId"` //PartitionKey
+	SequenceNumber string `dynamodbav:"sequenceNumber"`
+	Finished       bool   `dynamodbav:"finished"`
+	UpdatedAt      int64  `dynamodbav:"updatedAt"`
+}
+
+// OutboxTaskItem is a PageStructure and PageGlobalStructure write that a
+// comment write leaves to the outbox worker, recorded in the same
+// transaction. Status is "pending" until the task is applied, or "dead" once
+// it has failed too often; dead tasks stay until replayed.
+type OutboxTaskItem struct {
+	Status          string `dynamodbav:"status"` //PartitionKey
+	TaskId          string `dynamodbav:"taskId"` //Sort
+	Kind            string `dynamodbav:"kind"`
+	CommentId       string `dynamodbav:"commentId"`
+	Url             string `dynamodbav:"url"`
+	SiteDomain      string `dynamodbav:"siteDomain"`
+	CommentUnixTime int64  `dynamodbav:"commentUnixTime"`
+	Attempts        int    `dynamodbav:"attempts"`
+	NextAttemptAt   int64  `dynamodbav:"nextAttemptAt"` // unix milliseconds
+	LastError       string `dynamodbav:"lastError,omitempty"`
+	CreatedAt       int64  `dynamodbav:"createdAt"`
+	UpdatedAt       int64  `dynamodbav:"updatedAt"`
+}
+
+// IdempotencyKeyItem remembers the response to a POST /comment sent with an
+// Idempotency-Key header. Key is the user ID and the header value joined by
+// "#", so the keys of different users never collide. Completed is false while
+// the first request is being processed.
+type IdempotencyKeyItem struct {
+	Key         string `dynamodbav:"idempotencyKey"` //PartitionKey
+	RequestHash string `dynamodbav:"requestHash"`
+	Completed   bool   `dynamodbav:"completed"`
+	StatusCode  int    `dynamodbav:"statusCode,omitempty"`
+	ContentType string `dynamodbav:"contentType,omitempty"`
+	Body        string `dynamodbav:"body,omitempty"`
+	CreatedAt   int64  `dynamodbav:"createdAt"`
+	ExpiresAt   int64  `dynamodbav:"expiresAt"` //TTL (unix seconds)
 }
@@ -32,7 +32,7 @@ func (r *PageGlobalStructureRepository) PutGlobalStructure(item PageGlobalStruct
 	return err
 }
 
-func (r *PageGlobalStructureRepository) GetGlobalStructure() ([]PageGlobalStructureItem, error) {
+func (r *PageGlobalStructureRepository) GetGlobalStructure(page PageRequest) ([]PageGlobalStructureItem, map[string]types.AttributeValue, error) {
 
 	out, err := r.client.Query(context.TODO(), &dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
@@ -40,21 +40,25 @@ func (r *PageGlobalStructureRepository) GetGlobalStructure() ([]PageGlobalStruct
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":u": &types.AttributeValueMemberS{Value: "GLOBAL"},
 		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
 	var records []PageGlobalStructureItem
 	err = attributevalue.UnmarshalListOfMaps(out.Items, &records)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshal failed: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
 	}
 
-	return records, nil
+	return records, out.LastEvaluatedKey, nil
 }
 
-func (r *PageGlobalStructureRepository) IncrementGlobalStructureUrlCountByURL(siteDomain string) error {
+// UpsertGlobalStructureUrlCount adds delta to the urlCount of siteDomain,
+// creating the row when it does not exist yet, in a single UpdateItem call.
+func (r *PageGlobalStructureRepository) UpsertGlobalStructureUrlCount(siteDomain string, delta int) error {
 
 	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(r.tableName),
@@ -67,35 +71,29 @@ func (r *PageGlobalStructureRepository) IncrementGlobalStructureUrlCountByURL(si
 			"#c": "urlCount",
 		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":inc": &types.AttributeValueMemberN{Value: "1"},
+			":inc": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", delta)},
 		},
-		ReturnValues: types.ReturnValueUpdatedNew,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to increment urlCount for %s", err)
+		return fmt.Errorf("failed to upsert urlCount for %s: %w", siteDomain, err)
 	}
 
 	return nil
 }
 
-func (r *PageGlobalStructureRepository) ExistsGlobalStructureBySiteDomainAndURL(siteDomain string) (bool, error) {
+func (r *PageGlobalStructureRepository) DeleteGlobalStructure(siteDomain string) error {
 
-	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(r.tableName),
 		Key: map[string]types.AttributeValue{
 			"globalKey":  &types.AttributeValueMemberS{Value: "GLOBAL"},
 			"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
 		},
-		ProjectionExpression: aws.String("globalKey"),
 	})
 	if err != nil {
-		return false, fmt.Errorf("failed to get item: %w", err)
+		return fmt.Errorf("failed to delete global structure for %s: %w", siteDomain, err)
 	}
 
-	if out.Item == nil {
-		return false, nil
-	}
-
-	return true, nil
+	return nil
 }
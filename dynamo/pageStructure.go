@@ -32,7 +32,7 @@ func (r *PageStructureRepository) PutStructure(item PageStructureItem) error {
 	return err
 }
 
-func (r *PageStructureRepository) GetStructureBySiteDomain(siteDomain string) ([]PageStructureItem, error) {
+func (r *PageStructureRepository) GetStructureBySiteDomain(siteDomain string, page PageRequest) ([]PageStructureItem, map[string]types.AttributeValue, error) {
 
 	out, err := r.client.Query(context.TODO(), &dynamodb.QueryInput{
 		TableName:              aws.String(r.tableName),
@@ -40,23 +40,29 @@ func (r *PageStructureRepository) GetStructureBySiteDomain(siteDomain string) ([
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":d": &types.AttributeValueMemberS{Value: siteDomain},
 		},
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return nil, nil, fmt.Errorf("query failed: %w", err)
 	}
 
 	var records []PageStructureItem
 	err = attributevalue.UnmarshalListOfMaps(out.Items, &records)
 	if err != nil {
-		return nil, fmt.Errorf("unmarshal failed: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
 	}
 
-	return records, nil
+	return records, out.LastEvaluatedKey, nil
 }
 
-func (r *PageStructureRepository) IncrementStructureCommentCountByURL(siteDomain string, url string, now int64) error {
+// UpsertStructureCommentCount adds one comment to the row of url, creating
+// the row when it does not exist yet, in a single UpdateItem call. created
+// reports whether this call created the row, which is exact under concurrency
+// because DynamoDB applies updates to one item serially.
+func (r *PageStructureRepository) UpsertStructureCommentCount(siteDomain string, url string, now int64) (bool, error) {
 
-	_, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	out, err := r.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(r.tableName),
 		Key: map[string]types.AttributeValue{
 			"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
@@ -71,33 +77,49 @@ func (r *PageStructureRepository) IncrementStructureCommentCountByURL(siteDomain
 			":inc": &types.AttributeValueMemberN{Value: "1"},
 			":now": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now)},
 		},
-		ReturnValues: types.ReturnValueUpdatedNew,
+		ReturnValues: types.ReturnValueUpdatedOld,
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to increment commentCount for %s: %w", url, err)
+		return false, fmt.Errorf("failed to upsert commentCount for %s: %w", url, err)
 	}
 
-	return nil
+	_, existed := out.Attributes["commentCount"]
+	return !existed, nil
+}
+
+func (r *PageStructureRepository) ScanStructures(page PageRequest) ([]PageStructureItem, map[string]types.AttributeValue, error) {
+
+	out, err := r.client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:         aws.String(r.tableName),
+		Limit:             aws.Int32(page.limit()),
+		ExclusiveStartKey: page.StartKey,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("scan failed: %w", err)
+	}
+
+	var records []PageStructureItem
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &records)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return records, out.LastEvaluatedKey, nil
 }
 
-func (r *PageStructureRepository) ExistsStructureBySiteDomainAndURL(siteDomain string, url string) (bool, error) {
+func (r *PageStructureRepository) DeleteStructure(siteDomain string, url string) error {
 
-	out, err := r.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+	_, err := r.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(r.tableName),
 		Key: map[string]types.AttributeValue{
 			"siteDomain": &types.AttributeValueMemberS{Value: siteDomain},
 			"url":        &types.AttributeValueMemberS{Value: url},
 		},
-		ProjectionExpression: aws.String("siteDomain"),
 	})
 	if err != nil {
-		return false, fmt.Errorf("failed to get item: %w", err)
-	}
-
-	if out.Item == nil {
-		return false, nil
+		return fmt.Errorf("failed to delete structure for %s: %w", url, err)
 	}
 
-	return true, nil
+	return nil
 }
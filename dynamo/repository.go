@@ -0,0 +1,193 @@
+package dynamo
+
+import "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+// The interfaces below describe each repository independently of its storage.
+// The DynamoDB repositories in this package and the in-memory repositories in
+// package memory both satisfy them.
+
+type CommentStore interface {
+	PutComment(item CommentItem) error
+	GetLatestCommentsByURL(url string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error)
+	ScanComments(page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error)
+	GetCommentsByUserID(userId string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error)
+	GetComment(url string, unixTime int64) (CommentItem, bool, error)
+	GetCommentByID(commentId string) (CommentItem, bool, error)
+	GetRootCommentsByURL(url string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error)
+	GetReplies(url string, parentCommentId string, page PageRequest) ([]CommentItem, map[string]types.AttributeValue, error)
+}
+
+type CommentLogStore interface {
+	PutCommentLog(item CommentLogItem) error
+	GetCommentLog(unixTime int64, commentId string) (CommentLogItem, bool, error)
+}
+
+type PageGlobalStructureStore interface {
+	PutGlobalStructure(item PageGlobalStructureItem) error
+	GetGlobalStructure(page PageRequest) ([]PageGlobalStructureItem, map[string]types.AttributeValue, error)
+	UpsertGlobalStructureUrlCount(siteDomain string, delta int) error
+	DeleteGlobalStructure(siteDomain string) error
+}
+
+type PageStructureStore interface {
+	PutStructure(item PageStructureItem) error
+	GetStructureBySiteDomain(siteDomain string, page PageRequest) ([]PageStructureItem, map[string]types.AttributeValue, error)
+	UpsertStructureCommentCount(siteDomain string, url string, now int64) (bool, error)
+	ScanStructures(page PageRequest) ([]PageStructureItem, map[string]types.AttributeValue, error)
+	DeleteStructure(siteDomain string, url string) error
+}
+
+type RecentDomainCommentStore interface {
+	PutRecentDomainComment(item RecentDomainCommentItem) error
+	GetRecentDomainComment(siteDomain string, page PageRequest) ([]RecentDomainCommentItem, map[string]types.AttributeValue, error)
+	ScanRecentDomainComments(page PageRequest) ([]RecentDomainCommentItem, map[string]types.AttributeValue, error)
+	DeleteRecentDomainComment(siteDomain string, unixTime int64) error
+}
+
+type RecentGlobalCommentStore interface {
+	PutRecentGlobalComment(item RecentGlobalCommentItem) error
+	GetRecentGlobalComment(page PageRequest) ([]RecentGlobalCommentItem, map[string]types.AttributeValue, error)
+	DeleteRecentGlobalComment(unixTime int64) error
+}
+
+type CommentTransactionStore interface {
+	PutAllTableRecords(records AllTableRecords) error
+	ReassignCommentUser(item CommentItem, siteDomain string, userId string) error
+	DeleteComment(item CommentItem, siteDomain string, log CommentLogItem) error
+	EditComment(item CommentItem, siteDomain string, text string, revision CommentRevisionItem, log CommentLogItem) error
+	SetReaction(item CommentItem, siteDomain string, reaction ReactionItem, add bool) error
+	ReportComment(item CommentItem, siteDomain string, report ReportItem, hide bool) error
+	ApproveComment(item CommentItem, siteDomain string, log CommentLogItem) error
+}
+
+// CommentStreamStore applies the records of the Comment table's stream to the
+// tables derived from it. Applying a record twice has the effect of applying
+// it once.
+type CommentStreamStore interface {
+	ApplyCommentInsert(item CommentItem, siteDomain string) error
+	ApplyCommentModify(item CommentItem, siteDomain string) error
+	ApplyCommentRemove(item CommentItem, siteDomain string) error
+}
+
+// OutboxStore holds the outbox tasks. Writes taking the task as read are
+// conditioned on its attempts being unchanged and return ErrConditionFailed
+// when another worker got there first.
+type OutboxStore interface {
+	// GetDueOutboxTasks returns up to limit pending tasks whose next attempt
+	// is due at now, oldest first.
+	GetDueOutboxTasks(now int64, limit int32) ([]OutboxTaskItem, error)
+	GetOutboxTasks(status string, page PageRequest) ([]OutboxTaskItem, map[string]types.AttributeValue, error)
+	// ClaimOutboxTask counts an attempt and postpones the next one to
+	// nextAttemptAt, so no other worker takes the task meanwhile.
+	ClaimOutboxTask(task OutboxTaskItem, nextAttemptAt int64) (OutboxTaskItem, error)
+	FailOutboxTask(task OutboxTaskItem, lastError string) error
+	CompleteOutboxTask(task OutboxTaskItem) error
+	DeadLetterOutboxTask(task OutboxTaskItem, lastError string) error
+	// ReplayOutboxTask makes a dead task pending again, due at now, with its
+	// attempts reset. It reports false if there is no such dead task.
+	ReplayOutboxTask(taskId string, now int64) (OutboxTaskItem, bool, error)
+}
+
+// OutboxTaskApplier applies outbox tasks. Applying a task twice has the
+// effect of applying it once.
+type OutboxTaskApplier interface {
+	ApplyOutboxTask(task OutboxTaskItem) error
+}
+
+// IdempotencyKeyStore holds the responses remembered for idempotency keys.
+type IdempotencyKeyStore interface {
+	// ReserveIdempotencyKey stores item unless its key is taken, and reports
+	// whether it did. A key is taken by an item that has not expired at now
+	// (unix seconds) and is either completed or was created after
+	// staleBefore (unix milliseconds); otherwise the item holding it is
+	// returned.
+	ReserveIdempotencyKey(item IdempotencyKeyItem, now int64, staleBefore int64) (IdempotencyKeyItem, bool, error)
+	PutIdempotencyKey(item IdempotencyKeyItem) error
+	DeleteIdempotencyKey(key string) error
+}
+
+type StreamCheckpointStore interface {
+	GetCheckpoint(shardId string) (StreamCheckpointItem, bool, error)
+	PutCheckpoint(item StreamCheckpointItem) error
+}
+
+type DeviceLinkStore interface {
+	PutDeviceLink(item DeviceLinkItem) error
+	GetDeviceLink(deviceId string) (DeviceLinkItem, bool, error)
+}
+
+type RateLimitBucketStore interface {
+	GetBucket(bucketKey string) (RateLimitBucketItem, bool, error)
+	PutBucket(item RateLimitBucketItem, previousVersion int64) error
+}
+
+type HeldCommentStore interface {
+	PutHeldComment(item HeldCommentItem) error
+	GetHeldComments(page PageRequest) ([]HeldCommentItem, map[string]types.AttributeValue, error)
+	GetHeldComment(unixTime int64) (HeldCommentItem, bool, error)
+	DeleteHeldComment(unixTime int64, commentId string) error
+}
+
+type CommentRevisionStore interface {
+	GetCommentRevisions(commentId string, page PageRequest) ([]CommentRevisionItem, map[string]types.AttributeValue, error)
+}
+
+type ReactionStore interface {
+	GetReaction(commentId string, reactionType string, userId string) (ReactionItem, bool, error)
+}
+
+type ReportStore interface {
+	GetReports(commentId string, page PageRequest) ([]ReportItem, map[string]types.AttributeValue, error)
+}
+
+type ModerationQueueStore interface {
+	GetModerationQueue(page PageRequest) ([]ModerationQueueItem, map[string]types.AttributeValue, error)
+	GetModerationQueueItem(commentId string) (ModerationQueueItem, bool, error)
+	DeleteModerationQueueItem(commentId string) error
+}
+
+type RoleStore interface {
+	PutRole(item RoleItem) error
+	GetRole(userId string) (RoleItem, bool, error)
+	GetRoles(page PageRequest) ([]RoleItem, map[string]types.AttributeValue, error)
+	DeleteRole(userId string) error
+}
+
+type BanStore interface {
+	PutBan(item BanItem) error
+	GetBan(kind string, value string) (BanItem, bool, error)
+	GetBans(kind string, page PageRequest) ([]BanItem, map[string]types.AttributeValue, error)
+	DeleteBan(kind string, value string) error
+}
+
+// AdminAuditStore is append-only: there is no way to change or delete an
+// entry.
+type AdminAuditStore interface {
+	PutAdminAudit(item AdminAuditItem) error
+	GetAdminAudit(page PageRequest) ([]AdminAuditItem, map[string]types.AttributeValue, error)
+}
+
+var (
+	_ CommentStore             = (*CommentRepository)(nil)
+	_ CommentLogStore          = (*CommentLogRepository)(nil)
+	_ PageGlobalStructureStore = (*PageGlobalStructureRepository)(nil)
+	_ PageStructureStore       = (*PageStructureRepository)(nil)
+	_ RecentDomainCommentStore = (*RecentDomainCommentRepository)(nil)
+	_ RecentGlobalCommentStore = (*RecentGlobalCommentRepository)(nil)
+	_ CommentTransactionStore  = (*CommentTransactionRepository)(nil)
+	_ CommentStreamStore       = (*CommentTransactionRepository)(nil)
+	_ StreamCheckpointStore    = (*StreamCheckpointRepository)(nil)
+	_ OutboxStore              = (*OutboxRepository)(nil)
+	_ IdempotencyKeyStore      = (*IdempotencyKeyRepository)(nil)
+	_ OutboxTaskApplier        = (*CommentTransactionRepository)(nil)
+	_ DeviceLinkStore          = (*DeviceLinkRepository)(nil)
+	_ RateLimitBucketStore     = (*RateLimitBucketRepository)(nil)
+	_ HeldCommentStore         = (*HeldCommentRepository)(nil)
+	_ CommentRevisionStore     = (*CommentRevisionRepository)(nil)
+	_ ReactionStore            = (*ReactionRepository)(nil)
+	_ ReportStore              = (*ReportRepository)(nil)
+	_ ModerationQueueStore     = (*ModerationQueueRepository)(nil)
+	_ RoleStore                = (*RoleRepository)(nil)
+	_ BanStore                 = (*BanRepository)(nil)
+	_ AdminAuditStore          = (*AdminAuditRepository)(nil)
+)
@@ -2,6 +2,7 @@ package dynamo
 
 import (
 	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
 	"strings"
@@ -34,12 +35,20 @@ func GenerateCommentId() string {
 	return id.String()
 }
 
-func GetIpAddress(req *http.Request) string {
-	ip := req.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = req.RemoteAddr
-	}
-	return ip
+// GenerateAuditId returns a unique admin audit ID that sorts by now.
+func GenerateAuditId(now int64) string {
+	return fmt.Sprintf("%013d#%s", now, uuid.New().String())
+}
+
+// CommentLogId returns the comment log ID of commentId's action at now. It
+// sorts by now and is unique per comment and action.
+func CommentLogId(now int64, commentId string, action string) string {
+	return fmt.Sprintf("%013d#%s#%s", now, commentId, action)
+}
+
+// GenerateOutboxTaskId returns a unique outbox task ID that sorts by now.
+func GenerateOutboxTaskId(now int64) string {
+	return fmt.Sprintf("%013d#%s", now, uuid.New().String())
 }
 
 func GetUserAgent(req *http.Request) string {
@@ -48,20 +57,33 @@ func GetUserAgent(req *http.Request) string {
 }
 
 func GenerateAllTableRecords(Datas BaseFieldDatas) AllTableRecords {
+	var parentCommentId string
+	var parentUnixTime int64
+	if Datas.Parent != nil {
+		parentCommentId = Datas.Parent.CommentId
+		parentUnixTime = Datas.Parent.UnixTime
+	}
+
 	return AllTableRecords{
 		CommentItem: CommentItem{
-			Url:       Datas.Url,
-			UnixTime:  Datas.Now,
-			Comment:   Datas.Comment,
-			CommentId: Datas.CommentId,
-			UserID:    Datas.UserId,
+			Url:             Datas.Url,
+			UnixTime:        Datas.Now,
+			Comment:         Datas.Comment,
+			CommentId:       Datas.CommentId,
+			UserID:          Datas.UserId,
+			ParentCommentId: parentCommentId,
+			ParentUnixTime:  parentUnixTime,
+			Reactions:       map[string]int{},
 		},
 		CommentLogItem: CommentLogItem{
 			GlobalKey: "GLOBAL", // 生成関数などで作る
+			LogId:     CommentLogId(Datas.Now, Datas.CommentId, "post"),
 			UnixTime:  Datas.Now,
 			CommentId: Datas.CommentId,
-			Ip:        GetIpAddress(Datas.Req),
+			Ip:        Datas.Ip,
 			UserAgent: GetUserAgent(Datas.Req),
+			Action:    "post",
+			UserID:    Datas.UserId,
 		},
 		PageGlobalStructureItem: PageGlobalStructureItem{
 			GlobalKey:  "GLOBAL",
@@ -81,6 +103,7 @@ func GenerateAllTableRecords(Datas BaseFieldDatas) AllTableRecords {
 			CommentId:  Datas.CommentId,
 			Url:        Datas.Url,
 			UserID:     Datas.UserId,
+			Reactions:  map[string]int{},
 		},
 		RecentGlobalCommentItem: RecentGlobalCommentItem{
 			GlobalKey: "GLOBAL",
@@ -89,6 +112,36 @@ func GenerateAllTableRecords(Datas BaseFieldDatas) AllTableRecords {
 			CommentId: Datas.CommentId,
 			Url:       Datas.Url,
 			UserID:    Datas.UserId,
+			Reactions: map[string]int{},
 		},
 	}
 }
+
+// RecentCommentItemsFromComment derives the RecentGlobalComment and
+// RecentDomainComment rows of a stored comment, matching what
+// GenerateAllTableRecords writes when the comment is posted.
+func RecentCommentItemsFromComment(item CommentItem, siteDomain string) (RecentGlobalCommentItem, RecentDomainCommentItem) {
+	recentGlobal := RecentGlobalCommentItem{
+		GlobalKey: "GLOBAL",
+		UnixTime:  item.UnixTime,
+		Comment:   item.Comment,
+		CommentId: item.CommentId,
+		Url:       item.Url,
+		UserID:    item.UserID,
+		EditedAt:  item.EditedAt,
+		Reactions: maps.Clone(item.Reactions),
+		Hidden:    item.Hidden,
+	}
+	recentDomain := RecentDomainCommentItem{
+		SiteDomain: siteDomain,
+		UnixTime:   item.UnixTime,
+		Comment:    item.Comment,
+		CommentId:  item.CommentId,
+		Url:        item.Url,
+		UserID:     item.UserID,
+		EditedAt:   item.EditedAt,
+		Reactions:  maps.Clone(item.Reactions),
+		Hidden:     item.Hidden,
+	}
+	return recentGlobal, recentDomain
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/filter"
+)
+
+// loadEditWindow reads EDIT_WINDOW, how long after posting an author may edit
+// a comment, as a Go duration such as "15m". "0" disables editing.
+func loadEditWindow() time.Duration {
+	v := os.Getenv("EDIT_WINDOW")
+	if v == "" {
+		return 15 * time.Minute
+	}
+	window, err := time.ParseDuration(v)
+	if err != nil || window < 0 {
+		log.Fatalf("invalid EDIT_WINDOW: %s", v)
+	}
+	return window
+}
+
+// handleEditComment replaces the text of a comment on behalf of its author
+// within the edit window. The new text goes through the same filters as a new
+// comment; text the filters would hold is refused rather than held.
+func handleEditComment(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	identity, err := authenticator.Identify(w, r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	var req struct {
+		Url       string `json:"url"`
+		UnixTime  int64  `json:"unixTime"`
+		CommentId string `json:"commentId"`
+		Comment   string `json:"comment"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"url": req.Url != "", "unixTime": req.UnixTime != 0, "commentId": req.CommentId != "", "comment": req.Comment != ""}) {
+		return
+	}
+
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(req.Url)
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
+	}
+
+	domain, err := dynamo.GetDomainWithScheme(canonicalUrl)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("URL conversion failed: %w", err))
+		return
+	}
+
+	if !checkBans(w, r, identity.UserID, clientIp(r), domain) {
+		return
+	}
+
+	comment, ok, err := commentRepo.GetComment(canonicalUrl, req.UnixTime)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+		return
+	}
+	if !ok || comment.CommentId != req.CommentId {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+	if comment.UserID != identity.UserID {
+		writeError(w, r, http.StatusForbidden, codeForbidden)
+		return
+	}
+
+	nowUnix := dynamo.GetUnixMillsecound()
+	if time.Duration(nowUnix-comment.UnixTime)*time.Millisecond > editWindow {
+		writeError(w, r, http.StatusForbidden, codeEditWindowPassed)
+		return
+	}
+
+	if req.Comment == comment.Comment {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"message":  "Edit succeeded!",
+			"editedAt": comment.EditedAt,
+		})
+		return
+	}
+
+	decision := commentFilters.Run(filter.Comment{
+		Text:       req.Comment,
+		Url:        canonicalUrl,
+		SiteDomain: domain,
+		UserId:     identity.UserID,
+	})
+	if decision.Verdict != filter.Accept {
+		writeError(w, r, http.StatusUnprocessableEntity, codeCommentRejected, fieldError{Field: "comment", Code: errorCode(decision.Reason)})
+		return
+	}
+
+	revision := dynamo.CommentRevisionItem{
+		CommentId: comment.CommentId,
+		EditedAt:  nowUnix,
+		Comment:   comment.Comment,
+		EditorID:  identity.UserID,
+	}
+	logItem := dynamo.CommentLogItem{
+		GlobalKey: "GLOBAL",
+		LogId:     dynamo.CommentLogId(nowUnix, comment.CommentId, "edit"),
+		UnixTime:  nowUnix,
+		CommentId: comment.CommentId,
+		Ip:        clientIp(r),
+		UserAgent: dynamo.GetUserAgent(r),
+		Action:    "edit",
+		UserID:    identity.UserID,
+	}
+
+	err = commentTransactionRepo.EditComment(comment, domain, req.Comment, revision, logItem)
+	var canceled *dynamo.TransactionCanceledError
+	if errors.As(err, &canceled) && canceled.ConditionFailed(0) {
+		writeError(w, r, http.StatusConflict, codeConflict)
+		return
+	}
+	if errors.Is(err, dynamo.ErrTransactionCanceled) {
+		writeError(w, r, http.StatusConflict, codeConflict)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":  "Edit succeeded!",
+		"editedAt": nowUnix,
+	})
+}
+
+// handleGetCommentRevisions lists the replaced texts of a comment, newest
+// first. The revisions of a hidden comment are shown only to its author and
+// to moderators of its site; to anyone else it does not exist, like a deleted
+// one.
+func handleGetCommentRevisions(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	commentId := r.URL.Query().Get("commentId")
+	if !requireFields(w, r, map[string]bool{"commentId": commentId != ""}) {
+		return
+	}
+
+	comment, ok, err := commentRepo.GetCommentByID(commentId)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+		return
+	}
+	if ok && comment.Hidden {
+		ok, err = canSeeHiddenComment(r, comment)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch role: %w", err))
+			return
+		}
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+
+	scope := "commentRevision:" + commentId
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := commentRevisionRepo.GetCommentRevisions(commentId, page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch revisions: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.CommentRevisionListResponse{
+		Revisions:  make([]dynamo.CommentRevisionResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		response.Revisions = append(response.Revisions, dynamo.CommentRevisionResponse{
+			EditedAt: rec.EditedAt,
+			Comment:  rec.Comment,
+			EditorID: rec.EditorID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// canSeeHiddenComment reports whether the request's bearer token belongs to
+// the author of comment or to a moderator of its site.
+func canSeeHiddenComment(r *http.Request, comment dynamo.CommentItem) (bool, error) {
+	userId, err := authenticator.BearerUserID(r)
+	if err != nil {
+		return false, nil
+	}
+	if userId == comment.UserID {
+		return true, nil
+	}
+
+	actor, ok, err := lookupActor(userId)
+	if err != nil || !ok {
+		return false, err
+	}
+	siteDomain, err := dynamo.GetDomainWithScheme(comment.Url)
+	if err != nil {
+		return false, nil
+	}
+	return actor.can(permModerateComment, siteDomain), nil
+}
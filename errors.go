@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// errorCode is a stable, machine-readable error code. Clients branch on it;
+// the messages may change.
+type errorCode string
+
+const (
+	codeMethodNotAllowed         errorCode = "method_not_allowed"
+	codeUnauthorized             errorCode = "unauthorized"
+	codeForbidden                errorCode = "forbidden"
+	codeBanned                   errorCode = "banned"
+	codeInvalidJSON              errorCode = "invalid_json"
+	codeInvalidRequest           errorCode = "invalid_request"
+	codeCommentNotFound          errorCode = "comment_not_found"
+	codeNotInModerationQueue     errorCode = "not_in_moderation_queue"
+	codeNotHeld                  errorCode = "not_held"
+	codeOutboxTaskNotFound       errorCode = "outbox_task_not_found"
+	codeNotInRoom                errorCode = "not_in_room"
+	codeConflict                 errorCode = "conflict"
+	codeDeviceAlreadyLinked      errorCode = "device_already_linked"
+	codeIdempotencyKeyInProgress errorCode = "idempotency_key_in_progress"
+	codeIdempotencyKeyReused     errorCode = "idempotency_key_reused"
+	codeCommentRejected          errorCode = "comment_rejected"
+	codeEditWindowPassed         errorCode = "edit_window_passed"
+	codeRateLimited              errorCode = "rate_limited"
+	codeWebSocketRequired        errorCode = "websocket_required"
+	codeInternal                 errorCode = "internal_error"
+)
+
+// Codes of fieldError, saying what is wrong with one field. A rejected
+// comment's field code is the filter's reason instead.
+const (
+	fieldRequired     errorCode = "required"
+	fieldInvalid      errorCode = "invalid"
+	fieldUnknownValue errorCode = "unknown_value"
+	fieldTooLong      errorCode = "too_long"
+	fieldInPast       errorCode = "in_past"
+	fieldNotFound     errorCode = "not_found"
+)
+
+type localizedMessage struct {
+	en string
+	ja string
+}
+
+var errorMessages = map[errorCode]localizedMessage{
+	codeMethodNotAllowed:         {"This method is not allowed.", "このメソッドは使用できません。"},
+	codeUnauthorized:             {"Authentication is required.", "認証が必要です。"},
+	codeForbidden:                {"You do not have permission for this action.", "この操作を行う権限がありません。"},
+	codeBanned:                   {"This account, address or site is banned.", "このアカウント、IPアドレスまたはサイトは利用を禁止されています。"},
+	codeInvalidJSON:              {"The request body is not valid JSON.", "リクエストの本文が正しいJSONではありません。"},
+	codeInvalidRequest:           {"The request has invalid fields.", "リクエストに不正な項目があります。"},
+	codeCommentNotFound:          {"The comment was not found.", "コメントが見つかりません。"},
+	codeNotInModerationQueue:     {"The comment is not in the moderation queue.", "このコメントはモデレーション待ちではありません。"},
+	codeNotHeld:                  {"The comment is not held for review.", "このコメントは保留中ではありません。"},
+	codeOutboxTaskNotFound:       {"There is no dead-lettered task with this ID.", "このIDの失敗したタスクはありません。"},
+	codeNotInRoom:                {"Join a page room first.", "先にページのルームに参加してください。"},
+	codeConflict:                 {"The data was changed concurrently. Please retry.", "データが同時に更新されました。再度お試しください。"},
+	codeDeviceAlreadyLinked:      {"This device is already linked to another account.", "このデバイスは既に別のアカウントに連携されています。"},
+	codeIdempotencyKeyInProgress: {"A request with this Idempotency-Key is in progress.", "このIdempotency-Keyのリクエストは処理中です。"},
+	codeIdempotencyKeyReused:     {"This Idempotency-Key was used for a different request.", "このIdempotency-Keyは別のリクエストで使用されています。"},
+	codeCommentRejected:          {"The comment was rejected.", "コメントは受け付けられませんでした。"},
+	codeEditWindowPassed:         {"The time allowed for editing has passed.", "編集できる期間を過ぎています。"},
+	codeRateLimited:              {"Too many requests. Please wait and retry.", "リクエストが多すぎます。しばらく待ってから再度お試しください。"},
+	codeWebSocketRequired:        {"This endpoint requires a WebSocket handshake.", "WebSocketのハンドシェイクが必要です。"},
+	codeInternal:                 {"An internal error occurred.", "内部エラーが発生しました。"},
+
+	fieldRequired:     {"This field is required.", "この項目は必須です。"},
+	fieldInvalid:      {"This value is invalid.", "この値は不正です。"},
+	fieldUnknownValue: {"This value is not one of the allowed values.", "この値は使用できません。"},
+	fieldTooLong:      {"This value is too long.", "この値は長すぎます。"},
+	fieldInPast:       {"This time is in the past.", "過去の日時は指定できません。"},
+	fieldNotFound:     {"Nothing with this value was found.", "この値に該当するデータがありません。"},
+
+	"ng_word":             {"The text contains a prohibited word.", "禁止されている語句が含まれています。"},
+	"too_many_links":      {"The text contains too many links.", "リンクが多すぎます。"},
+	"repeated_characters": {"The text repeats characters too often.", "同じ文字の繰り返しが多すぎます。"},
+}
+
+// fallbackMessage is used for codes missing from errorMessages.
+var fallbackMessage = localizedMessage{"The request failed.", "リクエストを処理できませんでした。"}
+
+// errorEnvelope is the body of every error response.
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      errorCode    `json:"code"`
+	Message   string       `json:"message"`
+	RequestId string       `json:"requestId"`
+	Details   []fieldError `json:"details,omitempty"`
+}
+
+// fieldError is what is wrong with one field of the request.
+type fieldError struct {
+	Field   string    `json:"field"`
+	Code    errorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// newErrorBody localizes code and the details' codes for r.
+func newErrorBody(r *http.Request, code errorCode, details ...fieldError) errorBody {
+	lang := preferredLanguage(r)
+	body := errorBody{
+		Code:      code,
+		Message:   localize(code, lang),
+		RequestId: requestId(r),
+	}
+	for _, d := range details {
+		d.Message = localize(d.Code, lang)
+		body.Details = append(body.Details, d)
+	}
+	return body
+}
+
+// writeError answers status with the error envelope for code.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code errorCode, details ...fieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", preferredLanguage(r))
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorEnvelope{Error: newErrorBody(r, code, details...)})
+}
+
+// writeInvalidField answers 400 for one invalid field.
+func writeInvalidField(w http.ResponseWriter, r *http.Request, field string, code errorCode) {
+	writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fieldError{Field: field, Code: code})
+}
+
+// writeInternalError logs err with the request ID and answers 500 without
+// revealing it.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("request %s: %s %s: %v", requestId(r), r.Method, r.URL.Path, err)
+	writeError(w, r, http.StatusInternalServerError, codeInternal)
+}
+
+// requireFields answers 400 listing every missing field and returns false if
+// any is missing. fields tells for each field name whether it is present.
+func requireFields(w http.ResponseWriter, r *http.Request, fields map[string]bool) bool {
+	var details []fieldError
+	for name, present := range fields {
+		if !present {
+			details = append(details, fieldError{Field: name, Code: fieldRequired})
+		}
+	}
+	if len(details) == 0 {
+		return true
+	}
+	slices.SortFunc(details, func(a, b fieldError) int { return strings.Compare(a.Field, b.Field) })
+	writeError(w, r, http.StatusBadRequest, codeInvalidRequest, details...)
+	return false
+}
+
+func localize(code errorCode, lang string) string {
+	message, ok := errorMessages[code]
+	if !ok {
+		message = fallbackMessage
+	}
+	if lang == "ja" {
+		return message.ja
+	}
+	return message.en
+}
+
+// preferredLanguage returns "ja" or "en", whichever Accept-Language ranks
+// higher, and "en" when it lists neither.
+func preferredLanguage(r *http.Request) string {
+	best, bestQ := "en", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if (base == "ja" || base == "en") && q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+type requestIdKey struct{}
+
+// validRequestId is what an X-Request-Id set by a proxy in front of the
+// server must look like to be kept.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestId gives every request an ID, taken from X-Request-Id when a
+// proxy has set one, and returns it in the X-Request-Id response header. Error
+// responses and the log lines of internal errors carry it too.
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !validRequestId.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id)))
+	})
+}
+
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdKey{}).(string)
+	return id
+}
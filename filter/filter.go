@@ -0,0 +1,60 @@
+// Package filter inspects comments before they are stored. A Chain runs
+// Filters in order; each filter accepts a comment, rejects it with a reason,
+// or holds it for review.
+package filter
+
+type Verdict string
+
+const (
+	Accept Verdict = "accept"
+	Reject Verdict = "reject"
+	Hold   Verdict = "hold"
+)
+
+// Comment is what filters see of a comment being posted.
+type Comment struct {
+	Text       string
+	Url        string
+	SiteDomain string
+	UserId     string
+}
+
+// Decision is a filter's verdict. Reason is a stable machine-readable code
+// such as "ng_word", empty for Accept.
+type Decision struct {
+	Verdict Verdict
+	Reason  string
+	Filter  string
+}
+
+// Filter is implemented by every check, including classifiers added later.
+type Filter interface {
+	Name() string
+	Check(comment Comment) Decision
+}
+
+func accept() Decision {
+	return Decision{Verdict: Accept}
+}
+
+// Chain runs filters in order. The first Reject ends the run; otherwise the
+// first Hold is returned, so a held comment still has to pass every filter.
+type Chain []Filter
+
+func (c Chain) Run(comment Comment) Decision {
+	held := accept()
+	for _, f := range c {
+		decision := f.Check(comment)
+		decision.Filter = f.Name()
+
+		switch decision.Verdict {
+		case Reject:
+			return decision
+		case Hold:
+			if held.Verdict == Accept {
+				held = decision
+			}
+		}
+	}
+	return held
+}
@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+)
+
+// halfwidthKatakana maps U+FF66..U+FF9D to their full-width katakana.
+var halfwidthKatakana = []rune("ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン")
+
+// Normalize folds the spellings people use to slip words past a filter onto
+// one form: widths and kana as in foldWidth, upper case to lower case, and
+// whitespace and punctuation removed.
+func Normalize(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for _, r := range foldWidth(s) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// foldWidth converts full-width ASCII and the ideographic space to half-width,
+// half-width katakana (including voiced marks) to full-width, and katakana to
+// hiragana.
+func foldWidth(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		case r == 0x3000:
+			r = ' '
+		case r >= 0xFF66 && r <= 0xFF9D:
+			r = halfwidthKatakana[r-0xFF66]
+			if i+1 < len(runes) {
+				if voiced, ok := combineVoicedMark(r, runes[i+1]); ok {
+					r = voiced
+					i++
+				}
+			}
+		case r == 0xFF9E || r == 0xFF9F:
+			// A voiced mark that did not follow a kana it combines with.
+			continue
+		}
+
+		if r >= 0x30A1 && r <= 0x30F6 {
+			r -= 0x60
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// combineVoicedMark applies a half-width dakuten (U+FF9E) or handakuten
+// (U+FF9F) to a full-width katakana.
+func combineVoicedMark(r rune, mark rune) (rune, bool) {
+	switch mark {
+	case 0xFF9E:
+		switch {
+		case r == 'ウ':
+			return 'ヴ', true
+		case (r >= 'カ' && r <= 'チ' && (r-'カ')%2 == 0) || (r >= 'ハ' && r <= 'ホ' && (r-'ハ')%3 == 0):
+			return r + 1, true
+		case r == 'ツ' || r == 'テ' || r == 'ト':
+			// The small ッ before ツ shifts these to odd offsets from カ.
+			return r + 1, true
+		}
+	case 0xFF9F:
+		if r >= 'ハ' && r <= 'ホ' && (r-'ハ')%3 == 0 {
+			return r + 2, true
+		}
+	}
+	return r, false
+}
@@ -0,0 +1,32 @@
+package filter
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"full-width ASCII", "ＳＰＡＭ", "spam"},
+		{"ideographic space and punctuation", "ス　パ・ム！", "すぱむ"},
+		{"katakana to hiragana", "スパム", "すぱむ"},
+		{"half-width katakana", "ｽﾊﾟﾑ", "すぱむ"},
+		{"half-width dakuten", "ｶﾞｷﾞｸﾞ", "がぎぐ"},
+		{"half-width handakuten", "ﾊﾟﾋﾟﾌﾟﾍﾟﾎﾟ", "ぱぴぷぺぽ"},
+		{"dakuten on ta row", "ﾀﾞﾁﾞﾂﾞﾃﾞﾄﾞ", "だぢづでど"},
+		{"dakuten on ha row", "ﾊﾞﾋﾞﾌﾞﾍﾞﾎﾞ", "ばびぶべぼ"},
+		{"vu", "ｳﾞ", "ゔ"},
+		{"small tsu does not take dakuten", "ｯﾞ", "っ"},
+		{"dakuten after a kana it does not combine with", "ｱﾞ", "あ"},
+		{"stray voiced mark", "ﾞｽﾊﾟﾑ", "すぱむ"},
+		{"handakuten on ka row", "ｶﾟ", "か"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NGWordFilter matches comments against a word list after Normalize, so
+// "ＳＰＡＭ", "ｽﾊﾟﾑ" and "す ぱ む" all match the entry "スパム". Matches get
+// the configured verdict with reason "ng_word".
+type NGWordFilter struct {
+	words   []string
+	verdict Verdict
+}
+
+func NewNGWordFilter(words []string, verdict Verdict) *NGWordFilter {
+	normalized := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = Normalize(w); w != "" {
+			normalized = append(normalized, w)
+		}
+	}
+	return &NGWordFilter{words: normalized, verdict: verdict}
+}
+
+func (f *NGWordFilter) Name() string {
+	return "ng_word"
+}
+
+func (f *NGWordFilter) Check(comment Comment) Decision {
+	text := Normalize(comment.Text)
+	for _, w := range f.words {
+		if strings.Contains(text, w) {
+			return Decision{Verdict: f.verdict, Reason: "ng_word"}
+		}
+	}
+	return accept()
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(?:https?://|www\.)`)
+
+// LinkFilter holds comments with more than max links (reason "too_many_links");
+// they are often spam but not always, so a person decides.
+type LinkFilter struct {
+	max int
+}
+
+func NewLinkFilter(max int) *LinkFilter {
+	return &LinkFilter{max: max}
+}
+
+func (f *LinkFilter) Name() string {
+	return "link_count"
+}
+
+func (f *LinkFilter) Check(comment Comment) Decision {
+	// Count in the width-folded text so full-width "ｈｔｔｐ：／／" is a link too,
+	// but before Normalize strips the punctuation the pattern relies on.
+	text := foldWidth(comment.Text)
+	if len(linkPattern.FindAllStringIndex(text, -1)) > f.max {
+		return Decision{Verdict: Hold, Reason: "too_many_links"}
+	}
+	return accept()
+}
+
+// RepeatFilter rejects comments in which one character repeats more than
+// maxRun times in a row (reason "repeated_characters"), such as "wwwwwwwwww…".
+type RepeatFilter struct {
+	maxRun int
+}
+
+func NewRepeatFilter(maxRun int) *RepeatFilter {
+	return &RepeatFilter{maxRun: maxRun}
+}
+
+func (f *RepeatFilter) Name() string {
+	return "repeated_characters"
+}
+
+func (f *RepeatFilter) Check(comment Comment) Decision {
+	var prev rune
+	run := 0
+	for _, r := range foldWidth(comment.Text) {
+		if r == prev {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+		if run > f.maxRun {
+			return Decision{Verdict: Reject, Reason: "repeated_characters"}
+		}
+	}
+	return accept()
+}
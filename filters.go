@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"pageknock-backend/filter"
+)
+
+// loadCommentFilters builds the filter chain run on every posted comment.
+// FILTER_NG_WORDS lists comma-separated words that reject a comment and
+// FILTER_HOLD_WORDS words that hold it for review. Comments with more than
+// FILTER_MAX_LINKS links (default 2) are held, and comments repeating a
+// character more than FILTER_MAX_REPEAT times in a row (default 10) are
+// rejected.
+func loadCommentFilters() filter.Chain {
+	return filter.Chain{
+		filter.NewNGWordFilter(splitList(os.Getenv("FILTER_NG_WORDS")), filter.Reject),
+		filter.NewRepeatFilter(loadFilterInt("FILTER_MAX_REPEAT", 10)),
+		filter.NewNGWordFilter(splitList(os.Getenv("FILTER_HOLD_WORDS")), filter.Hold),
+		filter.NewLinkFilter(loadFilterInt("FILTER_MAX_LINKS", 2)),
+	}
+}
+
+func loadFilterInt(name string, defaultValue int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("invalid %s: %s", name, v)
+	}
+	return n
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
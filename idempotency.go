@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"pageknock-backend/auth"
+	"pageknock-backend/dynamo"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyPendingTimeout is how long a request holding an idempotency key
+// may take. A retry after that is processed anew, in case the server
+// processing the first request died.
+const idempotencyPendingTimeout = time.Minute
+
+func loadIdempotencyKeyTTL() time.Duration {
+	v := os.Getenv("IDEMPOTENCY_KEY_TTL")
+	if v == "" {
+		return 24 * time.Hour
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		log.Fatalf("invalid IDEMPOTENCY_KEY_TTL: %s", v)
+	}
+	return ttl
+}
+
+// submitCommentIdempotently is submitComment for a request carrying an
+// Idempotency-Key header; see serveIdempotently.
+//
+// Keys are scoped to the caller. An anonymous caller whose first request
+// was lost with its response retries without the device cookie that
+// response set, and is issued another device; its keys are therefore
+// scoped to the request body instead, so that the retry still finds the
+// first request's key.
+func submitCommentIdempotently(w http.ResponseWriter, r *http.Request, identity auth.Identity, key string, req postCommentRequest) {
+	scope := identity.UserID
+	if identity.NewDevice {
+		scope = "new-device"
+	}
+	serveIdempotently(w, r, scope, identity.NewDevice, key, req, func(w http.ResponseWriter) {
+		submitComment(w, r, identity.UserID, req)
+	})
+}
+
+// serveIdempotently answers a request carrying an Idempotency-Key header
+// with handle. The first request with a key in scope is processed and a
+// successful response is remembered for IDEMPOTENCY_KEY_TTL; a retry with
+// the same key and body gets that response again, with an
+// Idempotent-Replayed header, instead of being processed twice. A retry
+// with a different body gets 422, and one arriving while the first is still
+// processed gets 409. Failed responses are not remembered, so the request
+// can be retried with the same key. With scopeByBody the key is scoped to
+// the body as well, so a different body is processed anew.
+func serveIdempotently(w http.ResponseWriter, r *http.Request, scope string, scopeByBody bool, key string, req any, handle func(http.ResponseWriter)) {
+
+	if len(key) > maxIdempotencyKeyLength {
+		writeInvalidField(w, r, "Idempotency-Key", fieldTooLong)
+		return
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to hash request: %w", err))
+		return
+	}
+	hash := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(hash[:])
+
+	scopedKey := scope + "#" + key
+	if scopeByBody {
+		scopedKey += "#" + requestHash
+	}
+
+	now := time.Now()
+	item := dynamo.IdempotencyKeyItem{
+		Key:         scopedKey,
+		RequestHash: requestHash,
+		CreatedAt:   now.UnixMilli(),
+		ExpiresAt:   now.Add(idempotencyKeyTTL).Unix(),
+	}
+
+	existing, reserved, err := idempotencyKeyRepo.ReserveIdempotencyKey(item, now.Unix(), now.Add(-idempotencyPendingTimeout).UnixMilli())
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+	if !reserved {
+		switch {
+		case existing.RequestHash != item.RequestHash:
+			writeError(w, r, http.StatusUnprocessableEntity, codeIdempotencyKeyReused)
+		case !existing.Completed:
+			writeError(w, r, http.StatusConflict, codeIdempotencyKeyInProgress)
+		default:
+			w.Header().Set("Content-Type", existing.ContentType)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.StatusCode)
+			w.Write([]byte(existing.Body))
+		}
+		return
+	}
+
+	rec := &responseRecorder{header: http.Header{}}
+	handle(rec)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	if rec.status >= 200 && rec.status < 300 {
+		item.Completed = true
+		item.StatusCode = rec.status
+		item.ContentType = rec.header.Get("Content-Type")
+		item.Body = rec.body.String()
+		err = idempotencyKeyRepo.PutIdempotencyKey(item)
+	} else {
+		err = idempotencyKeyRepo.DeleteIdempotencyKey(item.Key)
+	}
+	if err != nil {
+		// The response stands regardless. A retry gets 409 until the key
+		// goes stale and is then processed anew.
+		log.Printf("idempotency key %s not recorded: %v", item.Key, err)
+	}
+
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// responseRecorder captures what submitComment answers, for posts made over
+// /live and for remembering the response to an idempotency key.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(data)
+}
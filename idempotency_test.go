@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"pageknock-backend/memory"
+)
+
+func useMemoryIdempotencyKeys(t *testing.T) {
+	t.Helper()
+	repo, ttl := idempotencyKeyRepo, idempotencyKeyTTL
+	idempotencyKeyRepo = memory.NewIdempotencyKeyRepository(memory.NewDB())
+	idempotencyKeyTTL = time.Hour
+	t.Cleanup(func() { idempotencyKeyRepo, idempotencyKeyTTL = repo, ttl })
+}
+
+// serve runs serveIdempotently for one request and returns its response.
+func serve(scope string, scopeByBody bool, key string, req postCommentRequest, handle func(http.ResponseWriter)) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/comment", nil)
+	serveIdempotently(w, r, scope, scopeByBody, key, req, handle)
+	return w
+}
+
+// countingHandler answers 200 with a body naming the call it answered.
+func countingHandler(calls *int) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"call":` + strconv.Itoa(*calls) + `}`))
+	}
+}
+
+func TestServeIdempotentlyReplaysResponse(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	req := postCommentRequest{Url: "https://example.com/a", Comment: "hello"}
+	var calls int
+
+	first := serve("user-1", false, "key-1", req, countingHandler(&calls))
+	retry := serve("user-1", false, "key-1", req, countingHandler(&calls))
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %q, want %d %q", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry not marked Idempotent-Replayed")
+	}
+
+	serve("user-2", false, "key-1", req, countingHandler(&calls))
+	if calls != 2 {
+		t.Error("key of another user replayed")
+	}
+}
+
+func TestServeIdempotentlyRejectsReusedKey(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	var calls int
+
+	serve("user-1", false, "key-1", postCommentRequest{Comment: "hello"}, countingHandler(&calls))
+	reused := serve("user-1", false, "key-1", postCommentRequest{Comment: "other"}, countingHandler(&calls))
+
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want 422", reused.Code)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestServeIdempotentlyRejectsRetryInFlight(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	req := postCommentRequest{Comment: "hello"}
+	var calls int
+	var inFlight *httptest.ResponseRecorder
+
+	serve("user-1", false, "key-1", req, func(w http.ResponseWriter) {
+		inFlight = serve("user-1", false, "key-1", req, countingHandler(&calls))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if inFlight.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", inFlight.Code)
+	}
+	if calls != 0 {
+		t.Errorf("retry in flight was processed")
+	}
+}
+
+func TestServeIdempotentlyForgetsFailure(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	req := postCommentRequest{Comment: "hello"}
+	var calls int
+
+	serve("user-1", false, "key-1", req, func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	retry := serve("user-1", false, "key-1", req, countingHandler(&calls))
+
+	if calls != 1 || retry.Code != http.StatusOK {
+		t.Errorf("retry after a failure = %d with %d calls, want processed", retry.Code, calls)
+	}
+}
+
+func TestServeIdempotentlyScopedByBody(t *testing.T) {
+	useMemoryIdempotencyKeys(t)
+	var calls int
+
+	// A caller without a device is issued a new one on every request, so
+	// only the key and body identify its retry.
+	serve("new-device", true, "key-1", postCommentRequest{Comment: "hello"}, countingHandler(&calls))
+	retry := serve("new-device", true, "key-1", postCommentRequest{Comment: "hello"}, countingHandler(&calls))
+	if calls != 1 || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry with the same body processed again (%d calls)", calls)
+	}
+
+	other := serve("new-device", true, "key-1", postCommentRequest{Comment: "other"}, countingHandler(&calls))
+	if calls != 2 || other.Code != http.StatusOK {
+		t.Errorf("request with another body = %d with %d calls, want processed", other.Code, calls)
+	}
+}
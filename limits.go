@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"pageknock-backend/memory"
+	"pageknock-backend/ratelimit"
+)
+
+// rateLimitConfig holds the limits applied to POST /comment (User, Ip and
+// Domain) and to POST /reportComment (Report, per account and per address).
+type rateLimitConfig struct {
+	User   ratelimit.Limit
+	Ip     ratelimit.Limit
+	Domain ratelimit.Limit
+	Report ratelimit.Limit
+}
+
+// loadRateLimiter reads RATE_LIMIT_{USER,IP,DOMAIN,REPORT}_{BURST,PER_MINUTE};
+// a burst of 0 disables that limit. With RATE_LIMIT_BACKEND=shared buckets live
+// in the storage backend (the RateLimitBucket table on DynamoDB) and are
+// shared by every instance; otherwise each process keeps its own.
+func loadRateLimiter() (*ratelimit.Limiter, rateLimitConfig) {
+	limits := rateLimitConfig{
+		User:   loadLimit("USER", ratelimit.Limit{Burst: 5, PerMinute: 10}),
+		Ip:     loadLimit("IP", ratelimit.Limit{Burst: 10, PerMinute: 30}),
+		Domain: loadLimit("DOMAIN", ratelimit.Limit{Burst: 50, PerMinute: 300}),
+		Report: loadLimit("REPORT", ratelimit.Limit{Burst: 5, PerMinute: 5}),
+	}
+
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "shared":
+		return ratelimit.NewLimiter(rateLimitBucketRepo), limits
+	case "", "local":
+		return ratelimit.NewLimiter(memory.NewRateLimitBucketRepository(memory.NewDB())), limits
+	default:
+		log.Fatalf("invalid RATE_LIMIT_BACKEND: %s", os.Getenv("RATE_LIMIT_BACKEND"))
+		return nil, rateLimitConfig{}
+	}
+}
+
+func loadLimit(name string, defaults ratelimit.Limit) ratelimit.Limit {
+	limit := defaults
+	if v := os.Getenv("RATE_LIMIT_" + name + "_BURST"); v != "" {
+		limit.Burst = parseLimitValue("RATE_LIMIT_"+name+"_BURST", v)
+	}
+	if v := os.Getenv("RATE_LIMIT_" + name + "_PER_MINUTE"); v != "" {
+		limit.PerMinute = parseLimitValue("RATE_LIMIT_"+name+"_PER_MINUTE", v)
+	}
+	if limit.Burst > 0 && limit.PerMinute <= 0 {
+		log.Fatalf("RATE_LIMIT_%s_PER_MINUTE must be positive", name)
+	}
+	return limit
+}
+
+func parseLimitValue(name string, v string) float64 {
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		log.Fatalf("invalid %s: %s", name, v)
+	}
+	return n
+}
+
+// checkPostRateLimit takes a token for the user, the IP and the site domain
+// of a comment post. When any bucket is empty it answers 429 with
+// Retry-After and returns false.
+func checkPostRateLimit(w http.ResponseWriter, r *http.Request, userId string, ip string, siteDomain string) bool {
+	return checkRateLimit(w, r,
+		ratelimit.Rule{Key: "user:" + userId, Limit: rateLimits.User},
+		ratelimit.Rule{Key: "ip:" + ip, Limit: rateLimits.Ip},
+		ratelimit.Rule{Key: "domain:" + siteDomain, Limit: rateLimits.Domain},
+	)
+}
+
+// checkReportRateLimit takes a token for the reporting account and for its
+// IP, answering 429 like checkPostRateLimit.
+func checkReportRateLimit(w http.ResponseWriter, r *http.Request, userId string, ip string) bool {
+	return checkRateLimit(w, r,
+		ratelimit.Rule{Key: "report:user:" + userId, Limit: rateLimits.Report},
+		ratelimit.Rule{Key: "report:ip:" + ip, Limit: rateLimits.Report},
+	)
+}
+
+func checkRateLimit(w http.ResponseWriter, r *http.Request, rules ...ratelimit.Rule) bool {
+	allowed, retryAfter, err := rateLimiter.Allow(rules...)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to check rate limit: %w", err))
+		return false
+	}
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited)
+		return false
+	}
+	return true
+}
+
+// loadTrustedProxies reads TRUSTED_PROXIES, the comma-separated addresses or
+// CIDR ranges of the load balancers in front of the server. X-Forwarded-For
+// is only believed on connections from them; when unset it is ignored.
+func loadTrustedProxies() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, v := range splitList(os.Getenv("TRUSTED_PROXIES")) {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				log.Fatalf("invalid TRUSTED_PROXIES entry: %s", v)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies
+}
+
+func trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp returns the address a request came from, without port. When the
+// connection comes from a trusted proxy it is the last X-Forwarded-For entry
+// not itself a trusted proxy; entries further left are supplied by the client
+// and unreliable. Any other client could set the header to whatever it likes,
+// so for them it is the connection's address.
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !trustedProxy(remote.Unmap()) {
+		return host
+	}
+
+	entries := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			break
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil || !trustedProxy(addr.Unmap()) {
+			return entry
+		}
+		host = entry
+	}
+	return host
+}
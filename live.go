@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"pageknock-backend/auth"
+	"pageknock-backend/pubsub"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	liveWriteWait = 10 * time.Second
+	// livePongWait is how long a connection may go without a pong (or any
+	// other message) before it is considered dead.
+	livePongWait   = 60 * time.Second
+	livePingPeriod = livePongWait * 9 / 10
+	// liveMaxMessageSize bounds a client message; posts are far smaller.
+	liveMaxMessageSize = 16 << 10
+	// liveSendBuffer is how many messages a client may fall behind before it
+	// is disconnected.
+	liveSendBuffer = 64
+	// liveTypingInterval is the least time between two typing indicators
+	// relayed for one connection.
+	liveTypingInterval = 2 * time.Second
+)
+
+var (
+	liveUpgrader websocket.Upgrader
+	// typingHub relays typing indicators. They are not worth resuming, so it
+	// keeps no history.
+	typingHub *pubsub.Hub
+	// liveConnections counts open /live connections, so that shutdown can
+	// wait for them to close.
+	liveConnections sync.WaitGroup
+)
+
+// loadLiveUpgrader reads LIVE_ALLOWED_ORIGINS, the comma-separated origins
+// (such as "chrome-extension://<id>") allowed to open /live, or "*" for any.
+// When unset only same-origin connections are allowed.
+func loadLiveUpgrader() websocket.Upgrader {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+
+	origins := splitList(os.Getenv("LIVE_ALLOWED_ORIGINS"))
+	switch {
+	case slices.Contains(origins, "*"):
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			return true
+		}
+	case len(origins) > 0:
+		upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || slices.Contains(origins, origin)
+		}
+	}
+	upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		code := codeWebSocketRequired
+		switch status {
+		case http.StatusForbidden:
+			code = codeForbidden
+		case http.StatusMethodNotAllowed:
+			code = codeMethodNotAllowed
+		}
+		writeError(w, r, status, code)
+	}
+	return upgrader
+}
+
+// liveClientMessage is a message from a /live client. Type is one of
+//
+//   - "join": enter the room of Url, leaving the current one. With
+//     LastEventId the events missed since are sent first.
+//   - "typing": tell the room the user is typing.
+//   - "post": post Comment (optionally replying to ParentCommentId) on the
+//     room's URL. The outcome comes back as a "result" with RequestId.
+type liveClientMessage struct {
+	Type            string `json:"type"`
+	Url             string `json:"url"`
+	LastEventId     string `json:"lastEventId"`
+	RequestId       string `json:"requestId"`
+	Comment         string `json:"comment"`
+	ParentCommentId string `json:"parentCommentId"`
+}
+
+// liveServerMessage is a message to a /live client. Type is "joined",
+// "comment" or "reaction" (with Id, to be given as lastEventId when joining
+// again, and the same Data as on /stream), "typing", "result" (Status and the
+// Data POST /comment would have answered) or "error" (Status and Error, the
+// body of an error response).
+type liveServerMessage struct {
+	Type      string          `json:"type"`
+	Id        string          `json:"id,omitempty"`
+	RequestId string          `json:"requestId,omitempty"`
+	Url       string          `json:"url,omitempty"`
+	UserId    string          `json:"userId,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     *errorBody      `json:"error,omitempty"`
+}
+
+// liveClient is one /live connection. Only writePump writes to conn; every
+// other goroutine hands it messages through send.
+type liveClient struct {
+	conn   *websocket.Conn
+	r      *http.Request
+	userId string
+	send   chan []byte
+	done   chan struct{}
+
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	// mu guards the room fields, which the read loop changes while the
+	// forwarding goroutines read them.
+	mu         sync.Mutex
+	url        string
+	comments   *pubsub.Subscription
+	typing     *pubsub.Subscription
+	lastTyping time.Time
+}
+
+// handleLive upgrades to a WebSocket connection for live page rooms. Browsers
+// cannot set headers on WebSocket requests, so the bearer token and device ID
+// may also be given as ?access_token= and ?deviceId=.
+func handleLive(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if deviceId := r.URL.Query().Get("deviceId"); deviceId != "" && r.Header.Get(auth.DeviceHeaderName) == "" {
+		r.Header.Set(auth.DeviceHeaderName, deviceId)
+	}
+
+	identity, err := authenticator.Identify(w, r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	// w.Header() carries the device cookie and header when Identify issued a
+	// new device.
+	conn, err := liveUpgrader.Upgrade(w, r, w.Header())
+	if err != nil {
+		// Upgrade has answered the client already.
+		return
+	}
+
+	liveConnections.Add(1)
+	defer liveConnections.Done()
+
+	c := &liveClient{
+		conn:   conn,
+		r:      r,
+		userId: identity.UserID,
+		send:   make(chan []byte, liveSendBuffer),
+		done:   make(chan struct{}),
+	}
+
+	writerDone := make(chan struct{})
+	go func() {
+		c.writePump(r.Context())
+		close(writerDone)
+	}()
+
+	c.readPump()
+	c.leave()
+	c.close(websocket.CloseNormalClosure, "")
+	<-writerDone
+}
+
+// close makes writePump send a close frame with code and end the connection.
+// Only the first call counts.
+func (c *liveClient) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// enqueue hands msg to writePump. A client that has fallen liveSendBuffer
+// messages behind is disconnected rather than buffered for without bound; it
+// can rejoin with the last event ID it received.
+func (c *liveClient) enqueue(msg liveServerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to encode live message: %v", err)
+		return
+	}
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- data:
+	default:
+		c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+func (c *liveClient) writePump(ctx context.Context) {
+	ticker := time.NewTicker(livePingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ctx.Done():
+			c.close(websocket.CloseGoingAway, "server shutting down")
+			c.writeClose()
+			return
+		case <-c.done:
+			c.writeClose()
+			return
+		}
+	}
+}
+
+func (c *liveClient) writeClose() {
+	if c.closeCode == websocket.CloseAbnormalClosure {
+		return
+	}
+	message := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(liveWriteWait))
+}
+
+// readPump handles client messages until the connection fails or closes.
+func (c *liveClient) readPump() {
+	c.conn.SetReadLimit(liveMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(livePongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(livePongWait))
+
+		var msg liveClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.fail("", http.StatusBadRequest, codeInvalidJSON)
+			continue
+		}
+
+		switch msg.Type {
+		case "join":
+			c.join(msg)
+		case "typing":
+			c.typed()
+		case "post":
+			c.post(msg)
+		default:
+			c.fail(msg.RequestId, http.StatusBadRequest, codeInvalidRequest, fieldError{Field: "type", Code: fieldUnknownValue})
+		}
+	}
+}
+
+func (c *liveClient) join(msg liveClientMessage) {
+	if msg.Url == "" {
+		c.fail("", http.StatusBadRequest, codeInvalidRequest, fieldError{Field: "url", Code: fieldRequired})
+		return
+	}
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(msg.Url)
+	if err != nil {
+		c.fail("", http.StatusBadRequest, codeInvalidRequest, fieldError{Field: "url", Code: fieldInvalid})
+		return
+	}
+
+	var lastId uint64
+	if msg.LastEventId != "" {
+		lastId, err = strconv.ParseUint(msg.LastEventId, 10, 64)
+		if err != nil {
+			c.fail("", http.StatusBadRequest, codeInvalidRequest, fieldError{Field: "lastEventId", Code: fieldInvalid})
+			return
+		}
+	}
+
+	c.leave()
+
+	comments := commentHub.Subscribe(urlTopic(canonicalUrl), lastId, msg.LastEventId != "")
+	typing := typingHub.Subscribe(urlTopic(canonicalUrl), 0, false)
+
+	c.mu.Lock()
+	c.url = canonicalUrl
+	c.comments = comments
+	c.typing = typing
+	c.mu.Unlock()
+
+	c.enqueue(liveServerMessage{Type: "joined", Url: canonicalUrl})
+	for _, event := range comments.Backlog {
+		c.enqueue(eventMessage(event))
+	}
+	go c.forward(comments)
+	go c.forward(typing)
+}
+
+// leave closes the current room's subscriptions, which ends their forward
+// goroutines.
+func (c *liveClient) leave() {
+	c.mu.Lock()
+	comments, typing := c.comments, c.typing
+	c.url, c.comments, c.typing = "", nil, nil
+	c.mu.Unlock()
+
+	if comments != nil {
+		comments.Close()
+		typing.Close()
+	}
+}
+
+// forward relays a room subscription to the client. When the hub closes the
+// subscription while it is still the current one, the client fell behind and
+// is disconnected, like in enqueue.
+func (c *liveClient) forward(sub *pubsub.Subscription) {
+	for event := range sub.C {
+		if event.Name == "typing" {
+			if userId := string(event.Data); userId != c.userId {
+				c.enqueue(liveServerMessage{Type: "typing", UserId: userId})
+			}
+			continue
+		}
+		c.enqueue(eventMessage(event))
+	}
+
+	c.mu.Lock()
+	current := c.comments == sub || c.typing == sub
+	c.mu.Unlock()
+	if current {
+		c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+func eventMessage(event pubsub.Event) liveServerMessage {
+	return liveServerMessage{
+		Type: event.Name,
+		Id:   strconv.FormatUint(event.ID, 10),
+		Data: event.Data,
+	}
+}
+
+func (c *liveClient) typed() {
+	now := time.Now()
+
+	c.mu.Lock()
+	url := c.url
+	throttled := now.Sub(c.lastTyping) < liveTypingInterval
+	if url != "" && !throttled {
+		c.lastTyping = now
+	}
+	c.mu.Unlock()
+
+	if url == "" || throttled {
+		return
+	}
+	typingHub.Publish("typing", []string{urlTopic(url)}, []byte(c.userId))
+}
+
+// post submits a comment through submitComment, exactly as POST /comment
+// would, and reports what it answered.
+func (c *liveClient) post(msg liveClientMessage) {
+	c.mu.Lock()
+	url := c.url
+	c.mu.Unlock()
+
+	if url == "" {
+		c.fail(msg.RequestId, http.StatusConflict, codeNotInRoom)
+		return
+	}
+
+	rec := &responseRecorder{header: http.Header{}}
+	submitComment(rec, c.r, c.userId, postCommentRequest{
+		Url:             url,
+		Comment:         msg.Comment,
+		ParentCommentId: msg.ParentCommentId,
+	})
+
+	c.enqueue(liveServerMessage{
+		Type:      "result",
+		RequestId: msg.RequestId,
+		Status:    rec.status,
+		Data:      bytes.TrimSpace(rec.body.Bytes()),
+	})
+}
+
+// fail sends an "error" message with the error envelope's body for code.
+func (c *liveClient) fail(requestId string, status int, code errorCode, details ...fieldError) {
+	body := newErrorBody(c.r, code, details...)
+	c.enqueue(liveServerMessage{Type: "error", RequestId: requestId, Status: status, Error: &body})
+}
@@ -2,14 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"pageknock-backend/auth"
 	"pageknock-backend/dynamo"
+	"pageknock-backend/filter"
+	"pageknock-backend/memory"
+	"pageknock-backend/outbox"
+	"pageknock-backend/pubsub"
+	"pageknock-backend/ratelimit"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -18,32 +32,250 @@ import (
 
 var (
 	client                  *dynamodb.Client
-	commentRepo             *dynamo.CommentRepository
-	commentLogRepo          *dynamo.CommentLogRepository
-	pageGlobalStructureRepo *dynamo.PageGlobalStructureRepository
-	pageStructureRepo       *dynamo.PageStructureRepository
-	recentDomainCommentRepo *dynamo.RecentDomainCommentRepository
-	recentGlobalCommentRepo *dynamo.RecentGlobalCommentRepository
+	commentRepo             dynamo.CommentStore
+	commentLogRepo          dynamo.CommentLogStore
+	pageGlobalStructureRepo dynamo.PageGlobalStructureStore
+	pageStructureRepo       dynamo.PageStructureStore
+	recentDomainCommentRepo dynamo.RecentDomainCommentStore
+	recentGlobalCommentRepo dynamo.RecentGlobalCommentStore
+	commentTransactionRepo  dynamo.CommentTransactionStore
+	cursorCodec             *dynamo.CursorCodec
+	urlCanonicalizer        *dynamo.URLCanonicalizer
+	authenticator           *auth.Authenticator
+	deviceLinkRepo          dynamo.DeviceLinkStore
+	rateLimitBucketRepo     dynamo.RateLimitBucketStore
+	rateLimiter             *ratelimit.Limiter
+	rateLimits              rateLimitConfig
+	trustedProxies          []netip.Prefix
+	heldCommentRepo         dynamo.HeldCommentStore
+	commentFilters          filter.Chain
+	adminUserIds            map[string]bool
+	commentRevisionRepo     dynamo.CommentRevisionStore
+	editWindow              time.Duration
+	reactionRepo            dynamo.ReactionStore
+	reactionTypes           []string
+	reportRepo              dynamo.ReportStore
+	moderationQueueRepo     dynamo.ModerationQueueStore
+	moderatorUserIds        map[string]bool
+	reportHideThreshold     int
+	roleRepo                dynamo.RoleStore
+	banRepo                 dynamo.BanStore
+	adminAuditRepo          dynamo.AdminAuditStore
+	commentHub              *pubsub.Hub
+	outboxRepo              dynamo.OutboxStore
+	outboxWorker            *outbox.Worker
+	idempotencyKeyRepo      dynamo.IdempotencyKeyStore
+	idempotencyKeyTTL       time.Duration
 )
 
+var errInvalidLimit = errors.New("invalid limit")
+
+// maxCommentKeyAttempts bounds the unixTime values tried for a new comment
+// whose key is taken by another comment on the same URL.
+const maxCommentKeyAttempts = 3
+
+// shutdownTimeout bounds how long in-flight requests may take to finish once
+// the server is asked to stop.
+const shutdownTimeout = 15 * time.Second
+
 func init() {
 
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using system environment")
 	}
 
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "memory":
+		initMemoryRepositories()
+	default:
+		initDynamoRepositories()
+	}
+
+	cursorCodec = dynamo.NewCursorCodec(loadSecret("CURSOR_SECRET"))
+	urlCanonicalizer = loadURLCanonicalizer()
+	authenticator = loadAuthenticator()
+	rateLimiter, rateLimits = loadRateLimiter()
+	trustedProxies = loadTrustedProxies()
+	commentFilters = loadCommentFilters()
+	adminUserIds = loadAdminUserIds()
+	editWindow = loadEditWindow()
+	reactionTypes = loadReactionTypes()
+	moderatorUserIds = loadModeratorUserIds()
+	reportHideThreshold = loadReportHideThreshold()
+	commentHub = loadCommentHub()
+	typingHub = pubsub.NewHub(0, liveSendBuffer)
+	liveUpgrader = loadLiveUpgrader()
+	idempotencyKeyTTL = loadIdempotencyKeyTTL()
+}
+
+// loadSecret returns the signing key in the environment variable name. Every
+// server instance must share the same key for signed values (cursors, device
+// IDs) to work across them; without it a random per-process key is used.
+func loadSecret(name string) []byte {
+	if secret := os.Getenv(name); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Printf("%s is not set, using a random key", name)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("failed to generate %s: %v", name, err)
+	}
+	return secret
+}
+
+// loadURLCanonicalizer builds the canonicalizer from URL_TRACKING_PARAMS
+// (comma separated, "prefix*" allowed) and URL_TRAILING_SLASH
+// (strip, keep or add).
+func loadURLCanonicalizer() *dynamo.URLCanonicalizer {
+	trackingParams := dynamo.DefaultTrackingParams
+	if params := os.Getenv("URL_TRACKING_PARAMS"); params != "" {
+		trackingParams = strings.Split(params, ",")
+	}
+
+	trailingSlash := dynamo.TrailingSlashPolicy(os.Getenv("URL_TRAILING_SLASH"))
+	switch trailingSlash {
+	case "", dynamo.TrailingSlashStrip, dynamo.TrailingSlashKeep, dynamo.TrailingSlashAdd:
+	default:
+		log.Fatalf("invalid URL_TRAILING_SLASH: %s", trailingSlash)
+	}
+
+	return dynamo.NewURLCanonicalizer(trackingParams, trailingSlash)
+}
+
+// loadAuthenticator configures bearer token verification from
+// AUTH_TOKEN_SECRET, AUTH_ISSUER and AUTH_AUDIENCE; the server refuses to
+// start with a secret but no issuer or audience. Posting without a token,
+// under a device ID signed with DEVICE_COOKIE_SECRET, is only allowed when
+// ANONYMOUS_POSTING is "true".
+func loadAuthenticator() *auth.Authenticator {
+	secret := os.Getenv("AUTH_TOKEN_SECRET")
+	allowAnonymous := os.Getenv("ANONYMOUS_POSTING") == "true"
+
+	if secret == "" {
+		log.Println("AUTH_TOKEN_SECRET is not set, bearer tokens will be rejected")
+	} else if os.Getenv("AUTH_ISSUER") == "" || os.Getenv("AUTH_AUDIENCE") == "" {
+		log.Fatal("AUTH_ISSUER and AUTH_AUDIENCE are required with AUTH_TOKEN_SECRET; leave AUTH_TOKEN_SECRET unset for anonymous posting only")
+	}
+	if secret == "" && !allowAnonymous {
+		log.Println("ANONYMOUS_POSTING is not enabled either, nobody can post comments")
+	}
+
+	verifier := auth.NewVerifier([]byte(secret), os.Getenv("AUTH_ISSUER"), os.Getenv("AUTH_AUDIENCE"))
+	devices := auth.NewDeviceIdentities(loadSecret("DEVICE_COOKIE_SECRET"))
+	linkedUser := func(deviceId string) (string, bool, error) {
+		link, ok, err := deviceLinkRepo.GetDeviceLink(deviceId)
+		return link.UserID, ok, err
+	}
+	return auth.NewAuthenticator(verifier, devices, linkedUser, allowAnonymous)
+}
+
+func initMemoryRepositories() {
+	if mode := loadDerivedTables(); mode != derivedByTransaction {
+		log.Fatalf("DERIVED_TABLES=%s requires the DynamoDB backend", mode)
+	}
+
+	db := memory.NewDB()
+	commentRepo = memory.NewCommentRepository(db)
+	commentLogRepo = memory.NewCommentLogRepository(db)
+	pageGlobalStructureRepo = memory.NewPageGlobalStructureRepository(db)
+	pageStructureRepo = memory.NewPageStructureRepository(db)
+	recentDomainCommentRepo = memory.NewRecentDomainCommentRepository(db)
+	recentGlobalCommentRepo = memory.NewRecentGlobalCommentRepository(db)
+	commentTransactionRepo = memory.NewCommentTransactionRepository(db)
+	deviceLinkRepo = memory.NewDeviceLinkRepository(db)
+	rateLimitBucketRepo = memory.NewRateLimitBucketRepository(db)
+	heldCommentRepo = memory.NewHeldCommentRepository(db)
+	commentRevisionRepo = memory.NewCommentRevisionRepository(db)
+	reactionRepo = memory.NewReactionRepository(db)
+	reportRepo = memory.NewReportRepository(db)
+	moderationQueueRepo = memory.NewModerationQueueRepository(db)
+	roleRepo = memory.NewRoleRepository(db)
+	banRepo = memory.NewBanRepository(db)
+	adminAuditRepo = memory.NewAdminAuditRepository(db)
+	outboxRepo = memory.NewOutboxRepository(db)
+	idempotencyKeyRepo = memory.NewIdempotencyKeyRepository(db)
+}
+
+// How the tables derived from the Comment table are maintained, set by
+// DERIVED_TABLES. With derivedByTransaction, the default, requests write
+// every table in one transaction. With derivedByStream requests write only
+// the Comment table (and CommentLog) and cmd/streamworker maintains
+// PageStructure, PageGlobalStructure and the Recent* tables from its stream.
+// With derivedByOutbox requests leave PageStructure and PageGlobalStructure
+// to the outbox worker running in every server.
+const (
+	derivedByTransaction = "transaction"
+	derivedByStream      = "stream"
+	derivedByOutbox      = "outbox"
+)
+
+func loadDerivedTables() string {
+	switch v := os.Getenv("DERIVED_TABLES"); v {
+	case "":
+		return derivedByTransaction
+	case derivedByTransaction, derivedByStream, derivedByOutbox:
+		return v
+	default:
+		log.Fatalf("invalid DERIVED_TABLES %q: want transaction, stream or outbox", v)
+		return ""
+	}
+}
+
+func initDynamoRepositories() {
 	region := os.Getenv("AWS_REGION")
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
 	if err != nil {
 		log.Fatalf("failed to load AWS config: %v", err)
 	}
 	client = dynamodb.NewFromConfig(cfg)
-	commentRepo = dynamo.NewCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_COMMENT"))
-	commentLogRepo = dynamo.NewCommentLogRepository(client, os.Getenv("DYNAMO_TABLE_NAME_COMMENTLOG"))
-	pageGlobalStructureRepo = dynamo.NewPageGlobalStructureRepository(client, os.Getenv("DYNAMO_TABLE_NAME_PAGEGLOBALSTRUCTURE"))
-	pageStructureRepo = dynamo.NewPageStructureRepository(client, os.Getenv("DYNAMO_TABLE_NAME_PAGESTRUCTURE"))
-	recentDomainCommentRepo = dynamo.NewRecentDomainCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_RECENTDOMAINCOMMENT"))
-	recentGlobalCommentRepo = dynamo.NewRecentGlobalCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_RECENTGLOBALCOMMENT"))
+
+	tables := dynamo.TableNames{
+		Comment:             os.Getenv("DYNAMO_TABLE_NAME_COMMENT"),
+		CommentLog:          os.Getenv("DYNAMO_TABLE_NAME_COMMENTLOG"),
+		PageGlobalStructure: os.Getenv("DYNAMO_TABLE_NAME_PAGEGLOBALSTRUCTURE"),
+		PageStructure:       os.Getenv("DYNAMO_TABLE_NAME_PAGESTRUCTURE"),
+		RecentDomainComment: os.Getenv("DYNAMO_TABLE_NAME_RECENTDOMAINCOMMENT"),
+		RecentGlobalComment: os.Getenv("DYNAMO_TABLE_NAME_RECENTGLOBALCOMMENT"),
+		CommentRevision:     os.Getenv("DYNAMO_TABLE_NAME_COMMENTREVISION"),
+		Reaction:            os.Getenv("DYNAMO_TABLE_NAME_REACTION"),
+		Report:              os.Getenv("DYNAMO_TABLE_NAME_REPORT"),
+		ModerationQueue:     os.Getenv("DYNAMO_TABLE_NAME_MODERATIONQUEUE"),
+		AppliedCommentEvent: os.Getenv("DYNAMO_TABLE_NAME_APPLIEDCOMMENTEVENT"),
+		Outbox:              os.Getenv("DYNAMO_TABLE_NAME_OUTBOX"),
+	}
+	commentRepo = dynamo.NewCommentRepository(client, tables.Comment, dynamo.CommentIndexNames{
+		UserID:    os.Getenv("DYNAMO_INDEX_NAME_COMMENT_USERID"),
+		CommentID: os.Getenv("DYNAMO_INDEX_NAME_COMMENT_COMMENTID"),
+		Parent:    os.Getenv("DYNAMO_INDEX_NAME_COMMENT_PARENT"),
+	})
+	commentLogRepo = dynamo.NewCommentLogRepository(client, tables.CommentLog)
+	pageGlobalStructureRepo = dynamo.NewPageGlobalStructureRepository(client, tables.PageGlobalStructure)
+	pageStructureRepo = dynamo.NewPageStructureRepository(client, tables.PageStructure)
+	recentDomainCommentRepo = dynamo.NewRecentDomainCommentRepository(client, tables.RecentDomainComment)
+	recentGlobalCommentRepo = dynamo.NewRecentGlobalCommentRepository(client, tables.RecentGlobalComment)
+	outboxRepo = dynamo.NewOutboxRepository(client, tables.Outbox)
+	switch loadDerivedTables() {
+	case derivedByStream:
+		commentTransactionRepo = dynamo.NewStreamDerivedCommentTransactionRepository(client, tables)
+	case derivedByOutbox:
+		repo := dynamo.NewOutboxCommentTransactionRepository(client, tables)
+		commentTransactionRepo = repo
+		outboxWorker = outbox.NewWorker(outboxRepo, repo, loadOutboxConfig())
+	default:
+		commentTransactionRepo = dynamo.NewCommentTransactionRepository(client, tables)
+	}
+	deviceLinkRepo = dynamo.NewDeviceLinkRepository(client, os.Getenv("DYNAMO_TABLE_NAME_DEVICELINK"))
+	rateLimitBucketRepo = dynamo.NewRateLimitBucketRepository(client, os.Getenv("DYNAMO_TABLE_NAME_RATELIMITBUCKET"))
+	heldCommentRepo = dynamo.NewHeldCommentRepository(client, os.Getenv("DYNAMO_TABLE_NAME_HELDCOMMENT"))
+	commentRevisionRepo = dynamo.NewCommentRevisionRepository(client, tables.CommentRevision)
+	reactionRepo = dynamo.NewReactionRepository(client, tables.Reaction)
+	reportRepo = dynamo.NewReportRepository(client, tables.Report)
+	moderationQueueRepo = dynamo.NewModerationQueueRepository(client, tables.ModerationQueue)
+	roleRepo = dynamo.NewRoleRepository(client, os.Getenv("DYNAMO_TABLE_NAME_ROLE"))
+	banRepo = dynamo.NewBanRepository(client, os.Getenv("DYNAMO_TABLE_NAME_BAN"))
+	adminAuditRepo = dynamo.NewAdminAuditRepository(client, os.Getenv("DYNAMO_TABLE_NAME_ADMINAUDIT"))
+	idempotencyKeyRepo = dynamo.NewIdempotencyKeyRepository(client, os.Getenv("DYNAMO_TABLE_NAME_IDEMPOTENCYKEY"))
 }
 
 func main() {
@@ -51,46 +283,178 @@ func main() {
 	http.HandleFunc("/getPageGlobalStructure", handleGetPageGlobalStructure)
 	http.HandleFunc("/getPageStructureBySiteDomain", handleGetPageStructureBySiteDomain)
 	http.HandleFunc("/getRecentGlobalCommnet", handleGetRecentGlobalCommnet)
+	http.HandleFunc("/getComments", handleGetComments)
+	http.HandleFunc("/getCommentTree", handleGetCommentTree)
+	http.HandleFunc("/getRecentDomainComment", handleGetRecentDomainComment)
+	http.HandleFunc("/stream", handleStream)
+	http.HandleFunc("/live", handleLive)
+	http.HandleFunc("/device", handleGetDevice)
+	http.HandleFunc("/linkDevice", handleLinkDevice)
+	http.HandleFunc("/deleteComment", handleDeleteComment)
+	http.HandleFunc("/editComment", handleEditComment)
+	http.HandleFunc("/getCommentRevisions", handleGetCommentRevisions)
+	http.HandleFunc("/reactComment", handleReactComment)
+	http.HandleFunc("/reportComment", handleReportComment)
+	http.HandleFunc("/moderation/queue", handleGetModerationQueue)
+	http.HandleFunc("/moderation/approve", handleModerateComment("approve"))
+	http.HandleFunc("/moderation/remove", handleModerateComment("remove"))
+	http.HandleFunc("/moderation/held", handleGetHeldComments)
+	http.HandleFunc("/moderation/held/approve", handleModerateHeldComment("approve"))
+	http.HandleFunc("/moderation/held/discard", handleModerateHeldComment("discard"))
+	http.HandleFunc("/admin/comments/remove", handleAdminRemoveComment)
+	http.HandleFunc("/admin/bans", handleAdminGetBans)
+	http.HandleFunc("/admin/bans/add", handleAdminAddBan)
+	http.HandleFunc("/admin/bans/remove", handleAdminRemoveBan)
+	http.HandleFunc("/admin/roles", handleAdminGetRoles)
+	http.HandleFunc("/admin/roles/assign", handleAdminAssignRole)
+	http.HandleFunc("/admin/roles/revoke", handleAdminRevokeRole)
+	http.HandleFunc("/admin/audit", handleAdminGetAudit)
+	http.HandleFunc("/admin/outbox", handleAdminGetOutbox)
+	http.HandleFunc("/admin/outbox/replay", handleAdminReplayOutbox)
+
+	// Request contexts derive from ctx, so that /stream and /live, which
+	// never finish on their own, end when the server is asked to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// A task interrupted by shutdown is retried once its claim expires.
+	if outboxWorker != nil {
+		go outboxWorker.Run(ctx)
+	}
+
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: withRequestId(http.DefaultServeMux),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		fmt.Println("Server running at http://localhost:8080")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
 
-	fmt.Println("Server running at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// Shutdown does not track hijacked connections; wait for /live ones to
+	// send their close frames.
+	liveClosed := make(chan struct{})
+	go func() {
+		liveConnections.Wait()
+		close(liveClosed)
+	}()
+	select {
+	case <-liveClosed:
+	case <-shutdownCtx.Done():
+		log.Println("shutdown: live connections did not close in time")
+	}
 }
 
 func enableCORS(w http.ResponseWriter, r *http.Request) {
 	if strings.Contains(r.Host, "localhost") {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Device-Id, Last-Event-ID, Idempotency-Key, X-Request-Id")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Device-Id, Idempotent-Replayed, X-Request-Id")
+	}
+}
+
+// parsePageRequest reads the cursor and limit parameters shared by every
+// list endpoint. scope binds the cursor to one query (see dynamo.CursorCodec).
+func parsePageRequest(scope string, cursor string, limit string) (dynamo.PageRequest, error) {
+	startKey, err := cursorCodec.Decode(scope, cursor)
+	if err != nil {
+		return dynamo.PageRequest{}, err
+	}
+
+	page := dynamo.PageRequest{StartKey: startKey}
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return dynamo.PageRequest{}, errInvalidLimit
+		}
+		page.Limit = int32(min(n, dynamo.MaxPageLimit))
+	}
+	return page, nil
+}
+
+func writePageRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errInvalidLimit) {
+		writeInvalidField(w, r, "limit", fieldInvalid)
+		return
 	}
+	writeInvalidField(w, r, "cursor", fieldInvalid)
 }
 
 func handleGetPageStructureBySiteDomain(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		SiteDomain string `json:"siteDomain"`
+		Cursor     string `json:"cursor"`
+		Limit      int    `json:"limit"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
 		return
 	}
 
 	defer r.Body.Close()
 
-	if req.SiteDomain == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	if !requireFields(w, r, map[string]bool{"siteDomain": req.SiteDomain != ""}) {
+		return
+	}
+
+	siteDomain, err := urlCanonicalizer.CanonicalizeSiteDomain(req.SiteDomain)
+	if err != nil {
+		writeInvalidField(w, r, "siteDomain", fieldInvalid)
+		return
+	}
+
+	limit := ""
+	if req.Limit != 0 {
+		limit = strconv.Itoa(req.Limit)
+	}
+	scope := "pageStructure:" + siteDomain
+	page, err := parsePageRequest(scope, req.Cursor, limit)
+	if err != nil {
+		writePageRequestError(w, r, err)
 		return
 	}
 
-	records, err := pageStructureRepo.GetStructureBySiteDomain(req.SiteDomain)
+	records, lastKey, err := pageStructureRepo.GetStructureBySiteDomain(siteDomain, page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch global structure: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("failed to fetch global structure: %w", err))
 		return
 	}
 
-	response := make([]dynamo.PageStructureResponse, 0, len(records))
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.PageStructureListResponse{
+		Structures: make([]dynamo.PageStructureResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
 	for _, rec := range records {
-		response = append(response, dynamo.PageStructureResponse{
+		response.Structures = append(response.Structures, dynamo.PageStructureResponse{
 			Url:            rec.Url,
 			CommentCount:   rec.CommentCount,
 			LatestUnixTime: rec.LatestUnixTime,
@@ -104,15 +468,35 @@ func handleGetPageStructureBySiteDomain(w http.ResponseWriter, r *http.Request)
 func handleGetPageGlobalStructure(w http.ResponseWriter, r *http.Request) {
 
 	enableCORS(w, r)
-	records, err := pageGlobalStructureRepo.GetGlobalStructure()
+	scope := "pageGlobalStructure"
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch global structure: %v", err), http.StatusInternalServerError)
+		writePageRequestError(w, r, err)
 		return
 	}
 
-	response := make([]dynamo.PageGlobalStructureResponse, 0, len(records))
+	records, lastKey, err := pageGlobalStructureRepo.GetGlobalStructure(page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch global structure: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.PageGlobalStructureListResponse{
+		Structures: make([]dynamo.PageGlobalStructureResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
 	for _, rec := range records {
-		response = append(response, dynamo.PageGlobalStructureResponse{
+		response.Structures = append(response.Structures, dynamo.PageGlobalStructureResponse{
 			SiteDomain: rec.SiteDomain,
 			UrlCount:   rec.UrlCount,
 		})
@@ -125,20 +509,45 @@ func handleGetPageGlobalStructure(w http.ResponseWriter, r *http.Request) {
 func handleGetRecentGlobalCommnet(w http.ResponseWriter, r *http.Request) {
 
 	enableCORS(w, r)
-	records, err := recentGlobalCommentRepo.GetRecentGlobalComment()
+	scope := "recentGlobalComment"
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := recentGlobalCommentRepo.GetRecentGlobalComment(page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch global structure: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch global structure: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
 		return
 	}
 
-	response := make([]dynamo.RecentGlobalCommentResponse, 0, len(records))
+	response := dynamo.RecentGlobalCommentListResponse{
+		Comments:   make([]dynamo.RecentGlobalCommentResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
 	for _, rec := range records {
-		response = append(response, dynamo.RecentGlobalCommentResponse{
+		if rec.Hidden {
+			continue
+		}
+		response.Comments = append(response.Comments, dynamo.RecentGlobalCommentResponse{
 			UnixTime:  rec.UnixTime,
 			Comment:   rec.Comment,
 			CommentId: rec.CommentId,
 			Url:       rec.Url,
 			UserID:    rec.UserID,
+			EditedAt:  rec.EditedAt,
+			Reactions: reactionCounts(rec.Reactions),
 		})
 	}
 
@@ -146,127 +555,299 @@ func handleGetRecentGlobalCommnet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func handlePostComment(w http.ResponseWriter, r *http.Request) {
+func handleGetRecentDomainComment(w http.ResponseWriter, r *http.Request) {
 
 	enableCORS(w, r)
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		Url     string `json:"url"`
-		Comment string `json:"comment"`
+	if !requireFields(w, r, map[string]bool{"siteDomain": r.URL.Query().Get("siteDomain") != ""}) {
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	siteDomain, err := urlCanonicalizer.CanonicalizeSiteDomain(r.URL.Query().Get("siteDomain"))
+	if err != nil {
+		writeInvalidField(w, r, "siteDomain", fieldInvalid)
 		return
 	}
 
-	defer r.Body.Close()
-
-	if req.Url == "" || req.Comment == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	scope := "recentDomainComment:" + siteDomain
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
 		return
 	}
 
-	domain, err := dynamo.GetDomainWithScheme(req.Url)
+	records, lastKey, err := recentDomainCommentRepo.GetRecentDomainComment(siteDomain, page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("URL変換処理失敗: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("failed to fetch domain comments: %w", err))
 		return
 	}
 
-	commentId := dynamo.GenerateCommentId()
-	nowUnix := dynamo.GetUnixMillsecound()
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
 
-	baseFieldDatas := dynamo.BaseFieldDatas{
-		Comment:    req.Comment,
-		CommentId:  commentId,
-		SiteDomain: domain,
-		Now:        nowUnix,
-		Req:        r,
-		Url:        req.Url,
-		UserId:     "1",
+	response := dynamo.RecentDomainCommentListResponse{
+		Comments:   make([]dynamo.RecentGlobalCommentResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		if rec.Hidden {
+			continue
+		}
+		response.Comments = append(response.Comments, dynamo.RecentGlobalCommentResponse{
+			UnixTime:  rec.UnixTime,
+			Comment:   rec.Comment,
+			CommentId: rec.CommentId,
+			Url:       rec.Url,
+			UserID:    rec.UserID,
+			EditedAt:  rec.EditedAt,
+			Reactions: reactionCounts(rec.Reactions),
+		})
 	}
 
-	tableRecords := dynamo.GenerateAllTableRecords(baseFieldDatas)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	err = commentRepo.PutComment(tableRecords.CommentItem)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("DynamoDB書き込み失敗: %v", err), http.StatusInternalServerError)
+func handleGetComments(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
 		return
 	}
 
-	err = recentGlobalCommentRepo.PutRecentGlobalComment(tableRecords.RecentGlobalCommentItem)
+	if !requireFields(w, r, map[string]bool{"url": r.URL.Query().Get("url") != ""}) {
+		return
+	}
+
+	url, err := urlCanonicalizer.Canonicalize(r.URL.Query().Get("url"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("DynamoDB書き込み失敗: %v", err), http.StatusInternalServerError)
+		writeInvalidField(w, r, "url", fieldInvalid)
 		return
 	}
 
-	err = recentDomainCommentRepo.PutRecentDomainComment(tableRecords.RecentDomainCommentItem)
+	scope := "comment:" + url
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("DynamoDB書き込み失敗: %v", err), http.StatusInternalServerError)
+		writePageRequestError(w, r, err)
 		return
 	}
 
-	err = commentLogRepo.PutCommentLog(tableRecords.CommentLogItem)
+	records, lastKey, err := commentRepo.GetLatestCommentsByURL(url, page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("DynamoDB書き込み失敗: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comments: %w", err))
 		return
 	}
 
-	err = handleStructureProcess(tableRecords, baseFieldDatas)
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("DynamoDB書き込み失敗: %v", err), http.StatusInternalServerError)
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
 		return
 	}
 
-	resp := map[string]string{
-		"message": "Insert succeeded!",
+	response := dynamo.CommentListResponse{
+		Comments:   make([]dynamo.CommentResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		if rec.Hidden {
+			continue
+		}
+		response.Comments = append(response.Comments, dynamo.CommentResponse{
+			UnixTime:        rec.UnixTime,
+			Comment:         rec.Comment,
+			CommentId:       rec.CommentId,
+			UserID:          rec.UserID,
+			EditedAt:        rec.EditedAt,
+			ParentCommentId: rec.ParentCommentId,
+			ReplyCount:      rec.ReplyCount,
+			Reactions:       reactionCounts(rec.Reactions),
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(response)
 }
 
-func handleStructureProcess(tableRecords dynamo.AllTableRecords, baseFieldDatas dynamo.BaseFieldDatas) error {
-	isExists, err := pageStructureRepo.ExistsStructureBySiteDomainAndURL(baseFieldDatas.SiteDomain, baseFieldDatas.Url)
+func handlePostComment(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	identity, err := authenticator.Identify(w, r)
 	if err != nil {
-		return err //Failed to fetch data from DynamoDB
+		writeAuthError(w, r, err)
+		return
 	}
 
-	if isExists {
+	var req postCommentRequest
 
-		err := pageStructureRepo.IncrementStructureCommentCountByURL(baseFieldDatas.SiteDomain, baseFieldDatas.Url, baseFieldDatas.Now)
-		if err != nil {
-			return err //Failed to fetch data from DynamoDB
-		}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
 
-	} else {
-		PutStructureErr := pageStructureRepo.PutStructure(tableRecords.PageStructureItem)
-		if PutStructureErr != nil {
-			return err //Failed to write data to DynamoDB
-		}
+	defer r.Body.Close()
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		submitCommentIdempotently(w, r, identity, key, req)
+		return
+	}
+	submitComment(w, r, identity.UserID, req)
+}
+
+type postCommentRequest struct {
+	Url             string `json:"url"`
+	Comment         string `json:"comment"`
+	ParentCommentId string `json:"parentCommentId"`
+}
+
+// submitComment validates and stores a comment by userId and answers on w.
+// It is shared by POST /comment and the "post" message of /live, so both go
+// through the same checks; r supplies the client's IP and user agent.
+func submitComment(w http.ResponseWriter, r *http.Request, userId string, req postCommentRequest) {
+
+	if !requireFields(w, r, map[string]bool{"url": req.Url != "", "comment": req.Comment != ""}) {
+		return
+	}
+
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(req.Url)
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
 	}
 
-	isGlobalStructureExists, err := pageGlobalStructureRepo.ExistsGlobalStructureBySiteDomainAndURL(baseFieldDatas.SiteDomain)
+	domain, err := dynamo.GetDomainWithScheme(canonicalUrl)
 	if err != nil {
-		return err //Failed to fetch data from DynamoDB
+		writeInternalError(w, r, fmt.Errorf("URL conversion failed: %w", err))
+		return
 	}
 
-	if isGlobalStructureExists {
+	if !checkBans(w, r, userId, clientIp(r), domain) {
+		return
+	}
 
-		err := pageGlobalStructureRepo.IncrementGlobalStructureUrlCountByURL(baseFieldDatas.SiteDomain)
+	if !checkPostRateLimit(w, r, userId, clientIp(r), domain) {
+		return
+	}
+
+	var parent *dynamo.CommentItem
+	if req.ParentCommentId != "" {
+		item, ok, err := commentRepo.GetCommentByID(req.ParentCommentId)
 		if err != nil {
-			return err //Failed to fetch data from DynamoDB
+			writeInternalError(w, r, fmt.Errorf("failed to fetch parent comment: %w", err))
+			return
+		}
+		if !ok || item.Url != canonicalUrl {
+			writeInvalidField(w, r, "parentCommentId", fieldNotFound)
+			return
+		}
+		parent = &item
+	}
+
+	commentId := dynamo.GenerateCommentId()
+	nowUnix := dynamo.GetUnixMillsecound()
+
+	decision := commentFilters.Run(filter.Comment{
+		Text:       req.Comment,
+		Url:        canonicalUrl,
+		SiteDomain: domain,
+		UserId:     userId,
+	})
+	switch decision.Verdict {
+	case filter.Reject:
+		writeError(w, r, http.StatusUnprocessableEntity, codeCommentRejected, fieldError{Field: "comment", Code: errorCode(decision.Reason)})
+		return
+	case filter.Hold:
+		held := dynamo.HeldCommentItem{
+			GlobalKey:  "GLOBAL",
+			UnixTime:   nowUnix,
+			Comment:    req.Comment,
+			CommentId:  commentId,
+			Url:        canonicalUrl,
+			SiteDomain: domain,
+			UserID:     userId,
+			Ip:         clientIp(r),
+			UserAgent:  dynamo.GetUserAgent(r),
+			Filter:     decision.Filter,
+			Reason:     decision.Reason,
+
+			ParentCommentId: req.ParentCommentId,
 		}
+		if err := heldCommentRepo.PutHeldComment(held); err != nil {
+			writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message":   "Held for review",
+			"reason":    decision.Reason,
+			"commentId": commentId,
+		})
+		return
+	}
+
+	baseFieldDatas := dynamo.BaseFieldDatas{
+		Comment:    req.Comment,
+		CommentId:  commentId,
+		SiteDomain: domain,
+		Now:        nowUnix,
+		Req:        r,
+		Ip:         clientIp(r),
+		Url:        canonicalUrl,
+		UserId:     userId,
+		Parent:     parent,
+	}
 
-	} else {
-		PutStructureErr := pageGlobalStructureRepo.PutGlobalStructure(tableRecords.PageGlobalStructureItem)
-		if PutStructureErr != nil {
-			return err //Failed to write data to DynamoDB
+	// Another comment on the URL stored in the same millisecond holds the
+	// key; the comment is then stored a millisecond later.
+	var tableRecords dynamo.AllTableRecords
+	for attempt := 1; ; attempt++ {
+		tableRecords = dynamo.GenerateAllTableRecords(baseFieldDatas)
+		err = commentTransactionRepo.PutAllTableRecords(tableRecords)
+
+		var canceled *dynamo.TransactionCanceledError
+		if errors.As(err, &canceled) && canceled.ConditionFailed(0) && attempt < maxCommentKeyAttempts {
+			baseFieldDatas.Now++
+			continue
 		}
+		break
+	}
+	if errors.Is(err, dynamo.ErrTransactionCanceled) {
+		writeError(w, r, http.StatusConflict, codeConflict)
+		return
 	}
-	return nil
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	publishComment(tableRecords.CommentItem, domain)
+
+	resp := map[string]string{
+		"message":   "Insert succeeded!",
+		"commentId": commentId,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"fmt"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type AdminAuditRepository struct {
+	db *DB
+}
+
+func NewAdminAuditRepository(db *DB) *AdminAuditRepository {
+	return &AdminAuditRepository{db: db}
+}
+
+func (r *AdminAuditRepository) PutAdminAudit(item dynamo.AdminAuditItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, exists := r.db.adminAudit.get(item.GlobalKey, item.AuditId); exists {
+		return fmt.Errorf("%w: audit entry %s exists", dynamo.ErrConditionFailed, item.AuditId)
+	}
+	r.db.adminAudit.put(item.GlobalKey, item.AuditId, item)
+	return nil
+}
+
+func (r *AdminAuditRepository) GetAdminAudit(page dynamo.PageRequest) ([]dynamo.AdminAuditItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "auditId")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.adminAudit.query("GLOBAL", false, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"globalKey": stringAttribute("GLOBAL"),
+		"auditId":   stringAttribute(*last),
+	}, nil
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type BanRepository struct {
+	db *DB
+}
+
+func NewBanRepository(db *DB) *BanRepository {
+	return &BanRepository{db: db}
+}
+
+func (r *BanRepository) PutBan(item dynamo.BanItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.bans.put(item.Kind, item.Value, item)
+	return nil
+}
+
+func (r *BanRepository) GetBan(kind string, value string) (dynamo.BanItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.bans.get(kind, value)
+	return item, ok, nil
+}
+
+func (r *BanRepository) GetBans(kind string, page dynamo.PageRequest) ([]dynamo.BanItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "value")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.bans.query(kind, true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"kind":  stringAttribute(kind),
+		"value": stringAttribute(*last),
+	}, nil
+}
+
+func (r *BanRepository) DeleteBan(kind string, value string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.bans.delete(kind, value)
+	return nil
+}
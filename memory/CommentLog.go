@@ -0,0 +1,27 @@
+package memory
+
+import "pageknock-backend/dynamo"
+
+type CommentLogRepository struct {
+	db *DB
+}
+
+func NewCommentLogRepository(db *DB) *CommentLogRepository {
+	return &CommentLogRepository{db: db}
+}
+
+func (r *CommentLogRepository) PutCommentLog(item dynamo.CommentLogItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.commentLogs.put(item.GlobalKey, item.LogId, item)
+	return nil
+}
+
+func (r *CommentLogRepository) GetCommentLog(unixTime int64, commentId string) (dynamo.CommentLogItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.commentLogs.get("GLOBAL", dynamo.CommentLogId(unixTime, commentId, "post"))
+	return item, ok, nil
+}
@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type CommentRevisionRepository struct {
+	db *DB
+}
+
+func NewCommentRevisionRepository(db *DB) *CommentRevisionRepository {
+	return &CommentRevisionRepository{db: db}
+}
+
+func (r *CommentRevisionRepository) GetCommentRevisions(commentId string, page dynamo.PageRequest) ([]dynamo.CommentRevisionItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "editedAt")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.commentRevisions.query(commentId, false, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"commentId": stringAttribute(commentId),
+		"editedAt":  numberAttribute(*last),
+	}, nil
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+)
+
+// markApplied must be called with the DB lock held. It reports false when
+// event of commentId was applied before.
+func (r *CommentTransactionRepository) markApplied(commentId string, event string) bool {
+	if _, ok := r.db.appliedCommentEvents.get(commentId, event); ok {
+		return false
+	}
+	r.db.appliedCommentEvents.put(commentId, event, dynamo.AppliedCommentEventItem{
+		CommentId: commentId,
+		Event:     event,
+		UnixTime:  dynamo.GetUnixMillsecound(),
+	})
+	return true
+}
+
+func (r *CommentTransactionRepository) ApplyCommentInsert(item dynamo.CommentItem, siteDomain string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if !r.markApplied(item.CommentId, "insert") {
+		return nil
+	}
+
+	recentGlobal, recentDomain := dynamo.RecentCommentItemsFromComment(item, siteDomain)
+	r.db.recentGlobalComments.put(recentGlobal.GlobalKey, recentGlobal.UnixTime, recentGlobal)
+	r.db.recentDomainComments.put(recentDomain.SiteDomain, recentDomain.UnixTime, recentDomain)
+
+	r.addToStructures(structureAddition(item.Url, siteDomain, item.UnixTime))
+	return nil
+}
+
+// structureAddition returns the records addToStructures needs to count one
+// comment posted on url at unixTime.
+func structureAddition(url string, siteDomain string, unixTime int64) dynamo.AllTableRecords {
+	return dynamo.AllTableRecords{
+		PageStructureItem: dynamo.PageStructureItem{
+			SiteDomain:     siteDomain,
+			Url:            url,
+			LatestUnixTime: unixTime,
+			CommentCount:   1,
+		},
+		PageGlobalStructureItem: dynamo.PageGlobalStructureItem{
+			GlobalKey:  "GLOBAL",
+			SiteDomain: siteDomain,
+			UrlCount:   1,
+		},
+	}
+}
+
+func (r *CommentTransactionRepository) ApplyCommentModify(item dynamo.CommentItem, siteDomain string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	recentGlobal, recentDomain := dynamo.RecentCommentItemsFromComment(item, siteDomain)
+	if stored, ok := r.db.recentGlobalComments.get(recentGlobal.GlobalKey, item.UnixTime); ok && stored.CommentId == item.CommentId {
+		r.db.recentGlobalComments.put(recentGlobal.GlobalKey, item.UnixTime, recentGlobal)
+	}
+	if stored, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && stored.CommentId == item.CommentId {
+		r.db.recentDomainComments.put(siteDomain, item.UnixTime, recentDomain)
+	}
+	return nil
+}
+
+func (r *CommentTransactionRepository) ApplyCommentRemove(item dynamo.CommentItem, siteDomain string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if !r.markApplied(item.CommentId, "remove") {
+		return nil
+	}
+
+	if recentGlobal, ok := r.db.recentGlobalComments.get("GLOBAL", item.UnixTime); ok && recentGlobal.CommentId == item.CommentId {
+		r.db.recentGlobalComments.delete("GLOBAL", item.UnixTime)
+	}
+	if recentDomain, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && recentDomain.CommentId == item.CommentId {
+		r.db.recentDomainComments.delete(siteDomain, item.UnixTime)
+	}
+
+	r.removeFromStructures(item.Url, siteDomain)
+	return nil
+}
@@ -0,0 +1,284 @@
+package memory
+
+import (
+	"maps"
+
+	"pageknock-backend/dynamo"
+)
+
+// CommentTransactionRepository applies multi-table writes under a single
+// acquisition of the DB lock, so readers never observe a partial write.
+type CommentTransactionRepository struct {
+	db *DB
+}
+
+func NewCommentTransactionRepository(db *DB) *CommentTransactionRepository {
+	return &CommentTransactionRepository{db: db}
+}
+
+func (r *CommentTransactionRepository) PutAllTableRecords(records dynamo.AllTableRecords) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	comment := records.CommentItem
+	if _, ok := r.db.comments.get(comment.Url, comment.UnixTime); ok {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None", "None"}}
+	}
+	if comment.ParentCommentId != "" {
+		parent, ok := r.db.comments.get(comment.Url, comment.ParentUnixTime)
+		if !ok || parent.CommentId != comment.ParentCommentId {
+			return &dynamo.TransactionCanceledError{Reasons: []string{"None", "None", "None", "None", "ConditionalCheckFailed"}}
+		}
+		parent.ReplyCount++
+		r.db.comments.put(parent.Url, parent.UnixTime, parent)
+	}
+	r.db.comments.put(comment.Url, comment.UnixTime, comment)
+
+	recentGlobal := records.RecentGlobalCommentItem
+	r.db.recentGlobalComments.put(recentGlobal.GlobalKey, recentGlobal.UnixTime, recentGlobal)
+
+	recentDomain := records.RecentDomainCommentItem
+	r.db.recentDomainComments.put(recentDomain.SiteDomain, recentDomain.UnixTime, recentDomain)
+
+	commentLog := records.CommentLogItem
+	r.db.commentLogs.put(commentLog.GlobalKey, commentLog.LogId, commentLog)
+
+	r.addToStructures(records)
+	return nil
+}
+
+// addToStructures must be called with the DB lock held. Only the first
+// comment on a URL counts towards the domain's urlCount.
+func (r *CommentTransactionRepository) addToStructures(records dynamo.AllTableRecords) {
+	structure, ok := r.db.pageStructures.get(records.PageStructureItem.SiteDomain, records.PageStructureItem.Url)
+	if ok {
+		structure.CommentCount += records.PageStructureItem.CommentCount
+		structure.LatestUnixTime = records.PageStructureItem.LatestUnixTime
+		r.db.pageStructures.put(structure.SiteDomain, structure.Url, structure)
+		return
+	}
+	r.db.pageStructures.put(records.PageStructureItem.SiteDomain, records.PageStructureItem.Url, records.PageStructureItem)
+
+	globalStructure, ok := r.db.pageGlobalStructures.get(records.PageGlobalStructureItem.GlobalKey, records.PageGlobalStructureItem.SiteDomain)
+	if !ok {
+		globalStructure = dynamo.PageGlobalStructureItem{GlobalKey: records.PageGlobalStructureItem.GlobalKey, SiteDomain: records.PageGlobalStructureItem.SiteDomain}
+	}
+	globalStructure.UrlCount += records.PageGlobalStructureItem.UrlCount
+	r.db.pageGlobalStructures.put(globalStructure.GlobalKey, globalStructure.SiteDomain, globalStructure)
+}
+
+func (r *CommentTransactionRepository) ReassignCommentUser(item dynamo.CommentItem, siteDomain string, userId string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	comment, ok := r.db.comments.get(item.Url, item.UnixTime)
+	if !ok || comment.CommentId != item.CommentId {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None"}}
+	}
+	comment.UserID = userId
+	r.db.comments.put(comment.Url, comment.UnixTime, comment)
+
+	if recentGlobal, ok := r.db.recentGlobalComments.get("GLOBAL", item.UnixTime); ok && recentGlobal.CommentId == item.CommentId {
+		recentGlobal.UserID = userId
+		r.db.recentGlobalComments.put("GLOBAL", item.UnixTime, recentGlobal)
+	}
+	if recentDomain, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && recentDomain.CommentId == item.CommentId {
+		recentDomain.UserID = userId
+		r.db.recentDomainComments.put(siteDomain, item.UnixTime, recentDomain)
+	}
+
+	return nil
+}
+
+func (r *CommentTransactionRepository) DeleteComment(item dynamo.CommentItem, siteDomain string, log dynamo.CommentLogItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	comment, ok := r.db.comments.get(item.Url, item.UnixTime)
+	if !ok || comment.CommentId != item.CommentId {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None", "None"}}
+	}
+	r.db.comments.delete(item.Url, item.UnixTime)
+
+	if parent, ok := r.db.comments.get(item.Url, item.ParentUnixTime); ok && item.ParentCommentId != "" && parent.CommentId == item.ParentCommentId {
+		parent.ReplyCount--
+		r.db.comments.put(parent.Url, parent.UnixTime, parent)
+	}
+
+	if recentGlobal, ok := r.db.recentGlobalComments.get("GLOBAL", item.UnixTime); ok && recentGlobal.CommentId == item.CommentId {
+		r.db.recentGlobalComments.delete("GLOBAL", item.UnixTime)
+	}
+	if recentDomain, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && recentDomain.CommentId == item.CommentId {
+		r.db.recentDomainComments.delete(siteDomain, item.UnixTime)
+	}
+
+	r.db.commentLogs.put(log.GlobalKey, log.LogId, log)
+	r.db.moderationQueue.delete("GLOBAL", item.CommentId)
+	delete(r.db.commentRevisions, item.CommentId)
+
+	r.removeFromStructures(item.Url, siteDomain)
+	return nil
+}
+
+// removeFromStructures must be called with the DB lock held. A page without
+// a PageStructure row leaves the counters alone, as on DynamoDB.
+func (r *CommentTransactionRepository) removeFromStructures(url string, siteDomain string) {
+	structure, ok := r.db.pageStructures.get(siteDomain, url)
+	if !ok {
+		return
+	}
+	if structure.CommentCount > 1 {
+		structure.CommentCount--
+		r.db.pageStructures.put(siteDomain, url, structure)
+		return
+	}
+	r.db.pageStructures.delete(siteDomain, url)
+
+	globalStructure, ok := r.db.pageGlobalStructures.get("GLOBAL", siteDomain)
+	if ok && globalStructure.UrlCount > 1 {
+		globalStructure.UrlCount--
+		r.db.pageGlobalStructures.put("GLOBAL", siteDomain, globalStructure)
+		return
+	}
+	r.db.pageGlobalStructures.delete("GLOBAL", siteDomain)
+}
+
+func (r *CommentTransactionRepository) EditComment(item dynamo.CommentItem, siteDomain string, text string, revision dynamo.CommentRevisionItem, log dynamo.CommentLogItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	comment, ok := r.db.comments.get(item.Url, item.UnixTime)
+	if !ok || comment.CommentId != item.CommentId || comment.Comment != item.Comment {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None", "None", "None"}}
+	}
+	comment.Comment = text
+	comment.EditedAt = revision.EditedAt
+	r.db.comments.put(comment.Url, comment.UnixTime, comment)
+
+	if recentGlobal, ok := r.db.recentGlobalComments.get("GLOBAL", item.UnixTime); ok && recentGlobal.CommentId == item.CommentId {
+		recentGlobal.Comment = text
+		recentGlobal.EditedAt = revision.EditedAt
+		r.db.recentGlobalComments.put("GLOBAL", item.UnixTime, recentGlobal)
+	}
+	if recentDomain, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && recentDomain.CommentId == item.CommentId {
+		recentDomain.Comment = text
+		recentDomain.EditedAt = revision.EditedAt
+		r.db.recentDomainComments.put(siteDomain, item.UnixTime, recentDomain)
+	}
+
+	r.db.commentRevisions.put(revision.CommentId, revision.EditedAt, revision)
+	r.db.commentLogs.put(log.GlobalKey, log.LogId, log)
+
+	return nil
+}
+
+// SetReaction copies the reactions maps it changes, as stored items may share
+// them with items handed out to readers.
+func (r *CommentTransactionRepository) SetReaction(item dynamo.CommentItem, siteDomain string, reaction dynamo.ReactionItem, add bool) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	_, exists := r.db.reactions.get(reaction.CommentId, reaction.Key)
+	if exists == add {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None", "None"}}
+	}
+	comment, ok := r.db.comments.get(item.Url, item.UnixTime)
+	if !ok || comment.CommentId != item.CommentId {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"None", "ConditionalCheckFailed", "None", "None"}}
+	}
+
+	delta := -1
+	if add {
+		delta = 1
+		r.db.reactions.put(reaction.CommentId, reaction.Key, reaction)
+	} else {
+		r.db.reactions.delete(reaction.CommentId, reaction.Key)
+	}
+
+	comment.Reactions = addReaction(comment.Reactions, reaction.Type, delta)
+	r.db.comments.put(comment.Url, comment.UnixTime, comment)
+
+	if recentGlobal, ok := r.db.recentGlobalComments.get("GLOBAL", item.UnixTime); ok && recentGlobal.CommentId == item.CommentId {
+		recentGlobal.Reactions = addReaction(recentGlobal.Reactions, reaction.Type, delta)
+		r.db.recentGlobalComments.put("GLOBAL", item.UnixTime, recentGlobal)
+	}
+	if recentDomain, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && recentDomain.CommentId == item.CommentId {
+		recentDomain.Reactions = addReaction(recentDomain.Reactions, reaction.Type, delta)
+		r.db.recentDomainComments.put(siteDomain, item.UnixTime, recentDomain)
+	}
+
+	return nil
+}
+
+func addReaction(counts map[string]int, reactionType string, delta int) map[string]int {
+	counts = maps.Clone(counts)
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	counts[reactionType] += delta
+	return counts
+}
+
+func (r *CommentTransactionRepository) ReportComment(item dynamo.CommentItem, siteDomain string, report dynamo.ReportItem, hide bool) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	comment, ok := r.db.comments.get(item.Url, item.UnixTime)
+	if !ok || comment.CommentId != item.CommentId || comment.ReportCount != item.ReportCount {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None"}}
+	}
+	if _, exists := r.db.reports.get(report.CommentId, report.ReporterID); exists {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"None", "ConditionalCheckFailed", "None"}}
+	}
+
+	comment.ReportCount++
+	comment.Hidden = comment.Hidden || hide
+	r.db.comments.put(comment.Url, comment.UnixTime, comment)
+	r.db.reports.put(report.CommentId, report.ReporterID, report)
+	r.db.moderationQueue.put("GLOBAL", comment.CommentId, dynamo.ModerationQueueItem{
+		GlobalKey:      "GLOBAL",
+		CommentId:      comment.CommentId,
+		Url:            comment.Url,
+		UnixTime:       comment.UnixTime,
+		SiteDomain:     siteDomain,
+		ReportCount:    comment.ReportCount,
+		LatestReportAt: report.UnixTime,
+		Hidden:         comment.Hidden,
+	})
+
+	if hide {
+		r.setRecentHidden(item, siteDomain, true)
+	}
+	return nil
+}
+
+func (r *CommentTransactionRepository) ApproveComment(item dynamo.CommentItem, siteDomain string, log dynamo.CommentLogItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	comment, ok := r.db.comments.get(item.Url, item.UnixTime)
+	if !ok || comment.CommentId != item.CommentId {
+		return &dynamo.TransactionCanceledError{Reasons: []string{"ConditionalCheckFailed", "None", "None", "None", "None"}}
+	}
+	comment.ReportCount = 0
+	comment.Hidden = false
+	r.db.comments.put(comment.Url, comment.UnixTime, comment)
+
+	r.setRecentHidden(item, siteDomain, false)
+	r.db.moderationQueue.delete("GLOBAL", item.CommentId)
+	r.db.commentLogs.put(log.GlobalKey, log.LogId, log)
+
+	return nil
+}
+
+// setRecentHidden must be called with the DB lock held.
+func (r *CommentTransactionRepository) setRecentHidden(item dynamo.CommentItem, siteDomain string, hidden bool) {
+	if recentGlobal, ok := r.db.recentGlobalComments.get("GLOBAL", item.UnixTime); ok && recentGlobal.CommentId == item.CommentId {
+		recentGlobal.Hidden = hidden
+		r.db.recentGlobalComments.put("GLOBAL", item.UnixTime, recentGlobal)
+	}
+	if recentDomain, ok := r.db.recentDomainComments.get(siteDomain, item.UnixTime); ok && recentDomain.CommentId == item.CommentId {
+		recentDomain.Hidden = hidden
+		r.db.recentDomainComments.put(siteDomain, item.UnixTime, recentDomain)
+	}
+}
@@ -0,0 +1,235 @@
+package memory
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"pageknock-backend/dynamo"
+)
+
+func TestDeleteCommentWithoutPageStructureRow(t *testing.T) {
+	db := NewDB()
+	commentTransactionRepo := NewCommentTransactionRepository(db)
+
+	records := dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+		Comment:    "hello",
+		CommentId:  "comment-1",
+		SiteDomain: "https://example.com",
+		Now:        1,
+		Req:        httptest.NewRequest("POST", "/comment", nil),
+		Url:        "https://example.com/a",
+		UserId:     "1",
+	})
+	if err := commentTransactionRepo.PutAllTableRecords(records); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+
+	// Drift: the page's PageStructure row is gone but its comment is not.
+	db.pageStructures.delete("https://example.com", "https://example.com/a")
+
+	err := commentTransactionRepo.DeleteComment(records.CommentItem, "https://example.com", dynamo.CommentLogItem{GlobalKey: "GLOBAL", UnixTime: 2})
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+
+	if _, ok, _ := NewCommentRepository(db).GetComment("https://example.com/a", 1); ok {
+		t.Error("comment still stored after DeleteComment")
+	}
+	if _, ok := db.recentGlobalComments.get("GLOBAL", 1); ok {
+		t.Error("RecentGlobalComment row still stored after DeleteComment")
+	}
+
+	globalStructures, _, err := NewPageGlobalStructureRepository(db).GetGlobalStructure(dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetGlobalStructure: %v", err)
+	}
+	if len(globalStructures) != 1 || globalStructures[0].UrlCount != 1 {
+		t.Errorf("global structures = %+v, want urlCount left at 1 for reconcile", globalStructures)
+	}
+}
+
+func TestPutAllTableRecordsKeepsCommentWithSameKey(t *testing.T) {
+	db := NewDB()
+	commentTransactionRepo := NewCommentTransactionRepository(db)
+
+	base := dynamo.BaseFieldDatas{
+		Comment:    "first",
+		CommentId:  "comment-1",
+		SiteDomain: "https://example.com",
+		Now:        1,
+		Req:        httptest.NewRequest("POST", "/comment", nil),
+		Url:        "https://example.com/a",
+		UserId:     "1",
+	}
+	if err := commentTransactionRepo.PutAllTableRecords(dynamo.GenerateAllTableRecords(base)); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+
+	base.Comment, base.CommentId = "second", "comment-2"
+	err := commentTransactionRepo.PutAllTableRecords(dynamo.GenerateAllTableRecords(base))
+	var canceled *dynamo.TransactionCanceledError
+	if !errors.As(err, &canceled) || !canceled.ConditionFailed(0) {
+		t.Fatalf("PutAllTableRecords = %v, want the Comment put canceled", err)
+	}
+
+	comment, ok, _ := NewCommentRepository(db).GetComment("https://example.com/a", 1)
+	if !ok || comment.CommentId != "comment-1" {
+		t.Errorf("stored comment = %+v, want comment-1 kept", comment)
+	}
+}
+
+func TestDeleteCommentDropsModerationQueueEntry(t *testing.T) {
+	db := NewDB()
+	commentTransactionRepo := NewCommentTransactionRepository(db)
+
+	records := dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+		Comment:    "hello",
+		CommentId:  "comment-1",
+		SiteDomain: "https://example.com",
+		Now:        1,
+		Req:        httptest.NewRequest("POST", "/comment", nil),
+		Url:        "https://example.com/a",
+		UserId:     "1",
+	})
+	if err := commentTransactionRepo.PutAllTableRecords(records); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+	report := dynamo.ReportItem{CommentId: "comment-1", ReporterID: "2", Reason: "spam", UnixTime: 2}
+	if err := commentTransactionRepo.ReportComment(records.CommentItem, "https://example.com", report, false); err != nil {
+		t.Fatalf("ReportComment: %v", err)
+	}
+
+	err := commentTransactionRepo.DeleteComment(records.CommentItem, "https://example.com", dynamo.CommentLogItem{GlobalKey: "GLOBAL", UnixTime: 3})
+	if err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+
+	if _, ok, _ := NewModerationQueueRepository(db).GetModerationQueueItem("comment-1"); ok {
+		t.Error("moderation queue entry left after DeleteComment")
+	}
+}
+
+func TestDeleteCommentKeepsPostLogOfSameMillisecond(t *testing.T) {
+	db := NewDB()
+	commentTransactionRepo := NewCommentTransactionRepository(db)
+	commentLogRepo := NewCommentLogRepository(db)
+
+	records := func(commentId string, now int64, ip string) dynamo.AllTableRecords {
+		return dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+			Comment:    "hello",
+			CommentId:  commentId,
+			SiteDomain: "https://example.com",
+			Now:        now,
+			Req:        httptest.NewRequest("POST", "/comment", nil),
+			Ip:         ip,
+			Url:        "https://example.com/a",
+			UserId:     "1",
+		})
+	}
+	first := records("comment-1", 1, "192.0.2.1")
+	if err := commentTransactionRepo.PutAllTableRecords(first); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+	second := records("comment-2", 2, "192.0.2.2")
+	if err := commentTransactionRepo.PutAllTableRecords(second); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+
+	// comment-1 is deleted in the millisecond comment-2 was posted.
+	log := dynamo.CommentLogItem{
+		GlobalKey: "GLOBAL",
+		LogId:     dynamo.CommentLogId(2, "comment-1", "delete"),
+		UnixTime:  2,
+		CommentId: "comment-1",
+		Action:    "delete",
+	}
+	if err := commentTransactionRepo.DeleteComment(first.CommentItem, "https://example.com", log); err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+
+	got, ok, err := commentLogRepo.GetCommentLog(2, "comment-2")
+	if err != nil {
+		t.Fatalf("GetCommentLog: %v", err)
+	}
+	if !ok || got.Action != "post" || got.Ip != "192.0.2.2" {
+		t.Errorf("GetCommentLog(2, comment-2) = %+v, %v; want comment-2's post log", got, ok)
+	}
+}
+
+func TestPutAllTableRecordsCounts(t *testing.T) {
+	tests := []struct {
+		name             string
+		urls             []string
+		wantCommentCount map[string]int
+		wantUrlCount     int
+	}{
+		{"one comment", []string{"/a"}, map[string]int{"/a": 1}, 1},
+		{"comments on one URL", []string{"/a", "/a", "/a"}, map[string]int{"/a": 3}, 1},
+		{"comments on two URLs", []string{"/a", "/b", "/a"}, map[string]int{"/a": 2, "/b": 1}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB()
+			commentTransactionRepo := NewCommentTransactionRepository(db)
+			for i, url := range tt.urls {
+				records := dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+					Comment:    "hello",
+					CommentId:  "comment-" + strconv.Itoa(i),
+					SiteDomain: "https://example.com",
+					Now:        int64(i + 1),
+					Req:        httptest.NewRequest("POST", "/comment", nil),
+					Url:        "https://example.com" + url,
+					UserId:     "1",
+				})
+				if err := commentTransactionRepo.PutAllTableRecords(records); err != nil {
+					t.Fatalf("PutAllTableRecords: %v", err)
+				}
+			}
+
+			for url, want := range tt.wantCommentCount {
+				structure, ok := db.pageStructures.get("https://example.com", "https://example.com"+url)
+				if !ok || structure.CommentCount != want {
+					t.Errorf("commentCount of %s = %d, want %d", url, structure.CommentCount, want)
+				}
+			}
+			global, _ := db.pageGlobalStructures.get("GLOBAL", "https://example.com")
+			if global.UrlCount != tt.wantUrlCount {
+				t.Errorf("urlCount = %d, want %d", global.UrlCount, tt.wantUrlCount)
+			}
+		})
+	}
+}
+
+func TestPutAllTableRecordsCountsReplies(t *testing.T) {
+	db := NewDB()
+	commentTransactionRepo := NewCommentTransactionRepository(db)
+
+	post := func(commentId string, now int64, parent *dynamo.CommentItem) dynamo.CommentItem {
+		t.Helper()
+		records := dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+			Comment:    "hello",
+			CommentId:  commentId,
+			SiteDomain: "https://example.com",
+			Now:        now,
+			Req:        httptest.NewRequest("POST", "/comment", nil),
+			Url:        "https://example.com/a",
+			UserId:     "1",
+			Parent:     parent,
+		})
+		if err := commentTransactionRepo.PutAllTableRecords(records); err != nil {
+			t.Fatalf("PutAllTableRecords: %v", err)
+		}
+		return records.CommentItem
+	}
+	root := post("comment-1", 1, nil)
+	post("comment-2", 2, &root)
+	post("comment-3", 3, &root)
+
+	got, _ := db.comments.get("https://example.com/a", 1)
+	if got.ReplyCount != 2 {
+		t.Errorf("replyCount = %d, want 2", got.ReplyCount)
+	}
+}
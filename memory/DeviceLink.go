@@ -0,0 +1,32 @@
+package memory
+
+import "pageknock-backend/dynamo"
+
+// DeviceLinkRepository stores its rows under an empty sort key, the table
+// having a partition key only.
+type DeviceLinkRepository struct {
+	db *DB
+}
+
+func NewDeviceLinkRepository(db *DB) *DeviceLinkRepository {
+	return &DeviceLinkRepository{db: db}
+}
+
+func (r *DeviceLinkRepository) PutDeviceLink(item dynamo.DeviceLinkItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.deviceLinks.get(item.DeviceId, ""); ok {
+		return dynamo.ErrConditionFailed
+	}
+	r.db.deviceLinks.put(item.DeviceId, "", item)
+	return nil
+}
+
+func (r *DeviceLinkRepository) GetDeviceLink(deviceId string) (dynamo.DeviceLinkItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.deviceLinks.get(deviceId, "")
+	return item, ok, nil
+}
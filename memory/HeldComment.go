@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type HeldCommentRepository struct {
+	db *DB
+}
+
+func NewHeldCommentRepository(db *DB) *HeldCommentRepository {
+	return &HeldCommentRepository{db: db}
+}
+
+func (r *HeldCommentRepository) PutHeldComment(item dynamo.HeldCommentItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.heldComments.put(item.GlobalKey, item.UnixTime, item)
+	return nil
+}
+
+func (r *HeldCommentRepository) GetHeldComments(page dynamo.PageRequest) ([]dynamo.HeldCommentItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.heldComments.query("GLOBAL", true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"globalKey": stringAttribute("GLOBAL"),
+		"unixTime":  numberAttribute(*last),
+	}, nil
+}
+
+func (r *HeldCommentRepository) GetHeldComment(unixTime int64) (dynamo.HeldCommentItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.heldComments.get("GLOBAL", unixTime)
+	return item, ok, nil
+}
+
+func (r *HeldCommentRepository) DeleteHeldComment(unixTime int64, commentId string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	item, ok := r.db.heldComments.get("GLOBAL", unixTime)
+	if !ok || item.CommentId != commentId {
+		return dynamo.ErrConditionFailed
+	}
+	r.db.heldComments.delete("GLOBAL", unixTime)
+	return nil
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+
+	"pageknock-backend/dynamo"
+)
+
+func TestDeleteHeldCommentChecksCommentId(t *testing.T) {
+	repo := NewHeldCommentRepository(NewDB())
+	held := dynamo.HeldCommentItem{GlobalKey: "GLOBAL", UnixTime: 1, CommentId: "comment-1"}
+	if err := repo.PutHeldComment(held); err != nil {
+		t.Fatalf("PutHeldComment: %v", err)
+	}
+
+	if err := repo.DeleteHeldComment(1, "comment-2"); !errors.Is(err, dynamo.ErrConditionFailed) {
+		t.Errorf("DeleteHeldComment of another comment = %v, want ErrConditionFailed", err)
+	}
+	if _, ok, _ := repo.GetHeldComment(1); !ok {
+		t.Fatal("held comment deleted by a request for another comment")
+	}
+
+	if err := repo.DeleteHeldComment(1, "comment-1"); err != nil {
+		t.Fatalf("DeleteHeldComment: %v", err)
+	}
+	if _, ok, _ := repo.GetHeldComment(1); ok {
+		t.Error("held comment still stored after DeleteHeldComment")
+	}
+	if err := repo.DeleteHeldComment(1, "comment-1"); !errors.Is(err, dynamo.ErrConditionFailed) {
+		t.Errorf("second DeleteHeldComment = %v, want ErrConditionFailed", err)
+	}
+}
@@ -0,0 +1,39 @@
+package memory
+
+import "pageknock-backend/dynamo"
+
+type IdempotencyKeyRepository struct {
+	db *DB
+}
+
+func NewIdempotencyKeyRepository(db *DB) *IdempotencyKeyRepository {
+	return &IdempotencyKeyRepository{db: db}
+}
+
+func (r *IdempotencyKeyRepository) ReserveIdempotencyKey(item dynamo.IdempotencyKeyItem, now int64, staleBefore int64) (dynamo.IdempotencyKeyItem, bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	existing, ok := r.db.idempotencyKeys.get(item.Key, "")
+	if ok && existing.ExpiresAt > now && (existing.Completed || existing.CreatedAt >= staleBefore) {
+		return existing, false, nil
+	}
+	r.db.idempotencyKeys.put(item.Key, "", item)
+	return item, true, nil
+}
+
+func (r *IdempotencyKeyRepository) PutIdempotencyKey(item dynamo.IdempotencyKeyItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.idempotencyKeys.put(item.Key, "", item)
+	return nil
+}
+
+func (r *IdempotencyKeyRepository) DeleteIdempotencyKey(key string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.idempotencyKeys.delete(key, "")
+	return nil
+}
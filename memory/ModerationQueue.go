@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type ModerationQueueRepository struct {
+	db *DB
+}
+
+func NewModerationQueueRepository(db *DB) *ModerationQueueRepository {
+	return &ModerationQueueRepository{db: db}
+}
+
+func (r *ModerationQueueRepository) GetModerationQueue(page dynamo.PageRequest) ([]dynamo.ModerationQueueItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "commentId")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.moderationQueue.query("GLOBAL", true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"globalKey": stringAttribute("GLOBAL"),
+		"commentId": stringAttribute(*last),
+	}, nil
+}
+
+func (r *ModerationQueueRepository) GetModerationQueueItem(commentId string) (dynamo.ModerationQueueItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.moderationQueue.get("GLOBAL", commentId)
+	return item, ok, nil
+}
+
+func (r *ModerationQueueRepository) DeleteModerationQueueItem(commentId string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.moderationQueue.delete("GLOBAL", commentId)
+	return nil
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"fmt"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// OutboxRepository holds outbox tasks. The memory backend writes every table
+// in one transaction, so it records no tasks itself; the repository serves
+// the inspection endpoints and the outbox worker's tests.
+type OutboxRepository struct {
+	db *DB
+}
+
+func NewOutboxRepository(db *DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// PutOutboxTask records a task, as the DynamoDB backend does within a
+// comment write.
+func (r *OutboxRepository) PutOutboxTask(task dynamo.OutboxTaskItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.outboxTasks.put(task.Status, task.TaskId, task)
+	return nil
+}
+
+func (r *OutboxRepository) GetDueOutboxTasks(now int64, limit int32) ([]dynamo.OutboxTaskItem, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	pending, _ := r.db.outboxTasks.query(dynamo.OutboxPending, true, 0, nil)
+	var tasks []dynamo.OutboxTaskItem
+	for _, task := range pending {
+		if task.NextAttemptAt <= now {
+			tasks = append(tasks, task)
+		}
+		if len(tasks) == int(limit) {
+			break
+		}
+	}
+	return tasks, nil
+}
+
+func (r *OutboxRepository) GetOutboxTasks(status string, page dynamo.PageRequest) ([]dynamo.OutboxTaskItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "taskId")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.outboxTasks.query(status, true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"status": stringAttribute(status),
+		"taskId": stringAttribute(*last),
+	}, nil
+}
+
+// pendingAt must be called with the DB lock held. It returns the pending
+// task stored as task if its attempts are unchanged.
+func (r *OutboxRepository) pendingAt(task dynamo.OutboxTaskItem) (dynamo.OutboxTaskItem, error) {
+	stored, ok := r.db.outboxTasks.get(dynamo.OutboxPending, task.TaskId)
+	if !ok || stored.Attempts != task.Attempts {
+		return dynamo.OutboxTaskItem{}, dynamo.ErrConditionFailed
+	}
+	return stored, nil
+}
+
+func (r *OutboxRepository) ClaimOutboxTask(task dynamo.OutboxTaskItem, nextAttemptAt int64) (dynamo.OutboxTaskItem, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	claimed, err := r.pendingAt(task)
+	if err != nil {
+		return dynamo.OutboxTaskItem{}, err
+	}
+	claimed.Attempts++
+	claimed.NextAttemptAt = nextAttemptAt
+	claimed.UpdatedAt = dynamo.GetUnixMillsecound()
+	r.db.outboxTasks.put(dynamo.OutboxPending, claimed.TaskId, claimed)
+	return claimed, nil
+}
+
+func (r *OutboxRepository) FailOutboxTask(task dynamo.OutboxTaskItem, lastError string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	stored, err := r.pendingAt(task)
+	if err != nil {
+		return err
+	}
+	stored.LastError = lastError
+	stored.UpdatedAt = dynamo.GetUnixMillsecound()
+	r.db.outboxTasks.put(dynamo.OutboxPending, stored.TaskId, stored)
+	return nil
+}
+
+func (r *OutboxRepository) CompleteOutboxTask(task dynamo.OutboxTaskItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.outboxTasks.delete(dynamo.OutboxPending, task.TaskId)
+	return nil
+}
+
+func (r *OutboxRepository) DeadLetterOutboxTask(task dynamo.OutboxTaskItem, lastError string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	dead, err := r.pendingAt(task)
+	if err != nil {
+		return err
+	}
+	dead.Status = dynamo.OutboxDead
+	dead.LastError = lastError
+	dead.UpdatedAt = dynamo.GetUnixMillsecound()
+	r.db.outboxTasks.delete(dynamo.OutboxPending, task.TaskId)
+	r.db.outboxTasks.put(dynamo.OutboxDead, dead.TaskId, dead)
+	return nil
+}
+
+func (r *OutboxRepository) ReplayOutboxTask(taskId string, now int64) (dynamo.OutboxTaskItem, bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	task, ok := r.db.outboxTasks.get(dynamo.OutboxDead, taskId)
+	if !ok {
+		return dynamo.OutboxTaskItem{}, false, nil
+	}
+	task.Status = dynamo.OutboxPending
+	task.Attempts = 0
+	task.NextAttemptAt = now
+	task.UpdatedAt = now
+	r.db.outboxTasks.delete(dynamo.OutboxDead, taskId)
+	r.db.outboxTasks.put(dynamo.OutboxPending, taskId, task)
+	return task, true, nil
+}
+
+func (r *CommentTransactionRepository) ApplyOutboxTask(task dynamo.OutboxTaskItem) error {
+	if task.Kind != dynamo.OutboxStructureAdd && task.Kind != dynamo.OutboxStructureRemove {
+		return fmt.Errorf("unknown outbox task kind %q", task.Kind)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if !r.markApplied(task.CommentId, task.Kind) {
+		return nil
+	}
+	if task.Kind == dynamo.OutboxStructureAdd {
+		r.addToStructures(structureAddition(task.Url, task.SiteDomain, task.CommentUnixTime))
+	} else if !r.markApplied(task.CommentId, dynamo.OutboxStructureAdd) {
+		// Unless the add was applied, marking it applied cancels it and
+		// leaves nothing to uncount.
+		r.removeFromStructures(task.Url, task.SiteDomain)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"time"
+
+	"pageknock-backend/dynamo"
+)
+
+// sweepInterval is how many PutBucket calls pass between removals of expired
+// buckets, standing in for the DynamoDB TTL on expiresAt.
+const sweepInterval = 1024
+
+type RateLimitBucketRepository struct {
+	db   *DB
+	puts int
+}
+
+func NewRateLimitBucketRepository(db *DB) *RateLimitBucketRepository {
+	return &RateLimitBucketRepository{db: db}
+}
+
+func (r *RateLimitBucketRepository) GetBucket(bucketKey string) (dynamo.RateLimitBucketItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.rateLimitBuckets.get(bucketKey, "")
+	return item, ok, nil
+}
+
+func (r *RateLimitBucketRepository) PutBucket(item dynamo.RateLimitBucketItem, previousVersion int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	current, ok := r.db.rateLimitBuckets.get(item.BucketKey, "")
+	if (previousVersion == 0 && ok) || (previousVersion != 0 && (!ok || current.Version != previousVersion)) {
+		return dynamo.ErrConditionFailed
+	}
+	r.db.rateLimitBuckets.put(item.BucketKey, "", item)
+
+	r.puts++
+	if r.puts%sweepInterval == 0 {
+		now := time.Now().Unix()
+		for key, partition := range r.db.rateLimitBuckets {
+			if partition[""].ExpiresAt < now {
+				r.db.rateLimitBuckets.delete(key, "")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package memory
+
+import "pageknock-backend/dynamo"
+
+type ReactionRepository struct {
+	db *DB
+}
+
+func NewReactionRepository(db *DB) *ReactionRepository {
+	return &ReactionRepository{db: db}
+}
+
+func (r *ReactionRepository) GetReaction(commentId string, reactionType string, userId string) (dynamo.ReactionItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.reactions.get(commentId, dynamo.ReactionKey(reactionType, userId))
+	return item, ok, nil
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type RecentDomainCommentRepository struct {
+	db *DB
+}
+
+func NewRecentDomainCommentRepository(db *DB) *RecentDomainCommentRepository {
+	return &RecentDomainCommentRepository{db: db}
+}
+
+func (r *RecentDomainCommentRepository) PutRecentDomainComment(item dynamo.RecentDomainCommentItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.recentDomainComments.put(item.SiteDomain, item.UnixTime, item)
+	return nil
+}
+
+func (r *RecentDomainCommentRepository) GetRecentDomainComment(siteDomain string, page dynamo.PageRequest) ([]dynamo.RecentDomainCommentItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.recentDomainComments.query(siteDomain, false, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"siteDomain": stringAttribute(siteDomain),
+		"unixTime":   numberAttribute(*last),
+	}, nil
+}
+
+func (r *RecentDomainCommentRepository) ScanRecentDomainComments(page dynamo.PageRequest) ([]dynamo.RecentDomainCommentItem, map[string]types.AttributeValue, error) {
+	startPK, err := stringKeyAttribute(page.StartKey, "siteDomain")
+	if err != nil {
+		return nil, nil, err
+	}
+	startSK, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, lastPK, lastSK := r.db.recentDomainComments.scan(pageLimit(page), startPK, startSK)
+	if lastPK == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"siteDomain": stringAttribute(*lastPK),
+		"unixTime":   numberAttribute(*lastSK),
+	}, nil
+}
+
+func (r *RecentDomainCommentRepository) DeleteRecentDomainComment(siteDomain string, unixTime int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.recentDomainComments.delete(siteDomain, unixTime)
+	return nil
+}
@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type RecentGlobalCommentRepository struct {
+	db *DB
+}
+
+func NewRecentGlobalCommentRepository(db *DB) *RecentGlobalCommentRepository {
+	return &RecentGlobalCommentRepository{db: db}
+}
+
+func (r *RecentGlobalCommentRepository) PutRecentGlobalComment(item dynamo.RecentGlobalCommentItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.recentGlobalComments.put(item.GlobalKey, item.UnixTime, item)
+	return nil
+}
+
+func (r *RecentGlobalCommentRepository) GetRecentGlobalComment(page dynamo.PageRequest) ([]dynamo.RecentGlobalCommentItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.recentGlobalComments.query("GLOBAL", false, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"globalKey": stringAttribute("GLOBAL"),
+		"unixTime":  numberAttribute(*last),
+	}, nil
+}
+
+func (r *RecentGlobalCommentRepository) DeleteRecentGlobalComment(unixTime int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.recentGlobalComments.delete("GLOBAL", unixTime)
+	return nil
+}
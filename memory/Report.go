@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type ReportRepository struct {
+	db *DB
+}
+
+func NewReportRepository(db *DB) *ReportRepository {
+	return &ReportRepository{db: db}
+}
+
+func (r *ReportRepository) GetReports(commentId string, page dynamo.PageRequest) ([]dynamo.ReportItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "reporterId")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.reports.query(commentId, true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"commentId":  stringAttribute(commentId),
+		"reporterId": stringAttribute(*last),
+	}, nil
+}
@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// RoleRepository stores its rows under an empty sort key, the table having a
+// partition key only.
+type RoleRepository struct {
+	db *DB
+}
+
+func NewRoleRepository(db *DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+func (r *RoleRepository) PutRole(item dynamo.RoleItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.roles.put(item.UserID, "", item)
+	return nil
+}
+
+func (r *RoleRepository) GetRole(userId string) (dynamo.RoleItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.roles.get(userId, "")
+	return item, ok, nil
+}
+
+func (r *RoleRepository) GetRoles(page dynamo.PageRequest) ([]dynamo.RoleItem, map[string]types.AttributeValue, error) {
+	startPK, err := stringKeyAttribute(page.StartKey, "userId")
+	if err != nil {
+		return nil, nil, err
+	}
+	var startSK *string
+	if startPK != nil {
+		startSK = new(string)
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, lastPK, _ := r.db.roles.scan(pageLimit(page), startPK, startSK)
+	if lastPK == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"userId": stringAttribute(*lastPK),
+	}, nil
+}
+
+func (r *RoleRepository) DeleteRole(userId string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.roles.delete(userId, "")
+	return nil
+}
@@ -0,0 +1,27 @@
+package memory
+
+import "pageknock-backend/dynamo"
+
+type StreamCheckpointRepository struct {
+	db *DB
+}
+
+func NewStreamCheckpointRepository(db *DB) *StreamCheckpointRepository {
+	return &StreamCheckpointRepository{db: db}
+}
+
+func (r *StreamCheckpointRepository) GetCheckpoint(shardId string) (dynamo.StreamCheckpointItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.streamCheckpoints.get(shardId, "")
+	return item, ok, nil
+}
+
+func (r *StreamCheckpointRepository) PutCheckpoint(item dynamo.StreamCheckpointItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.streamCheckpoints.put(item.ShardId, "", item)
+	return nil
+}
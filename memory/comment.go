@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"cmp"
+	"slices"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type CommentRepository struct {
+	db *DB
+}
+
+func NewCommentRepository(db *DB) *CommentRepository {
+	return &CommentRepository{db: db}
+}
+
+func (r *CommentRepository) PutComment(item dynamo.CommentItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.comments.put(item.Url, item.UnixTime, item)
+	return nil
+}
+
+func (r *CommentRepository) GetLatestCommentsByURL(url string, page dynamo.PageRequest) ([]dynamo.CommentItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.comments.query(url, false, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"url":      stringAttribute(url),
+		"unixTime": numberAttribute(*last),
+	}, nil
+}
+
+func (r *CommentRepository) ScanComments(page dynamo.PageRequest) ([]dynamo.CommentItem, map[string]types.AttributeValue, error) {
+	startPK, err := stringKeyAttribute(page.StartKey, "url")
+	if err != nil {
+		return nil, nil, err
+	}
+	startSK, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, lastPK, lastSK := r.db.comments.scan(pageLimit(page), startPK, startSK)
+	if lastPK == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"url":      stringAttribute(*lastPK),
+		"unixTime": numberAttribute(*lastSK),
+	}, nil
+}
+
+// GetCommentsByUserID emulates the userId index by scanning every comment.
+// Items are ordered by unixTime descending, ties broken by url.
+func (r *CommentRepository) GetCommentsByUserID(userId string, page dynamo.PageRequest) ([]dynamo.CommentItem, map[string]types.AttributeValue, error) {
+	startTime, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+	startUrl, err := stringKeyAttribute(page.StartKey, "url")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	compare := func(a, b dynamo.CommentItem) int {
+		if n := cmp.Compare(b.UnixTime, a.UnixTime); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.Url, b.Url)
+	}
+
+	var items []dynamo.CommentItem
+	for _, partition := range r.db.comments {
+		for _, item := range partition {
+			if item.UserID != userId {
+				continue
+			}
+			if startTime != nil && compare(item, dynamo.CommentItem{UnixTime: *startTime, Url: *startUrl}) <= 0 {
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+	slices.SortFunc(items, compare)
+
+	limit := pageLimit(page)
+	if len(items) <= limit {
+		return items, nil, nil
+	}
+	items = items[:limit]
+	last := items[limit-1]
+	return items, map[string]types.AttributeValue{
+		"userId":   stringAttribute(userId),
+		"unixTime": numberAttribute(last.UnixTime),
+		"url":      stringAttribute(last.Url),
+	}, nil
+}
+
+func (r *CommentRepository) GetComment(url string, unixTime int64) (dynamo.CommentItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	item, ok := r.db.comments.get(url, unixTime)
+	return item, ok, nil
+}
+
+// GetCommentByID emulates the commentId index by scanning every comment.
+func (r *CommentRepository) GetCommentByID(commentId string) (dynamo.CommentItem, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	for _, partition := range r.db.comments {
+		for _, item := range partition {
+			if item.CommentId == commentId {
+				return item, true, nil
+			}
+		}
+	}
+	return dynamo.CommentItem{}, false, nil
+}
+
+// GetRootCommentsByURL drops replies after applying the limit, like the
+// DynamoDB filter expression does.
+func (r *CommentRepository) GetRootCommentsByURL(url string, page dynamo.PageRequest) ([]dynamo.CommentItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.comments.query(url, false, pageLimit(page), start)
+	items = slices.DeleteFunc(items, func(item dynamo.CommentItem) bool {
+		return item.ParentCommentId != ""
+	})
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"url":      stringAttribute(url),
+		"unixTime": numberAttribute(*last),
+	}, nil
+}
+
+func (r *CommentRepository) GetReplies(url string, parentCommentId string, page dynamo.PageRequest) ([]dynamo.CommentItem, map[string]types.AttributeValue, error) {
+	start, err := numberKeyAttribute(page.StartKey, "unixTime")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	all, _ := r.db.comments.query(url, true, 0, start)
+
+	var items []dynamo.CommentItem
+	for _, item := range all {
+		if item.ParentCommentId == parentCommentId {
+			items = append(items, item)
+		}
+	}
+
+	limit := pageLimit(page)
+	if len(items) <= limit {
+		return items, nil, nil
+	}
+	items = items[:limit]
+	last := items[limit-1]
+	return items, map[string]types.AttributeValue{
+		"parentCommentId": stringAttribute(parentCommentId),
+		"unixTime":        numberAttribute(last.UnixTime),
+		"url":             stringAttribute(url),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"testing"
+
+	"pageknock-backend/dynamo"
+)
+
+func TestGetLatestCommentsByURLPages(t *testing.T) {
+	db := NewDB()
+	commentRepo := NewCommentRepository(db)
+	for unixTime := int64(1); unixTime <= 150; unixTime++ {
+		if err := commentRepo.PutComment(dynamo.CommentItem{Url: "https://example.com/a", UnixTime: unixTime}); err != nil {
+			t.Fatalf("PutComment: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		limit     int32
+		wantFirst int64
+		wantLen   int
+	}{
+		{"default limit", 0, 150, 100},
+		{"smaller limit", 10, 150, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var all []dynamo.CommentItem
+			page := dynamo.PageRequest{Limit: tt.limit}
+			items, next, err := commentRepo.GetLatestCommentsByURL("https://example.com/a", page)
+			if err != nil {
+				t.Fatalf("GetLatestCommentsByURL: %v", err)
+			}
+			if len(items) != tt.wantLen || items[0].UnixTime != tt.wantFirst {
+				t.Fatalf("first page has %d items from %d, want %d from %d", len(items), items[0].UnixTime, tt.wantLen, tt.wantFirst)
+			}
+			all = append(all, items...)
+
+			for next != nil {
+				page.StartKey = next
+				items, next, err = commentRepo.GetLatestCommentsByURL("https://example.com/a", page)
+				if err != nil {
+					t.Fatalf("GetLatestCommentsByURL: %v", err)
+				}
+				all = append(all, items...)
+			}
+
+			if len(all) != 150 {
+				t.Fatalf("pages hold %d comments, want 150", len(all))
+			}
+			for i, item := range all {
+				if want := int64(150 - i); item.UnixTime != want {
+					t.Fatalf("comment %d has unixTime %d, want %d", i, item.UnixTime, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Package memory implements the repositories of package dynamo in process
+// memory, so the server can run without AWS.
+package memory
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"pageknock-backend/dynamo"
+)
+
+// DB holds every in-memory table. It plays the role of *dynamodb.Client for
+// the repositories in this package: all repositories created from the same DB
+// share its tables and its lock.
+type DB struct {
+	mu sync.RWMutex
+
+	comments             table[int64, dynamo.CommentItem]
+	commentLogs          table[string, dynamo.CommentLogItem]
+	pageGlobalStructures table[string, dynamo.PageGlobalStructureItem]
+	pageStructures       table[string, dynamo.PageStructureItem]
+	recentDomainComments table[int64, dynamo.RecentDomainCommentItem]
+	recentGlobalComments table[int64, dynamo.RecentGlobalCommentItem]
+	deviceLinks          table[string, dynamo.DeviceLinkItem]
+	rateLimitBuckets     table[string, dynamo.RateLimitBucketItem]
+	heldComments         table[int64, dynamo.HeldCommentItem]
+	commentRevisions     table[int64, dynamo.CommentRevisionItem]
+	reactions            table[string, dynamo.ReactionItem]
+	reports              table[string, dynamo.ReportItem]
+	moderationQueue      table[string, dynamo.ModerationQueueItem]
+	roles                table[string, dynamo.RoleItem]
+	bans                 table[string, dynamo.BanItem]
+	adminAudit           table[string, dynamo.AdminAuditItem]
+	appliedCommentEvents table[string, dynamo.AppliedCommentEventItem]
+	streamCheckpoints    table[string, dynamo.StreamCheckpointItem]
+	outboxTasks          table[string, dynamo.OutboxTaskItem]
+	idempotencyKeys      table[string, dynamo.IdempotencyKeyItem]
+}
+
+func NewDB() *DB {
+	return &DB{
+		comments:             table[int64, dynamo.CommentItem]{},
+		commentLogs:          table[string, dynamo.CommentLogItem]{},
+		pageGlobalStructures: table[string, dynamo.PageGlobalStructureItem]{},
+		pageStructures:       table[string, dynamo.PageStructureItem]{},
+		recentDomainComments: table[int64, dynamo.RecentDomainCommentItem]{},
+		recentGlobalComments: table[int64, dynamo.RecentGlobalCommentItem]{},
+		deviceLinks:          table[string, dynamo.DeviceLinkItem]{},
+		rateLimitBuckets:     table[string, dynamo.RateLimitBucketItem]{},
+		heldComments:         table[int64, dynamo.HeldCommentItem]{},
+		commentRevisions:     table[int64, dynamo.CommentRevisionItem]{},
+		reactions:            table[string, dynamo.ReactionItem]{},
+		reports:              table[string, dynamo.ReportItem]{},
+		moderationQueue:      table[string, dynamo.ModerationQueueItem]{},
+		roles:                table[string, dynamo.RoleItem]{},
+		bans:                 table[string, dynamo.BanItem]{},
+		adminAudit:           table[string, dynamo.AdminAuditItem]{},
+		appliedCommentEvents: table[string, dynamo.AppliedCommentEventItem]{},
+		streamCheckpoints:    table[string, dynamo.StreamCheckpointItem]{},
+		outboxTasks:          table[string, dynamo.OutboxTaskItem]{},
+		idempotencyKeys:      table[string, dynamo.IdempotencyKeyItem]{},
+	}
+}
+
+// table is a partition key -> sort key -> item map with DynamoDB-like
+// semantics: putting an item with an existing key pair replaces it.
+type table[K cmp.Ordered, T any] map[string]map[K]T
+
+func (t table[K, T]) put(pk string, sk K, item T) {
+	partition, ok := t[pk]
+	if !ok {
+		partition = map[K]T{}
+		t[pk] = partition
+	}
+	partition[sk] = item
+}
+
+func (t table[K, T]) get(pk string, sk K) (T, bool) {
+	item, ok := t[pk][sk]
+	return item, ok
+}
+
+// query returns the items of one partition ordered by sort key, like a
+// DynamoDB Query with ScanIndexForward, starting after the sort key start
+// (exclusive, nil for the beginning). A limit of 0 means no limit. The
+// returned last key is the sort key of the final item when more items remain,
+// and nil otherwise.
+func (t table[K, T]) query(pk string, forward bool, limit int, start *K) ([]T, *K) {
+	partition := t[pk]
+	keys := make([]K, 0, len(partition))
+	for k := range partition {
+		if start != nil && ((forward && k <= *start) || (!forward && k >= *start)) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if !forward {
+		slices.Reverse(keys)
+	}
+
+	var last *K
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+		last = &keys[limit-1]
+	}
+
+	items := make([]T, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, partition[k])
+	}
+	return items, last
+}
+
+func (t table[K, T]) delete(pk string, sk K) {
+	partition, ok := t[pk]
+	if !ok {
+		return
+	}
+	delete(partition, sk)
+	if len(partition) == 0 {
+		delete(t, pk)
+	}
+}
+
+// scan returns items across all partitions ordered by partition key then sort
+// key, starting after (startPK, startSK) when startPK is non-nil. Like query,
+// the last key pair is returned only when more items remain.
+func (t table[K, T]) scan(limit int, startPK *string, startSK *K) ([]T, *string, *K) {
+	pks := make([]string, 0, len(t))
+	for pk := range t {
+		if startPK == nil || pk >= *startPK {
+			pks = append(pks, pk)
+		}
+	}
+	slices.Sort(pks)
+
+	var items []T
+	for _, pk := range pks {
+		var start *K
+		if startPK != nil && pk == *startPK {
+			start = startSK
+		}
+
+		remaining := 0
+		if limit > 0 {
+			remaining = limit - len(items)
+		}
+		partitionItems, last := t.query(pk, true, remaining, start)
+		items = append(items, partitionItems...)
+
+		if last != nil {
+			return items, &pk, last
+		}
+		if limit > 0 && len(items) == limit {
+			if pk != pks[len(pks)-1] {
+				return items, &pk, t.lastSortKey(pk)
+			}
+			break
+		}
+	}
+	return items, nil, nil
+}
+
+func (t table[K, T]) lastSortKey(pk string) *K {
+	var last *K
+	for k := range t[pk] {
+		if last == nil || k > *last {
+			last = &k
+		}
+	}
+	return last
+}
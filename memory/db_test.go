@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTableQuery(t *testing.T) {
+	tab := table[int64, int64]{}
+	for sk := int64(1); sk <= 5; sk++ {
+		tab.put("pk", sk, sk)
+	}
+	key := func(sk int64) *int64 { return &sk }
+
+	tests := []struct {
+		name     string
+		forward  bool
+		limit    int
+		start    *int64
+		want     []int64
+		wantLast *int64
+	}{
+		{"ascending", true, 0, nil, []int64{1, 2, 3, 4, 5}, nil},
+		{"descending", false, 0, nil, []int64{5, 4, 3, 2, 1}, nil},
+		{"descending limit", false, 2, nil, []int64{5, 4}, key(4)},
+		{"descending after start", false, 2, key(4), []int64{3, 2}, key(2)},
+		{"ascending after start", true, 0, key(3), []int64{4, 5}, nil},
+		{"limit equal to rest", false, 3, key(4), []int64{3, 2, 1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, last := tab.query("pk", tt.forward, tt.limit, tt.start)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("items = %v, want %v", got, tt.want)
+			}
+			if (last == nil) != (tt.wantLast == nil) || (last != nil && *last != *tt.wantLast) {
+				t.Errorf("last = %v, want %v", last, tt.wantLast)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"fmt"
+	"strconv"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The helpers below convert between the sort keys of the in-memory tables and
+// the DynamoDB key maps carried by dynamo.PageRequest, so cursors look the
+// same whichever backend issued them.
+
+func stringKeyAttribute(key map[string]types.AttributeValue, name string) (*string, error) {
+	if key == nil {
+		return nil, nil
+	}
+	v, ok := key[name].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", dynamo.ErrInvalidCursor, name)
+	}
+	return &v.Value, nil
+}
+
+func numberKeyAttribute(key map[string]types.AttributeValue, name string) (*int64, error) {
+	if key == nil {
+		return nil, nil
+	}
+	v, ok := key[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", dynamo.ErrInvalidCursor, name)
+	}
+	n, err := strconv.ParseInt(v.Value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad %s", dynamo.ErrInvalidCursor, name)
+	}
+	return &n, nil
+}
+
+func stringAttribute(v string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: v}
+}
+
+func numberAttribute(v int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+}
+
+func pageLimit(page dynamo.PageRequest) int {
+	if page.Limit <= 0 {
+		return dynamo.DefaultPageLimit
+	}
+	return int(page.Limit)
+}
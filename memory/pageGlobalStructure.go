@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type PageGlobalStructureRepository struct {
+	db *DB
+}
+
+func NewPageGlobalStructureRepository(db *DB) *PageGlobalStructureRepository {
+	return &PageGlobalStructureRepository{db: db}
+}
+
+func (r *PageGlobalStructureRepository) PutGlobalStructure(item dynamo.PageGlobalStructureItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.pageGlobalStructures.put(item.GlobalKey, item.SiteDomain, item)
+	return nil
+}
+
+func (r *PageGlobalStructureRepository) GetGlobalStructure(page dynamo.PageRequest) ([]dynamo.PageGlobalStructureItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "siteDomain")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.pageGlobalStructures.query("GLOBAL", true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"globalKey":  stringAttribute("GLOBAL"),
+		"siteDomain": stringAttribute(*last),
+	}, nil
+}
+
+func (r *PageGlobalStructureRepository) UpsertGlobalStructureUrlCount(siteDomain string, delta int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	item, ok := r.db.pageGlobalStructures.get("GLOBAL", siteDomain)
+	if !ok {
+		item = dynamo.PageGlobalStructureItem{GlobalKey: "GLOBAL", SiteDomain: siteDomain}
+	}
+	item.UrlCount += delta
+	r.db.pageGlobalStructures.put("GLOBAL", siteDomain, item)
+	return nil
+}
+
+func (r *PageGlobalStructureRepository) DeleteGlobalStructure(siteDomain string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.pageGlobalStructures.delete("GLOBAL", siteDomain)
+	return nil
+}
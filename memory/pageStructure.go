@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type PageStructureRepository struct {
+	db *DB
+}
+
+func NewPageStructureRepository(db *DB) *PageStructureRepository {
+	return &PageStructureRepository{db: db}
+}
+
+func (r *PageStructureRepository) PutStructure(item dynamo.PageStructureItem) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.pageStructures.put(item.SiteDomain, item.Url, item)
+	return nil
+}
+
+func (r *PageStructureRepository) GetStructureBySiteDomain(siteDomain string, page dynamo.PageRequest) ([]dynamo.PageStructureItem, map[string]types.AttributeValue, error) {
+	start, err := stringKeyAttribute(page.StartKey, "url")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, last := r.db.pageStructures.query(siteDomain, true, pageLimit(page), start)
+	if last == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"siteDomain": stringAttribute(siteDomain),
+		"url":        stringAttribute(*last),
+	}, nil
+}
+
+func (r *PageStructureRepository) UpsertStructureCommentCount(siteDomain string, url string, now int64) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	item, existed := r.db.pageStructures.get(siteDomain, url)
+	if !existed {
+		item = dynamo.PageStructureItem{SiteDomain: siteDomain, Url: url}
+	}
+	item.CommentCount++
+	item.LatestUnixTime = now
+	r.db.pageStructures.put(siteDomain, url, item)
+	return !existed, nil
+}
+
+func (r *PageStructureRepository) ScanStructures(page dynamo.PageRequest) ([]dynamo.PageStructureItem, map[string]types.AttributeValue, error) {
+	startPK, err := stringKeyAttribute(page.StartKey, "siteDomain")
+	if err != nil {
+		return nil, nil, err
+	}
+	startSK, err := stringKeyAttribute(page.StartKey, "url")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	items, lastPK, lastSK := r.db.pageStructures.scan(pageLimit(page), startPK, startSK)
+	if lastPK == nil {
+		return items, nil, nil
+	}
+	return items, map[string]types.AttributeValue{
+		"siteDomain": stringAttribute(*lastPK),
+		"url":        stringAttribute(*lastSK),
+	}, nil
+}
+
+func (r *PageStructureRepository) DeleteStructure(siteDomain string, url string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.pageStructures.delete(siteDomain, url)
+	return nil
+}
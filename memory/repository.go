@@ -0,0 +1,28 @@
+package memory
+
+import "pageknock-backend/dynamo"
+
+var (
+	_ dynamo.CommentStore             = (*CommentRepository)(nil)
+	_ dynamo.CommentLogStore          = (*CommentLogRepository)(nil)
+	_ dynamo.PageGlobalStructureStore = (*PageGlobalStructureRepository)(nil)
+	_ dynamo.PageStructureStore       = (*PageStructureRepository)(nil)
+	_ dynamo.RecentDomainCommentStore = (*RecentDomainCommentRepository)(nil)
+	_ dynamo.RecentGlobalCommentStore = (*RecentGlobalCommentRepository)(nil)
+	_ dynamo.CommentTransactionStore  = (*CommentTransactionRepository)(nil)
+	_ dynamo.CommentStreamStore       = (*CommentTransactionRepository)(nil)
+	_ dynamo.StreamCheckpointStore    = (*StreamCheckpointRepository)(nil)
+	_ dynamo.OutboxStore              = (*OutboxRepository)(nil)
+	_ dynamo.IdempotencyKeyStore      = (*IdempotencyKeyRepository)(nil)
+	_ dynamo.OutboxTaskApplier        = (*CommentTransactionRepository)(nil)
+	_ dynamo.DeviceLinkStore          = (*DeviceLinkRepository)(nil)
+	_ dynamo.RateLimitBucketStore     = (*RateLimitBucketRepository)(nil)
+	_ dynamo.HeldCommentStore         = (*HeldCommentRepository)(nil)
+	_ dynamo.CommentRevisionStore     = (*CommentRevisionRepository)(nil)
+	_ dynamo.ReactionStore            = (*ReactionRepository)(nil)
+	_ dynamo.ReportStore              = (*ReportRepository)(nil)
+	_ dynamo.ModerationQueueStore     = (*ModerationQueueRepository)(nil)
+	_ dynamo.RoleStore                = (*RoleRepository)(nil)
+	_ dynamo.BanStore                 = (*BanRepository)(nil)
+	_ dynamo.AdminAuditStore          = (*AdminAuditRepository)(nil)
+)
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"pageknock-backend/dynamo"
+)
+
+// errParentDeleted is returned when a held reply is approved after its
+// parent was deleted.
+var errParentDeleted = errors.New("parent comment deleted")
+
+var reportReasons = []string{"spam", "harassment", "hate", "sexual", "violence", "misinformation", "other"}
+
+const (
+	maxReportTextLength = 1000
+	// queueReportLimit is how many reports each moderation queue entry shows.
+	queueReportLimit = 20
+	// maxReportAttempts bounds the retries when concurrent reports change a
+	// comment's reportCount.
+	maxReportAttempts = 3
+)
+
+// loadModeratorUserIds reads MODERATOR_USER_IDS, the comma-separated accounts
+// that hold the moderator role whatever the role table says. Admins are not
+// included; lookupActor checks ADMIN_USER_IDS first.
+func loadModeratorUserIds() map[string]bool {
+	moderators := map[string]bool{}
+	for _, userId := range splitList(os.Getenv("MODERATOR_USER_IDS")) {
+		moderators[userId] = true
+	}
+	return moderators
+}
+
+// loadReportHideThreshold reads REPORT_HIDE_THRESHOLD, the number of distinct
+// reporters after which a comment is hidden until a moderator approves it.
+func loadReportHideThreshold() int {
+	v := os.Getenv("REPORT_HIDE_THRESHOLD")
+	if v == "" {
+		return 3
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Fatalf("invalid REPORT_HIDE_THRESHOLD: %s", v)
+	}
+	return n
+}
+
+// requireModerator returns the bearer token's user when they may work the
+// moderation queue, and answers 401 or 403 otherwise.
+func requireModerator(w http.ResponseWriter, r *http.Request) (adminActor, bool) {
+	return authorize(w, r, permModerateComment)
+}
+
+// handleReportComment records the caller's report of a comment. Reporting the
+// same comment again is accepted but not counted. Reports hide comments, so
+// only bearer-token accounts may file them; a device ID costs nothing to
+// replace and would let one person count as many reporters.
+func handleReportComment(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	reporterId, err := authenticator.BearerUserID(r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	if !checkReportRateLimit(w, r, reporterId, clientIp(r)) {
+		return
+	}
+
+	var req struct {
+		Url       string `json:"url"`
+		UnixTime  int64  `json:"unixTime"`
+		CommentId string `json:"commentId"`
+		Reason    string `json:"reason"`
+		Text      string `json:"text"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"url": req.Url != "", "unixTime": req.UnixTime != 0, "commentId": req.CommentId != "", "reason": req.Reason != ""}) {
+		return
+	}
+
+	if !slices.Contains(reportReasons, req.Reason) {
+		writeInvalidField(w, r, "reason", fieldUnknownValue)
+		return
+	}
+	if utf8.RuneCountInString(req.Text) > maxReportTextLength {
+		writeInvalidField(w, r, "text", fieldTooLong)
+		return
+	}
+
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(req.Url)
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
+	}
+
+	domain, err := dynamo.GetDomainWithScheme(canonicalUrl)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("URL conversion failed: %w", err))
+		return
+	}
+
+	if !checkBans(w, r, reporterId, clientIp(r), domain) {
+		return
+	}
+
+	report := dynamo.ReportItem{
+		CommentId:  req.CommentId,
+		ReporterID: reporterId,
+		Reason:     req.Reason,
+		Text:       req.Text,
+		UnixTime:   dynamo.GetUnixMillsecound(),
+	}
+
+	for attempt := 0; ; attempt++ {
+		comment, ok, err := commentRepo.GetComment(canonicalUrl, req.UnixTime)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+			return
+		}
+		if !ok || comment.CommentId != req.CommentId {
+			writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+			return
+		}
+
+		hide := !comment.Hidden && comment.ReportCount+1 >= reportHideThreshold
+		err = commentTransactionRepo.ReportComment(comment, domain, report, hide)
+
+		var canceled *dynamo.TransactionCanceledError
+		if errors.As(err, &canceled) && canceled.ConditionFailed(1) {
+			break
+		}
+		if errors.As(err, &canceled) && canceled.ConditionFailed(0) && attempt+1 < maxReportAttempts {
+			continue
+		}
+		if errors.Is(err, dynamo.ErrTransactionCanceled) {
+			writeError(w, r, http.StatusConflict, codeConflict)
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+			return
+		}
+		break
+	}
+
+	resp := map[string]string{
+		"message": "Report received",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetModerationQueue lists reported comments with their reports and
+// the IP, user agent and time they were posted with.
+func handleGetModerationQueue(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	scope := "moderationQueue"
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := moderationQueueRepo.GetModerationQueue(page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch moderation queue: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.ModerationQueueListResponse{
+		Entries:    make([]dynamo.ModerationQueueResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		entry, err := moderationQueueEntry(rec)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch moderation context: %w", err))
+			return
+		}
+		response.Entries = append(response.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func moderationQueueEntry(rec dynamo.ModerationQueueItem) (dynamo.ModerationQueueResponse, error) {
+	entry := dynamo.ModerationQueueResponse{
+		CommentId:      rec.CommentId,
+		Url:            rec.Url,
+		UnixTime:       rec.UnixTime,
+		SiteDomain:     rec.SiteDomain,
+		ReportCount:    rec.ReportCount,
+		LatestReportAt: rec.LatestReportAt,
+		Hidden:         rec.Hidden,
+		Reports:        []dynamo.ReportResponse{},
+	}
+
+	comment, ok, err := commentRepo.GetComment(rec.Url, rec.UnixTime)
+	if err != nil {
+		return dynamo.ModerationQueueResponse{}, err
+	}
+	if ok && comment.CommentId == rec.CommentId {
+		entry.Comment = &dynamo.CommentResponse{
+			UnixTime:        comment.UnixTime,
+			Comment:         comment.Comment,
+			CommentId:       comment.CommentId,
+			UserID:          comment.UserID,
+			EditedAt:        comment.EditedAt,
+			ParentCommentId: comment.ParentCommentId,
+			ReplyCount:      comment.ReplyCount,
+			Reactions:       reactionCounts(comment.Reactions),
+		}
+	}
+
+	reports, _, err := reportRepo.GetReports(rec.CommentId, dynamo.PageRequest{Limit: queueReportLimit})
+	if err != nil {
+		return dynamo.ModerationQueueResponse{}, err
+	}
+	for _, report := range reports {
+		entry.Reports = append(entry.Reports, dynamo.ReportResponse{
+			ReporterID: report.ReporterID,
+			Reason:     report.Reason,
+			Text:       report.Text,
+			UnixTime:   report.UnixTime,
+		})
+	}
+
+	logItem, ok, err := commentLogRepo.GetCommentLog(rec.UnixTime, rec.CommentId)
+	if err != nil {
+		return dynamo.ModerationQueueResponse{}, err
+	}
+	if ok {
+		entry.Log = &dynamo.CommentLogResponse{
+			UnixTime:  logItem.UnixTime,
+			Ip:        logItem.Ip,
+			UserAgent: logItem.UserAgent,
+		}
+	}
+
+	return entry, nil
+}
+
+// handleModerateComment approves (action "approve") or removes (action
+// "remove") a comment in the moderation queue.
+func handleModerateComment(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		enableCORS(w, r)
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+			return
+		}
+
+		moderator, ok := requireModerator(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			CommentId string `json:"commentId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+			return
+		}
+
+		defer r.Body.Close()
+
+		if !requireFields(w, r, map[string]bool{"commentId": req.CommentId != ""}) {
+			return
+		}
+
+		entry, ok, err := moderationQueueRepo.GetModerationQueueItem(req.CommentId)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch moderation queue: %w", err))
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusNotFound, codeNotInModerationQueue)
+			return
+		}
+
+		comment, ok, err := commentRepo.GetComment(entry.Url, entry.UnixTime)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+			return
+		}
+		if !ok || comment.CommentId != entry.CommentId {
+			// The comment is gone already; only the queue entry is left.
+			if err := moderationQueueRepo.DeleteModerationQueueItem(entry.CommentId); err != nil {
+				writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+				return
+			}
+			writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+			return
+		}
+
+		now := dynamo.GetUnixMillsecound()
+		logItem := dynamo.CommentLogItem{
+			GlobalKey: "GLOBAL",
+			LogId:     dynamo.CommentLogId(now, comment.CommentId, action),
+			UnixTime:  now,
+			CommentId: comment.CommentId,
+			Ip:        clientIp(r),
+			UserAgent: dynamo.GetUserAgent(r),
+			Action:    action,
+			UserID:    moderator.UserID,
+		}
+
+		if !writeAudit(w, r, moderator, "moderation."+action, comment.CommentId, "") {
+			return
+		}
+
+		if action == "approve" {
+			err = commentTransactionRepo.ApproveComment(comment, entry.SiteDomain, logItem)
+		} else {
+			err = commentTransactionRepo.DeleteComment(comment, entry.SiteDomain, logItem)
+		}
+		if err != nil {
+			auditFailure(r, moderator, "moderation."+action, comment.CommentId)
+		}
+		if errors.Is(err, dynamo.ErrTransactionCanceled) {
+			writeError(w, r, http.StatusConflict, codeConflict)
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+			return
+		}
+
+		writeAdminResult(w, "Moderation succeeded!")
+	}
+}
+
+// handleGetHeldComments lists the comments a filter held for review, oldest
+// first.
+func handleGetHeldComments(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	if _, ok := requireModerator(w, r); !ok {
+		return
+	}
+
+	scope := "heldComments"
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := heldCommentRepo.GetHeldComments(page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch held comments: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.HeldCommentListResponse{
+		Entries:    make([]dynamo.HeldCommentResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		response.Entries = append(response.Entries, dynamo.HeldCommentResponse{
+			CommentId:       rec.CommentId,
+			Url:             rec.Url,
+			UnixTime:        rec.UnixTime,
+			SiteDomain:      rec.SiteDomain,
+			Comment:         rec.Comment,
+			UserID:          rec.UserID,
+			ParentCommentId: rec.ParentCommentId,
+			Filter:          rec.Filter,
+			Reason:          rec.Reason,
+			Ip:              rec.Ip,
+			UserAgent:       rec.UserAgent,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleModerateHeldComment publishes (action "approve") or discards
+// (action "discard") a comment a filter held for review.
+func handleModerateHeldComment(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		enableCORS(w, r)
+		if r.Method != http.MethodPost {
+			writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+			return
+		}
+
+		moderator, ok := requireModerator(w, r)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			UnixTime  int64  `json:"unixTime"`
+			CommentId string `json:"commentId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+			return
+		}
+
+		defer r.Body.Close()
+
+		if !requireFields(w, r, map[string]bool{"unixTime": req.UnixTime != 0, "commentId": req.CommentId != ""}) {
+			return
+		}
+
+		held, ok, err := heldCommentRepo.GetHeldComment(req.UnixTime)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch held comment: %w", err))
+			return
+		}
+		if !ok || held.CommentId != req.CommentId {
+			writeError(w, r, http.StatusNotFound, codeNotHeld)
+			return
+		}
+
+		if !writeAudit(w, r, moderator, "moderation.held."+action, held.CommentId, "") {
+			return
+		}
+
+		var published *dynamo.CommentItem
+		if action == "approve" {
+			published, err = publishHeldComment(r, held)
+		}
+		if err == nil {
+			err = heldCommentRepo.DeleteHeldComment(held.UnixTime, held.CommentId)
+			// A concurrent request decided on the comment first.
+			if errors.Is(err, dynamo.ErrConditionFailed) && action == "approve" {
+				err = nil
+			}
+		}
+		if err != nil {
+			auditFailure(r, moderator, "moderation.held."+action, held.CommentId)
+		}
+		if errors.Is(err, dynamo.ErrConditionFailed) {
+			writeError(w, r, http.StatusNotFound, codeNotHeld)
+			return
+		}
+		if errors.Is(err, dynamo.ErrTransactionCanceled) || errors.Is(err, errParentDeleted) {
+			writeError(w, r, http.StatusConflict, codeConflict)
+			return
+		}
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+			return
+		}
+
+		if published != nil {
+			publishComment(*published, held.SiteDomain)
+		}
+
+		writeAdminResult(w, "Moderation succeeded!")
+	}
+}
+
+// publishHeldComment stores held as a comment posted at the time it was
+// held, or a millisecond or two later when another comment on the URL has
+// that key, with the IP and user agent it was posted with. It returns nil when
+// an earlier approval stored the comment already, so that an approval whose
+// held row could not be deleted can be repeated. A reply whose parent has
+// been deleted since fails with errParentDeleted.
+func publishHeldComment(r *http.Request, held dynamo.HeldCommentItem) (*dynamo.CommentItem, error) {
+	var parent *dynamo.CommentItem
+	if held.ParentCommentId != "" {
+		item, ok, err := commentRepo.GetCommentByID(held.ParentCommentId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch parent comment: %w", err)
+		}
+		if !ok {
+			return nil, errParentDeleted
+		}
+		parent = &item
+	}
+
+	baseFieldDatas := dynamo.BaseFieldDatas{
+		Comment:    held.Comment,
+		CommentId:  held.CommentId,
+		SiteDomain: held.SiteDomain,
+		Now:        held.UnixTime,
+		Req:        r,
+		Ip:         held.Ip,
+		Url:        held.Url,
+		UserId:     held.UserID,
+		Parent:     parent,
+	}
+
+	// As in submitComment, another comment stored on the URL in the same
+	// millisecond moves this one a millisecond later. Finding the held
+	// comment itself in the slot means an earlier approval published it.
+	var records dynamo.AllTableRecords
+	var err error
+	for attempt := 1; ; attempt++ {
+		records = dynamo.GenerateAllTableRecords(baseFieldDatas)
+		records.CommentLogItem.UserAgent = held.UserAgent
+		err = commentTransactionRepo.PutAllTableRecords(records)
+
+		var canceled *dynamo.TransactionCanceledError
+		if !errors.As(err, &canceled) || !canceled.ConditionFailed(0) {
+			break
+		}
+		existing, ok, getErr := commentRepo.GetComment(held.Url, baseFieldDatas.Now)
+		if getErr != nil {
+			return nil, fmt.Errorf("failed to fetch comment: %w", getErr)
+		}
+		if ok && existing.CommentId == held.CommentId {
+			return nil, nil
+		}
+		if attempt >= maxCommentKeyAttempts {
+			break
+		}
+		baseFieldDatas.Now++
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &records.CommentItem, nil
+}
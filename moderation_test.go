@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/memory"
+)
+
+func useMemoryComments(t *testing.T) {
+	t.Helper()
+	comments, transactions := commentRepo, commentTransactionRepo
+	db := memory.NewDB()
+	commentRepo = memory.NewCommentRepository(db)
+	commentTransactionRepo = memory.NewCommentTransactionRepository(db)
+	t.Cleanup(func() { commentRepo, commentTransactionRepo = comments, transactions })
+}
+
+func TestPublishHeldCommentWhoseKeyIsTaken(t *testing.T) {
+	useMemoryComments(t)
+	r := httptest.NewRequest(http.MethodPost, "/moderation/held/approve", nil)
+
+	// Another comment on the URL was stored in the millisecond the held
+	// comment was posted.
+	other := dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+		Comment:    "first",
+		CommentId:  "comment-1",
+		SiteDomain: "https://example.com",
+		Now:        1,
+		Req:        r,
+		Url:        "https://example.com/a",
+		UserId:     "1",
+	})
+	if err := commentTransactionRepo.PutAllTableRecords(other); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+
+	held := dynamo.HeldCommentItem{
+		GlobalKey:  "GLOBAL",
+		UnixTime:   1,
+		CommentId:  "comment-2",
+		Url:        "https://example.com/a",
+		SiteDomain: "https://example.com",
+		Comment:    "held",
+		UserID:     "2",
+	}
+	published, err := publishHeldComment(r, held)
+	if err != nil {
+		t.Fatalf("publishHeldComment: %v", err)
+	}
+	if published == nil || published.UnixTime != 2 {
+		t.Fatalf("publishHeldComment = %+v, want the comment stored at unixTime 2", published)
+	}
+	if got, ok, _ := commentRepo.GetComment(held.Url, 1); !ok || got.CommentId != "comment-1" {
+		t.Errorf("comment at unixTime 1 = %+v, %v; want comment-1", got, ok)
+	}
+
+	// Repeating the approval finds the comment published already.
+	again, err := publishHeldComment(r, held)
+	if err != nil || again != nil {
+		t.Errorf("repeated publishHeldComment = %+v, %v; want nil, nil", again, err)
+	}
+}
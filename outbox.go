@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/outbox"
+)
+
+func loadOutboxConfig() outbox.Config {
+	return outbox.Config{
+		MaxAttempts:  loadFilterInt("OUTBOX_MAX_ATTEMPTS", 8),
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		PollInterval: time.Second,
+		BatchSize:    25,
+	}
+}
+
+func outboxTaskResponse(task dynamo.OutboxTaskItem) dynamo.OutboxTaskResponse {
+	return dynamo.OutboxTaskResponse{
+		TaskId:          task.TaskId,
+		Status:          task.Status,
+		Kind:            task.Kind,
+		CommentId:       task.CommentId,
+		Url:             task.Url,
+		SiteDomain:      task.SiteDomain,
+		CommentUnixTime: task.CommentUnixTime,
+		Attempts:        task.Attempts,
+		NextAttemptAt:   task.NextAttemptAt,
+		LastError:       task.LastError,
+		CreatedAt:       task.CreatedAt,
+		UpdatedAt:       task.UpdatedAt,
+	}
+}
+
+// handleAdminGetOutbox lists outbox tasks, oldest first: dead-lettered ones
+// by default, or with ?status=pending those still waiting, including the
+// ones being retried.
+func handleAdminGetOutbox(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	if _, ok := authorize(w, r, permManageOutbox); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = dynamo.OutboxDead
+	}
+	if status != dynamo.OutboxDead && status != dynamo.OutboxPending {
+		writeInvalidField(w, r, "status", fieldUnknownValue)
+		return
+	}
+
+	scope := "outbox:" + status
+	page, err := parsePageRequest(scope, r.URL.Query().Get("cursor"), r.URL.Query().Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	records, lastKey, err := outboxRepo.GetOutboxTasks(status, page)
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch outbox tasks: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	response := dynamo.OutboxTaskListResponse{
+		Tasks:      make([]dynamo.OutboxTaskResponse, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, rec := range records {
+		response.Tasks = append(response.Tasks, outboxTaskResponse(rec))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAdminReplayOutbox makes a dead-lettered task pending again, with its
+// attempts reset, for the outbox worker to pick up.
+func handleAdminReplayOutbox(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	actor, ok := authorize(w, r, permManageOutbox)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TaskId string `json:"taskId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"taskId": req.TaskId != ""}) {
+		return
+	}
+
+	if !writeAudit(w, r, actor, "outbox.replay", req.TaskId, "") {
+		return
+	}
+
+	_, ok, err := outboxRepo.ReplayOutboxTask(req.TaskId, dynamo.GetUnixMillsecound())
+	if err != nil || !ok {
+		auditFailure(r, actor, "outbox.replay", req.TaskId)
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeOutboxTaskNotFound)
+		return
+	}
+
+	writeAdminResult(w, "Replay succeeded!")
+}
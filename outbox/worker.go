@@ -0,0 +1,114 @@
+// Package outbox applies the outbox tasks recorded by comment writes (see
+// dynamo.OutboxTaskItem), retrying failed tasks with exponential backoff and
+// dead-lettering those that keep failing.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pageknock-backend/dynamo"
+)
+
+type Config struct {
+	// MaxAttempts is how many times a task is tried before it is
+	// dead-lettered.
+	MaxAttempts int
+	// BaseDelay is the wait after the first attempt; it doubles with every
+	// attempt up to MaxDelay. It also bounds how long an attempt may take
+	// before another worker may try the task again.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// PollInterval is how often due tasks are looked for.
+	PollInterval time.Duration
+	// BatchSize is the most tasks applied per poll.
+	BatchSize int32
+}
+
+// Worker applies due tasks. Any number of workers may share a store: a task
+// is claimed before it is applied, and applying a task twice is harmless.
+type Worker struct {
+	store   dynamo.OutboxStore
+	applier dynamo.OutboxTaskApplier
+	config  Config
+}
+
+func NewWorker(store dynamo.OutboxStore, applier dynamo.OutboxTaskApplier, config Config) *Worker {
+	return &Worker{store: store, applier: applier, config: config}
+}
+
+// Run polls until ctx is done. Failures are logged and retried on the next
+// poll.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Poll(time.Now()); err != nil {
+			log.Printf("outbox poll failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll tries every task due at now, up to BatchSize.
+func (w *Worker) Poll(now time.Time) error {
+	tasks, err := w.store.GetDueOutboxTasks(now.UnixMilli(), w.config.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to get outbox tasks: %w", err)
+	}
+
+	var errs []error
+	for _, task := range tasks {
+		if err := w.try(task, now); err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", task.TaskId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// try claims task and applies it. A task another worker claimed first is
+// left to that worker. The error of applying the task is not returned but
+// recorded on it.
+func (w *Worker) try(task dynamo.OutboxTaskItem, now time.Time) error {
+	claimed, err := w.store.ClaimOutboxTask(task, now.Add(w.backoff(task.Attempts+1)).UnixMilli())
+	if errors.Is(err, dynamo.ErrConditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim: %w", err)
+	}
+
+	applyErr := w.applier.ApplyOutboxTask(claimed)
+	if applyErr == nil {
+		return w.store.CompleteOutboxTask(claimed)
+	}
+
+	if claimed.Attempts >= w.config.MaxAttempts {
+		log.Printf("outbox task %s (%s %s) dead-lettered after %d attempts: %v", claimed.TaskId, claimed.Kind, claimed.CommentId, claimed.Attempts, applyErr)
+		err = w.store.DeadLetterOutboxTask(claimed, applyErr.Error())
+	} else {
+		log.Printf("outbox task %s (%s %s) attempt %d failed: %v", claimed.TaskId, claimed.Kind, claimed.CommentId, claimed.Attempts, applyErr)
+		err = w.store.FailOutboxTask(claimed, applyErr.Error())
+	}
+	if errors.Is(err, dynamo.ErrConditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// backoff returns the wait after the attempt-th attempt.
+func (w *Worker) backoff(attempt int) time.Duration {
+	delay := w.config.BaseDelay
+	for i := 1; i < attempt && delay < w.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, w.config.MaxDelay)
+}
@@ -0,0 +1,224 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/memory"
+)
+
+var testConfig = Config{
+	MaxAttempts:  3,
+	BaseDelay:    time.Second,
+	MaxDelay:     4 * time.Second,
+	PollInterval: time.Second,
+	BatchSize:    10,
+}
+
+// flakyApplier fails the first failures tasks it is given.
+type flakyApplier struct {
+	dynamo.OutboxTaskApplier
+	failures int
+}
+
+func (a *flakyApplier) ApplyOutboxTask(task dynamo.OutboxTaskItem) error {
+	if a.failures > 0 {
+		a.failures--
+		return errors.New("throttled")
+	}
+	return a.OutboxTaskApplier.ApplyOutboxTask(task)
+}
+
+type harness struct {
+	db      *memory.DB
+	store   *memory.OutboxRepository
+	applier *flakyApplier
+	worker  *Worker
+	start   time.Time
+}
+
+func newHarness(t *testing.T, failures int) *harness {
+	db := memory.NewDB()
+	h := &harness{
+		db:      db,
+		store:   memory.NewOutboxRepository(db),
+		applier: &flakyApplier{OutboxTaskApplier: memory.NewCommentTransactionRepository(db), failures: failures},
+		start:   time.UnixMilli(1_700_000_000_000),
+	}
+	h.worker = NewWorker(h.store, h.applier, testConfig)
+
+	err := h.store.PutOutboxTask(dynamo.OutboxTaskItem{
+		Status:          dynamo.OutboxPending,
+		TaskId:          dynamo.GenerateOutboxTaskId(h.start.UnixMilli()),
+		Kind:            dynamo.OutboxStructureAdd,
+		CommentId:       "comment-1",
+		Url:             "https://example.com/a",
+		SiteDomain:      "https://example.com",
+		CommentUnixTime: h.start.UnixMilli(),
+		NextAttemptAt:   h.start.UnixMilli(),
+	})
+	if err != nil {
+		t.Fatalf("PutOutboxTask: %v", err)
+	}
+	return h
+}
+
+func (h *harness) poll(t *testing.T, after time.Duration) {
+	t.Helper()
+	if err := h.worker.Poll(h.start.Add(after)); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+}
+
+func (h *harness) tasks(t *testing.T, status string) []dynamo.OutboxTaskItem {
+	t.Helper()
+	tasks, _, err := h.store.GetOutboxTasks(status, dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetOutboxTasks: %v", err)
+	}
+	return tasks
+}
+
+func (h *harness) commentCount(t *testing.T) int {
+	t.Helper()
+	structures, _, err := memory.NewPageStructureRepository(h.db).GetStructureBySiteDomain("https://example.com", dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetStructureBySiteDomain: %v", err)
+	}
+	count := 0
+	for _, s := range structures {
+		count += s.CommentCount
+	}
+	return count
+}
+
+func TestWorkerAppliesDueTask(t *testing.T) {
+	h := newHarness(t, 0)
+
+	h.poll(t, 0)
+
+	if n := h.commentCount(t); n != 1 {
+		t.Errorf("commentCount = %d, want 1", n)
+	}
+	if tasks := h.tasks(t, dynamo.OutboxPending); len(tasks) != 0 {
+		t.Errorf("pending tasks = %+v, want none", tasks)
+	}
+}
+
+func TestWorkerRetriesWithBackoff(t *testing.T) {
+	h := newHarness(t, 2)
+
+	h.poll(t, 0)
+	task := h.tasks(t, dynamo.OutboxPending)[0]
+	if task.Attempts != 1 || task.LastError != "throttled" || task.NextAttemptAt != h.start.Add(time.Second).UnixMilli() {
+		t.Fatalf("after the first failure task = %+v, want attempt 1 retried in 1s", task)
+	}
+
+	// Not due yet.
+	h.poll(t, 500*time.Millisecond)
+	if task := h.tasks(t, dynamo.OutboxPending)[0]; task.Attempts != 1 {
+		t.Fatalf("task tried before it was due: %+v", task)
+	}
+
+	h.poll(t, time.Second)
+	task = h.tasks(t, dynamo.OutboxPending)[0]
+	if task.Attempts != 2 || task.NextAttemptAt != h.start.Add(3*time.Second).UnixMilli() {
+		t.Fatalf("after the second failure task = %+v, want attempt 2 retried in 2s", task)
+	}
+
+	h.poll(t, 3*time.Second)
+	if tasks := h.tasks(t, dynamo.OutboxPending); len(tasks) != 0 {
+		t.Errorf("pending tasks = %+v, want none", tasks)
+	}
+	if n := h.commentCount(t); n != 1 {
+		t.Errorf("commentCount = %d, want 1", n)
+	}
+}
+
+func TestWorkerDeadLettersAndReplays(t *testing.T) {
+	h := newHarness(t, testConfig.MaxAttempts)
+
+	for _, after := range []time.Duration{0, time.Second, 3 * time.Second} {
+		h.poll(t, after)
+	}
+
+	dead := h.tasks(t, dynamo.OutboxDead)
+	if len(dead) != 1 || dead[0].Attempts != testConfig.MaxAttempts || dead[0].LastError != "throttled" {
+		t.Fatalf("dead tasks = %+v, want the task after %d attempts", dead, testConfig.MaxAttempts)
+	}
+	if tasks := h.tasks(t, dynamo.OutboxPending); len(tasks) != 0 {
+		t.Fatalf("pending tasks = %+v, want none", tasks)
+	}
+	h.poll(t, time.Hour)
+	if n := h.commentCount(t); n != 0 {
+		t.Fatalf("commentCount = %d, want a dead task left alone", n)
+	}
+
+	replayAt := h.start.Add(time.Hour)
+	if _, ok, err := h.store.ReplayOutboxTask(dead[0].TaskId, replayAt.UnixMilli()); err != nil || !ok {
+		t.Fatalf("ReplayOutboxTask: %v, %v", ok, err)
+	}
+	h.poll(t, time.Hour)
+
+	if n := h.commentCount(t); n != 1 {
+		t.Errorf("commentCount = %d, want 1", n)
+	}
+	if tasks := h.tasks(t, dynamo.OutboxDead); len(tasks) != 0 {
+		t.Errorf("dead tasks = %+v, want none", tasks)
+	}
+}
+
+func TestWorkerRemoveBeforeReplayedAdd(t *testing.T) {
+	h := newHarness(t, testConfig.MaxAttempts)
+	for _, after := range []time.Duration{0, time.Second, 3 * time.Second} {
+		h.poll(t, after)
+	}
+	dead := h.tasks(t, dynamo.OutboxDead)
+	if len(dead) != 1 {
+		t.Fatalf("dead tasks = %+v, want the add", dead)
+	}
+
+	// The comment is deleted while its add is dead-lettered.
+	removeAt := h.start.Add(time.Minute)
+	err := h.store.PutOutboxTask(dynamo.OutboxTaskItem{
+		Status:          dynamo.OutboxPending,
+		TaskId:          dynamo.GenerateOutboxTaskId(removeAt.UnixMilli()),
+		Kind:            dynamo.OutboxStructureRemove,
+		CommentId:       "comment-1",
+		Url:             "https://example.com/a",
+		SiteDomain:      "https://example.com",
+		CommentUnixTime: h.start.UnixMilli(),
+		NextAttemptAt:   removeAt.UnixMilli(),
+	})
+	if err != nil {
+		t.Fatalf("PutOutboxTask: %v", err)
+	}
+	h.poll(t, time.Minute)
+
+	if _, ok, err := h.store.ReplayOutboxTask(dead[0].TaskId, h.start.Add(time.Hour).UnixMilli()); err != nil || !ok {
+		t.Fatalf("ReplayOutboxTask: %v, %v", ok, err)
+	}
+	h.poll(t, time.Hour)
+
+	structures, _, err := memory.NewPageStructureRepository(h.db).GetStructureBySiteDomain("https://example.com", dynamo.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetStructureBySiteDomain: %v", err)
+	}
+	if len(structures) != 0 {
+		t.Errorf("page structures = %+v, want none for a deleted comment", structures)
+	}
+	if tasks := h.tasks(t, dynamo.OutboxPending); len(tasks) != 0 {
+		t.Errorf("pending tasks = %+v, want none", tasks)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	w := NewWorker(nil, nil, testConfig)
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 4 * time.Second} {
+		if got := w.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package pubsub fans events out to in-process subscribers by topic.
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is one published message. IDs increase across all topics.
+type Event struct {
+	ID     uint64
+	Name   string
+	Topics []string
+	Data   []byte
+}
+
+func (e Event) hasTopic(topic string) bool {
+	for _, t := range e.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub delivers published events to the subscribers of their topics and keeps
+// the most recent ones so that a reconnecting subscriber can resume.
+type Hub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	historySize int
+	bufferSize  int
+	subscribers map[string]map[*Subscription]struct{}
+}
+
+// NewHub keeps the last historySize events for resuming and gives every
+// subscriber a buffer of bufferSize events.
+//
+// Event IDs start from the current time in microseconds rather than zero, so
+// IDs issued after a restart are still greater than the ones before it and a
+// client resuming across a restart is not sent events it has already seen.
+func NewHub(historySize int, bufferSize int) *Hub {
+	return &Hub{
+		nextID:      uint64(time.Now().UnixMicro()),
+		historySize: historySize,
+		bufferSize:  bufferSize,
+		subscribers: map[string]map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives the events of one topic on C. C is closed when the
+// subscriber fell a full buffer behind or was closed; a closed subscriber
+// resubscribes with the last ID it received to continue.
+type Subscription struct {
+	C <-chan Event
+	// Backlog holds the events published after the lastID given to
+	// Subscribe, to be handled before those on C.
+	Backlog []Event
+
+	hub   *Hub
+	topic string
+	c     chan Event
+}
+
+// Subscribe starts receiving the events on topic. When resume is set, the
+// events after lastID still in the history are put in Backlog.
+func (h *Hub) Subscribe(topic string, lastID uint64, resume bool) *Subscription {
+	c := make(chan Event, h.bufferSize)
+	sub := &Subscription{C: c, hub: h, topic: topic, c: c}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if resume {
+		for _, event := range h.history {
+			if event.ID > lastID && event.hasTopic(topic) {
+				sub.Backlog = append(sub.Backlog, event)
+			}
+		}
+	}
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = map[*Subscription]struct{}{}
+	}
+	h.subscribers[topic][sub] = struct{}{}
+	return sub
+}
+
+// Close stops the subscription. Closing it twice is harmless.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// remove must be called with mu held.
+func (h *Hub) remove(sub *Subscription) {
+	topicSubscribers := h.subscribers[sub.topic]
+	if _, ok := topicSubscribers[sub]; !ok {
+		return
+	}
+	delete(topicSubscribers, sub)
+	if len(topicSubscribers) == 0 {
+		delete(h.subscribers, sub.topic)
+	}
+	close(sub.c)
+}
+
+// Publish sends an event to the subscribers of any of topics and returns its
+// ID. It never blocks: a subscriber whose buffer is full is dropped.
+func (h *Hub) Publish(name string, topics []string, data []byte) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	event := Event{ID: h.nextID, Name: name, Topics: topics, Data: data}
+
+	if h.historySize > 0 {
+		if len(h.history) == h.historySize {
+			h.history = append(h.history[:0], h.history[1:]...)
+		}
+		h.history = append(h.history, event)
+	}
+
+	for _, topic := range topics {
+		for sub := range h.subscribers[topic] {
+			select {
+			case sub.c <- event:
+			default:
+				h.remove(sub)
+			}
+		}
+	}
+	return event.ID
+}
@@ -0,0 +1,152 @@
+// Package ratelimit enforces token-bucket limits on comment posting.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"pageknock-backend/dynamo"
+)
+
+// maxAttempts bounds the compare-and-set retries of one take when other
+// requests keep updating the same bucket.
+const maxAttempts = 5
+
+// contentionRetryAfter is the wait returned when a bucket is too contended to
+// take a token from in maxAttempts tries. The request is denied as if the
+// bucket were empty, rather than failed.
+const contentionRetryAfter = time.Second
+
+// Limit is a token bucket: up to Burst requests at once, refilled at
+// PerMinute requests per minute, which must be positive. A zero Burst
+// disables the limit.
+type Limit struct {
+	Burst     float64
+	PerMinute float64
+}
+
+func (l Limit) enabled() bool {
+	return l.Burst > 0
+}
+
+// Rule applies a Limit to one bucket, such as "user:<id>" or "ip:<addr>".
+type Rule struct {
+	Key   string
+	Limit Limit
+}
+
+// Limiter takes tokens from buckets kept in a dynamo.RateLimitBucketStore.
+// Backed by the in-memory store it limits a single process; backed by
+// DynamoDB every instance sharing the table enforces the same limits.
+type Limiter struct {
+	store dynamo.RateLimitBucketStore
+	now   func() time.Time
+}
+
+func NewLimiter(store dynamo.RateLimitBucketStore) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes one token from the bucket of every rule. Every bucket is
+// checked before any token is taken, so a request denied by one bucket spends
+// none of the others'. When a bucket is empty it returns false with the time
+// until every empty bucket has a token again. A bucket that empties between
+// the check and the take still denies the request; the tokens taken from the
+// buckets before it are then spent.
+func (l *Limiter) Allow(rules ...Rule) (bool, time.Duration, error) {
+	var enabled []Rule
+	for _, rule := range rules {
+		if rule.Limit.enabled() {
+			enabled = append(enabled, rule)
+		}
+	}
+
+	var wait time.Duration
+	for _, rule := range enabled {
+		tokens, _, err := l.available(rule, l.now())
+		if err != nil {
+			return false, 0, fmt.Errorf("rate limit %s: %w", rule.Key, err)
+		}
+		if tokens < 1 {
+			wait = max(wait, rule.Limit.wait(tokens))
+		}
+	}
+	if wait > 0 {
+		return false, wait, nil
+	}
+
+	for _, rule := range enabled {
+		allowed, retryAfter, err := l.take(rule)
+		if err != nil {
+			return false, 0, fmt.Errorf("rate limit %s: %w", rule.Key, err)
+		}
+		if !allowed {
+			return false, retryAfter, nil
+		}
+	}
+	return true, 0, nil
+}
+
+// available returns the tokens in rule's bucket at now and the version of
+// the stored bucket, 0 if there is none.
+func (l *Limiter) available(rule Rule, now time.Time) (float64, int64, error) {
+	bucket, ok, err := l.store.GetBucket(rule.Key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok || bucket.ExpiresAt < now.Unix() {
+		bucket.Tokens = rule.Limit.Burst
+		bucket.UpdatedAt = now.UnixMilli()
+	}
+
+	elapsed := max(now.UnixMilli()-bucket.UpdatedAt, 0)
+	return min(rule.Limit.Burst, bucket.Tokens+float64(elapsed)*rule.Limit.perMilli()), bucket.Version, nil
+}
+
+func (l *Limiter) take(rule Rule) (bool, time.Duration, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		now := l.now()
+
+		tokens, previousVersion, err := l.available(rule, now)
+		if err != nil {
+			return false, 0, err
+		}
+		if tokens < 1 {
+			return false, rule.Limit.wait(tokens), nil
+		}
+
+		err = l.store.PutBucket(dynamo.RateLimitBucketItem{
+			BucketKey: rule.Key,
+			Tokens:    tokens - 1,
+			UpdatedAt: now.UnixMilli(),
+			Version:   previousVersion + 1,
+			ExpiresAt: now.Add(refillTime(rule.Limit)).Unix() + 1,
+		}, previousVersion)
+		if errors.Is(err, dynamo.ErrConditionFailed) {
+			continue
+		}
+		if err != nil {
+			return false, 0, err
+		}
+		return true, 0, nil
+	}
+
+	return false, contentionRetryAfter, nil
+}
+
+func (l Limit) perMilli() float64 {
+	return l.PerMinute / float64(time.Minute/time.Millisecond)
+}
+
+// wait is how long a bucket holding tokens takes to refill to one token.
+func (l Limit) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1-tokens)/l.perMilli())) * time.Millisecond
+}
+
+// refillTime is how long an untouched bucket takes to become full again,
+// after which it can be forgotten.
+func refillTime(limit Limit) time.Duration {
+	return time.Duration(limit.Burst / limit.PerMinute * float64(time.Minute))
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/memory"
+)
+
+// newTestLimiter returns a limiter over an in-memory store whose clock only
+// moves when the returned function is called.
+func newTestLimiter(store dynamo.RateLimitBucketStore) (*Limiter, func(time.Duration)) {
+	now := time.UnixMilli(1_700_000_000_000)
+	l := NewLimiter(store)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func allow(t *testing.T, l *Limiter, rules ...Rule) (bool, time.Duration) {
+	t.Helper()
+	allowed, retryAfter, err := l.Allow(rules...)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	return allowed, retryAfter
+}
+
+func TestAllowSpendsBurstThenRefills(t *testing.T) {
+	l, advance := newTestLimiter(memory.NewRateLimitBucketRepository(memory.NewDB()))
+	rule := Rule{Key: "user:1", Limit: Limit{Burst: 3, PerMinute: 6}}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := allow(t, l, rule); !allowed {
+			t.Fatalf("request %d denied within the burst", i+1)
+		}
+	}
+	allowed, retryAfter := allow(t, l, rule)
+	if allowed {
+		t.Fatal("request allowed past the burst")
+	}
+	if retryAfter != 10*time.Second {
+		t.Errorf("retryAfter = %v, want 10s at 6 per minute", retryAfter)
+	}
+
+	advance(5 * time.Second)
+	if _, retryAfter := allow(t, l, rule); retryAfter != 5*time.Second {
+		t.Errorf("retryAfter = %v after 5s, want 5s", retryAfter)
+	}
+
+	advance(5 * time.Second)
+	if allowed, _ := allow(t, l, rule); !allowed {
+		t.Error("request denied after a token was refilled")
+	}
+	if allowed, _ := allow(t, l, rule); allowed {
+		t.Error("second request allowed with only one token refilled")
+	}
+}
+
+func TestAllowRefillsUpToBurst(t *testing.T) {
+	l, advance := newTestLimiter(memory.NewRateLimitBucketRepository(memory.NewDB()))
+	rule := Rule{Key: "user:1", Limit: Limit{Burst: 2, PerMinute: 60}}
+
+	allow(t, l, rule)
+	advance(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := allow(t, l, rule); !allowed {
+			t.Fatalf("request %d denied after a full refill", i+1)
+		}
+	}
+	if allowed, _ := allow(t, l, rule); allowed {
+		t.Error("bucket refilled past its burst")
+	}
+}
+
+func TestAllowDeniedRequestSpendsNoToken(t *testing.T) {
+	l, _ := newTestLimiter(memory.NewRateLimitBucketRepository(memory.NewDB()))
+	user := Rule{Key: "user:1", Limit: Limit{Burst: 2, PerMinute: 1}}
+	domain := Rule{Key: "domain:a", Limit: Limit{Burst: 1, PerMinute: 1}}
+
+	if allowed, _ := allow(t, l, user, domain); !allowed {
+		t.Fatal("first request denied")
+	}
+	// The domain bucket is empty, so the user's last token must survive.
+	if allowed, _ := allow(t, l, user, domain); allowed {
+		t.Fatal("request allowed with the domain bucket empty")
+	}
+	if allowed, _ := allow(t, l, user); !allowed {
+		t.Error("user bucket was spent by a request the domain bucket denied")
+	}
+}
+
+func TestAllowReportsLongestWait(t *testing.T) {
+	l, _ := newTestLimiter(memory.NewRateLimitBucketRepository(memory.NewDB()))
+	fast := Rule{Key: "fast", Limit: Limit{Burst: 1, PerMinute: 60}}
+	slow := Rule{Key: "slow", Limit: Limit{Burst: 1, PerMinute: 1}}
+
+	allow(t, l, fast, slow)
+	if _, retryAfter := allow(t, l, fast, slow); retryAfter != time.Minute {
+		t.Errorf("retryAfter = %v, want the slow bucket's 1m", retryAfter)
+	}
+}
+
+func TestAllowIgnoresDisabledLimit(t *testing.T) {
+	l, _ := newTestLimiter(memory.NewRateLimitBucketRepository(memory.NewDB()))
+	rule := Rule{Key: "user:1", Limit: Limit{}}
+
+	for i := 0; i < 100; i++ {
+		if allowed, _ := allow(t, l, rule); !allowed {
+			t.Fatalf("request %d denied by a disabled limit", i+1)
+		}
+	}
+}
+
+// contendedStore fails every write as if another request had just updated
+// the bucket.
+type contendedStore struct {
+	dynamo.RateLimitBucketStore
+}
+
+func (s contendedStore) PutBucket(item dynamo.RateLimitBucketItem, previousVersion int64) error {
+	return dynamo.ErrConditionFailed
+}
+
+func TestAllowDeniesUnderContention(t *testing.T) {
+	l, _ := newTestLimiter(contendedStore{memory.NewRateLimitBucketRepository(memory.NewDB())})
+
+	allowed, retryAfter := allow(t, l, Rule{Key: "domain:a", Limit: Limit{Burst: 5, PerMinute: 5}})
+	if allowed || retryAfter != contentionRetryAfter {
+		t.Errorf("Allow = %v, %v, want denied with %v", allowed, retryAfter, contentionRetryAfter)
+	}
+}
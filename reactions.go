@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+
+	"pageknock-backend/dynamo"
+)
+
+var defaultReactionTypes = []string{"knock", "like", "funny", "wow", "sad"}
+
+// loadReactionTypes reads REACTION_TYPES, the comma-separated reaction types
+// (names or emoji) users can choose from.
+func loadReactionTypes() []string {
+	if types := splitList(os.Getenv("REACTION_TYPES")); len(types) > 0 {
+		return types
+	}
+	return defaultReactionTypes
+}
+
+// reactionCounts returns the counts of the configured reaction types that at
+// least one user chose, so types removed from the configuration disappear
+// from responses.
+func reactionCounts(counts map[string]int) map[string]int {
+	visible := map[string]int{}
+	for _, t := range reactionTypes {
+		if counts[t] > 0 {
+			visible[t] = counts[t]
+		}
+	}
+	return visible
+}
+
+// handleReactComment toggles the caller's reaction of one type on a comment:
+// the first call adds it, the next removes it.
+func handleReactComment(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	identity, err := authenticator.Identify(w, r)
+	if err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	var req struct {
+		Url       string `json:"url"`
+		UnixTime  int64  `json:"unixTime"`
+		CommentId string `json:"commentId"`
+		Type      string `json:"type"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidJSON)
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !requireFields(w, r, map[string]bool{"url": req.Url != "", "unixTime": req.UnixTime != 0, "commentId": req.CommentId != "", "type": req.Type != ""}) {
+		return
+	}
+
+	if !slices.Contains(reactionTypes, req.Type) {
+		writeInvalidField(w, r, "type", fieldUnknownValue)
+		return
+	}
+
+	canonicalUrl, err := urlCanonicalizer.Canonicalize(req.Url)
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
+	}
+
+	domain, err := dynamo.GetDomainWithScheme(canonicalUrl)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("URL conversion failed: %w", err))
+		return
+	}
+
+	if !checkBans(w, r, identity.UserID, clientIp(r), domain) {
+		return
+	}
+
+	comment, ok, err := commentRepo.GetComment(canonicalUrl, req.UnixTime)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comment: %w", err))
+		return
+	}
+	if !ok || comment.CommentId != req.CommentId {
+		writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+		return
+	}
+
+	_, reacted, err := reactionRepo.GetReaction(comment.CommentId, req.Type, identity.UserID)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch reaction: %w", err))
+		return
+	}
+
+	reaction := dynamo.ReactionItem{
+		CommentId: comment.CommentId,
+		Key:       dynamo.ReactionKey(req.Type, identity.UserID),
+		Type:      req.Type,
+		UserID:    identity.UserID,
+		UnixTime:  dynamo.GetUnixMillsecound(),
+	}
+
+	err = commentTransactionRepo.SetReaction(comment, domain, reaction, !reacted)
+	if errors.Is(err, dynamo.ErrTransactionCanceled) {
+		writeError(w, r, http.StatusConflict, codeConflict)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("DynamoDB write failed: %w", err))
+		return
+	}
+
+	counts := maps.Clone(comment.Reactions)
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	if reacted {
+		counts[req.Type]--
+	} else {
+		counts[req.Type]++
+	}
+
+	publishReaction(comment, counts)
+
+	resp := map[string]any{
+		"reacted":   !reacted,
+		"reactions": reactionCounts(counts),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
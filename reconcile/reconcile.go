@@ -0,0 +1,293 @@
+// Package reconcile recomputes the tables derived from the Comment table
+// (PageStructure, PageGlobalStructure, RecentGlobalComment and
+// RecentDomainComment) and repairs rows that have drifted.
+package reconcile
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// scanPageLimit is the page size used when reading whole tables.
+const scanPageLimit = 1000
+
+type Stores struct {
+	Comment             dynamo.CommentStore
+	PageGlobalStructure dynamo.PageGlobalStructureStore
+	PageStructure       dynamo.PageStructureStore
+	RecentDomainComment dynamo.RecentDomainCommentStore
+	RecentGlobalComment dynamo.RecentGlobalCommentStore
+}
+
+type DifferenceKind string
+
+const (
+	// Missing rows should exist according to the Comment table but do not.
+	Missing DifferenceKind = "missing"
+	// Stale rows exist but no comment backs them.
+	Stale DifferenceKind = "stale"
+	// Mismatch rows exist but hold different values than recomputed.
+	Mismatch DifferenceKind = "mismatch"
+)
+
+// Difference is one derived row that does not match the Comment table, along
+// with the write that repairs it.
+type Difference struct {
+	Table  string
+	Key    string
+	Kind   DifferenceKind
+	Detail string
+
+	repair func() error
+}
+
+func (d Difference) String() string {
+	s := fmt.Sprintf("%s %s %s", d.Table, d.Kind, d.Key)
+	if d.Detail != "" {
+		s += ": " + d.Detail
+	}
+	return s
+}
+
+// Compute reads the Comment table and every derived table in full and
+// returns the differences between the stored and recomputed derived rows.
+// Nothing is written.
+//
+// Whole tables are held in memory, which is fine for the sizes this tool is
+// meant for. When two comments share a millisecond the Recent* tables can
+// only hold one of them, and whichever was written last is; a row holding
+// either comment is accepted, and a missing row is repaired with the one
+// with the greatest CommentId.
+func Compute(stores Stores) ([]Difference, error) {
+	comments, err := scanAll(stores.Comment.ScanComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan comments: %w", err)
+	}
+
+	expected, err := recompute(comments)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []Difference
+
+	structures, err := scanAll(stores.PageStructure.ScanStructures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan page structures: %w", err)
+	}
+	diffs = append(diffs, diffRows(
+		"PageStructure",
+		candidates(expected.structures),
+		structures,
+		func(item dynamo.PageStructureItem) string { return item.SiteDomain + " " + item.Url },
+		stores.PageStructure.PutStructure,
+		func(item dynamo.PageStructureItem) error {
+			return stores.PageStructure.DeleteStructure(item.SiteDomain, item.Url)
+		},
+	)...)
+
+	globalStructures, err := scanAll(stores.PageGlobalStructure.GetGlobalStructure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global structures: %w", err)
+	}
+	diffs = append(diffs, diffRows(
+		"PageGlobalStructure",
+		candidates(expected.globalStructures),
+		globalStructures,
+		func(item dynamo.PageGlobalStructureItem) string { return item.SiteDomain },
+		stores.PageGlobalStructure.PutGlobalStructure,
+		func(item dynamo.PageGlobalStructureItem) error {
+			return stores.PageGlobalStructure.DeleteGlobalStructure(item.SiteDomain)
+		},
+	)...)
+
+	recentGlobals, err := scanAll(stores.RecentGlobalComment.GetRecentGlobalComment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recent global comments: %w", err)
+	}
+	diffs = append(diffs, diffRows(
+		"RecentGlobalComment",
+		expected.recentGlobals,
+		recentGlobals,
+		func(item dynamo.RecentGlobalCommentItem) string { return fmt.Sprintf("%d", item.UnixTime) },
+		stores.RecentGlobalComment.PutRecentGlobalComment,
+		func(item dynamo.RecentGlobalCommentItem) error {
+			return stores.RecentGlobalComment.DeleteRecentGlobalComment(item.UnixTime)
+		},
+	)...)
+
+	recentDomains, err := scanAll(stores.RecentDomainComment.ScanRecentDomainComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan recent domain comments: %w", err)
+	}
+	diffs = append(diffs, diffRows(
+		"RecentDomainComment",
+		expected.recentDomains,
+		recentDomains,
+		func(item dynamo.RecentDomainCommentItem) string {
+			return fmt.Sprintf("%s %d", item.SiteDomain, item.UnixTime)
+		},
+		stores.RecentDomainComment.PutRecentDomainComment,
+		func(item dynamo.RecentDomainCommentItem) error {
+			return stores.RecentDomainComment.DeleteRecentDomainComment(item.SiteDomain, item.UnixTime)
+		},
+	)...)
+
+	return diffs, nil
+}
+
+// Apply rewrites or deletes the derived rows listed in diffs. It stops at the
+// first failed write; running Compute again shows what is left.
+func Apply(diffs []Difference) error {
+	for _, d := range diffs {
+		if err := d.repair(); err != nil {
+			return fmt.Errorf("failed to repair %s: %w", d, err)
+		}
+	}
+	return nil
+}
+
+// derivedRows are the recomputed rows by key. A Recent* key lists every
+// comment that may hold it, in CommentId order.
+type derivedRows struct {
+	structures       map[string]dynamo.PageStructureItem
+	globalStructures map[string]dynamo.PageGlobalStructureItem
+	recentGlobals    map[string][]dynamo.RecentGlobalCommentItem
+	recentDomains    map[string][]dynamo.RecentDomainCommentItem
+}
+
+func recompute(comments []dynamo.CommentItem) (derivedRows, error) {
+	rows := derivedRows{
+		structures:       map[string]dynamo.PageStructureItem{},
+		globalStructures: map[string]dynamo.PageGlobalStructureItem{},
+		recentGlobals:    map[string][]dynamo.RecentGlobalCommentItem{},
+		recentDomains:    map[string][]dynamo.RecentDomainCommentItem{},
+	}
+
+	// Process comments in CommentId order so that the last candidate for a
+	// Recent* key is the one with the greatest CommentId.
+	slices.SortFunc(comments, func(a, b dynamo.CommentItem) int {
+		return strings.Compare(a.CommentId, b.CommentId)
+	})
+
+	for _, comment := range comments {
+		siteDomain, err := dynamo.GetDomainWithScheme(comment.Url)
+		if err != nil {
+			return derivedRows{}, fmt.Errorf("comment %s: %w", comment.CommentId, err)
+		}
+
+		structureKey := siteDomain + " " + comment.Url
+		structure, ok := rows.structures[structureKey]
+		if !ok {
+			structure = dynamo.PageStructureItem{SiteDomain: siteDomain, Url: comment.Url}
+
+			globalStructure := rows.globalStructures[siteDomain]
+			globalStructure.GlobalKey = "GLOBAL"
+			globalStructure.SiteDomain = siteDomain
+			globalStructure.UrlCount++
+			rows.globalStructures[siteDomain] = globalStructure
+		}
+		structure.CommentCount++
+		structure.LatestUnixTime = max(structure.LatestUnixTime, comment.UnixTime)
+		rows.structures[structureKey] = structure
+
+		recentGlobal, recentDomain := dynamo.RecentCommentItemsFromComment(comment, siteDomain)
+		globalKey := fmt.Sprintf("%d", recentGlobal.UnixTime)
+		rows.recentGlobals[globalKey] = append(rows.recentGlobals[globalKey], recentGlobal)
+		domainKey := fmt.Sprintf("%s %d", recentDomain.SiteDomain, recentDomain.UnixTime)
+		rows.recentDomains[domainKey] = append(rows.recentDomains[domainKey], recentDomain)
+	}
+
+	return rows, nil
+}
+
+// candidates lists each row of rows as the only candidate for its key.
+func candidates[T any](rows map[string]T) map[string][]T {
+	listed := make(map[string][]T, len(rows))
+	for k, row := range rows {
+		listed[k] = []T{row}
+	}
+	return listed
+}
+
+// diffRows compares the stored rows with the expected ones. A stored row
+// matches when it equals any candidate for its key; otherwise, and when it
+// is missing, it is repaired with the last candidate.
+func diffRows[T any](
+	table string,
+	expected map[string][]T,
+	actual []T,
+	key func(T) string,
+	put func(T) error,
+	del func(T) error,
+) []Difference {
+	var diffs []Difference
+
+	seen := make(map[string]bool, len(actual))
+	for _, item := range actual {
+		k := key(item)
+		seen[k] = true
+
+		wants, ok := expected[k]
+		switch {
+		case !ok:
+			diffs = append(diffs, Difference{
+				Table:  table,
+				Key:    k,
+				Kind:   Stale,
+				repair: func() error { return del(item) },
+			})
+		case !slices.ContainsFunc(wants, func(want T) bool { return reflect.DeepEqual(want, item) }):
+			want := wants[len(wants)-1]
+			diffs = append(diffs, Difference{
+				Table:  table,
+				Key:    k,
+				Kind:   Mismatch,
+				Detail: fmt.Sprintf("%+v -> %+v", item, want),
+				repair: func() error { return put(want) },
+			})
+		}
+	}
+
+	for k, wants := range expected {
+		if seen[k] {
+			continue
+		}
+		want := wants[len(wants)-1]
+		diffs = append(diffs, Difference{
+			Table:  table,
+			Key:    k,
+			Kind:   Missing,
+			Detail: fmt.Sprintf("%+v", want),
+			repair: func() error { return put(want) },
+		})
+	}
+
+	slices.SortFunc(diffs, func(a, b Difference) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return diffs
+}
+
+// scanAll follows LastEvaluatedKey until a paged read is exhausted.
+func scanAll[T any](read func(dynamo.PageRequest) ([]T, map[string]types.AttributeValue, error)) ([]T, error) {
+	var all []T
+	page := dynamo.PageRequest{Limit: scanPageLimit}
+	for {
+		items, lastKey, err := read(page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if len(lastKey) == 0 {
+			return all, nil
+		}
+		page.StartKey = lastKey
+	}
+}
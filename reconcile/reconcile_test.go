@@ -0,0 +1,123 @@
+package reconcile
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/memory"
+)
+
+func newTestStores() (Stores, *memory.CommentTransactionRepository) {
+	db := memory.NewDB()
+	return Stores{
+		Comment:             memory.NewCommentRepository(db),
+		PageGlobalStructure: memory.NewPageGlobalStructureRepository(db),
+		PageStructure:       memory.NewPageStructureRepository(db),
+		RecentDomainComment: memory.NewRecentDomainCommentRepository(db),
+		RecentGlobalComment: memory.NewRecentGlobalCommentRepository(db),
+	}, memory.NewCommentTransactionRepository(db)
+}
+
+func postComment(t *testing.T, repo *memory.CommentTransactionRepository, url string, unixTime int64, commentId string) dynamo.AllTableRecords {
+	t.Helper()
+	records := dynamo.GenerateAllTableRecords(dynamo.BaseFieldDatas{
+		Comment:    "hello",
+		CommentId:  commentId,
+		SiteDomain: "https://example.com",
+		Now:        unixTime,
+		Req:        httptest.NewRequest("POST", "/comment", nil),
+		Url:        url,
+		UserId:     "1",
+	})
+	if err := repo.PutAllTableRecords(records); err != nil {
+		t.Fatalf("PutAllTableRecords: %v", err)
+	}
+	return records
+}
+
+func compute(t *testing.T, stores Stores) []Difference {
+	t.Helper()
+	diffs, err := Compute(stores)
+	if err != nil {
+		t.Fatalf("Compute: %v", err)
+	}
+	return diffs
+}
+
+func TestComputeFindsNoDifferencesInConsistentTables(t *testing.T) {
+	stores, repo := newTestStores()
+	postComment(t, repo, "https://example.com/a", 1, "comment-1")
+	postComment(t, repo, "https://example.com/a", 2, "comment-2")
+	postComment(t, repo, "https://example.com/b", 3, "comment-3")
+
+	if diffs := compute(t, stores); len(diffs) != 0 {
+		t.Errorf("differences in consistent tables: %v", diffs)
+	}
+}
+
+func TestApplyRepairsDrift(t *testing.T) {
+	stores, repo := newTestStores()
+	postComment(t, repo, "https://example.com/a", 1, "comment-1")
+	postComment(t, repo, "https://example.com/a", 2, "comment-2")
+
+	// Missing: the recent global row of comment 1.
+	if err := stores.RecentGlobalComment.DeleteRecentGlobalComment(1); err != nil {
+		t.Fatal(err)
+	}
+	// Mismatch: the page's comment count.
+	if err := stores.PageStructure.PutStructure(dynamo.PageStructureItem{SiteDomain: "https://example.com", Url: "https://example.com/a", CommentCount: 5, LatestUnixTime: 2}); err != nil {
+		t.Fatal(err)
+	}
+	// Stale: a page without comments.
+	if err := stores.PageStructure.PutStructure(dynamo.PageStructureItem{SiteDomain: "https://example.com", Url: "https://example.com/gone", CommentCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := compute(t, stores)
+	kinds := map[DifferenceKind]int{}
+	for _, d := range diffs {
+		kinds[d.Kind]++
+	}
+	if kinds[Missing] != 1 || kinds[Mismatch] != 1 || kinds[Stale] != 1 {
+		t.Fatalf("differences = %v, want one missing, one mismatch and one stale", diffs)
+	}
+
+	if err := Apply(diffs); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if diffs := compute(t, stores); len(diffs) != 0 {
+		t.Errorf("differences left after Apply: %v", diffs)
+	}
+}
+
+func TestComputeAcceptsEitherCollidingComment(t *testing.T) {
+	stores, repo := newTestStores()
+	// Two pages commented on in the same millisecond share the recent global
+	// key. The comment written last, here the smaller CommentId, holds it.
+	postComment(t, repo, "https://example.com/a", 1, "comment-2")
+	postComment(t, repo, "https://example.com/b", 1, "comment-1")
+
+	if diffs := compute(t, stores); len(diffs) != 0 {
+		t.Errorf("differences for a collision: %v", diffs)
+	}
+
+	if err := stores.RecentGlobalComment.DeleteRecentGlobalComment(1); err != nil {
+		t.Fatal(err)
+	}
+	diffs := compute(t, stores)
+	if len(diffs) != 1 || diffs[0].Kind != Missing {
+		t.Fatalf("differences = %v, want the recent global row missing", diffs)
+	}
+	if err := Apply(diffs); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	recent, _, err := stores.RecentGlobalComment.GetRecentGlobalComment(dynamo.PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 || recent[0].CommentId != "comment-2" {
+		t.Errorf("recent global rows = %+v, want comment-2 restored", recent)
+	}
+}
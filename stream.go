@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pageknock-backend/dynamo"
+	"pageknock-backend/pubsub"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment line, keeping
+// proxies from closing the connection and detecting gone clients.
+const streamHeartbeat = 15 * time.Second
+
+// streamRetry is the reconnection delay suggested to clients, in
+// milliseconds.
+const streamRetry = 3000
+
+// loadCommentHub reads STREAM_HISTORY_SIZE, the number of recent events kept
+// for Last-Event-ID resume (default 1000), and STREAM_BUFFER_SIZE, the events
+// a subscriber may fall behind before it is disconnected (default 64).
+func loadCommentHub() *pubsub.Hub {
+	historySize := loadFilterInt("STREAM_HISTORY_SIZE", 1000)
+	bufferSize := loadFilterInt("STREAM_BUFFER_SIZE", 64)
+	if bufferSize < 1 {
+		log.Fatalf("invalid STREAM_BUFFER_SIZE: %d", bufferSize)
+	}
+	return pubsub.NewHub(historySize, bufferSize)
+}
+
+func urlTopic(url string) string {
+	return "url:" + url
+}
+
+func domainTopic(siteDomain string) string {
+	return "domain:" + siteDomain
+}
+
+const globalTopic = "global"
+
+// publishComment pushes a newly posted comment to the streams of its URL, its
+// site domain and the global feed.
+func publishComment(item dynamo.CommentItem, siteDomain string) {
+	data, err := json.Marshal(dynamo.CommentEventResponse{
+		CommentResponse: dynamo.CommentResponse{
+			UnixTime:        item.UnixTime,
+			Comment:         item.Comment,
+			CommentId:       item.CommentId,
+			UserID:          item.UserID,
+			EditedAt:        item.EditedAt,
+			ParentCommentId: item.ParentCommentId,
+			ReplyCount:      item.ReplyCount,
+			Reactions:       reactionCounts(item.Reactions),
+		},
+		Url:        item.Url,
+		SiteDomain: siteDomain,
+	})
+	if err != nil {
+		log.Printf("failed to encode comment event: %v", err)
+		return
+	}
+
+	commentHub.Publish("comment", []string{urlTopic(item.Url), domainTopic(siteDomain), globalTopic}, data)
+}
+
+// publishReaction pushes the new reaction counts of a comment to the streams
+// of its URL.
+func publishReaction(item dynamo.CommentItem, counts map[string]int) {
+	data, err := json.Marshal(dynamo.ReactionEventResponse{
+		CommentId: item.CommentId,
+		Url:       item.Url,
+		UnixTime:  item.UnixTime,
+		Reactions: reactionCounts(counts),
+	})
+	if err != nil {
+		log.Printf("failed to encode reaction event: %v", err)
+		return
+	}
+
+	commentHub.Publish("reaction", []string{urlTopic(item.Url)}, data)
+}
+
+// handleStream streams newly posted comments as Server-Sent Events: those on
+// ?url=, on ?siteDomain=, or on every site when neither is given. URL streams
+// also carry "reaction" events with a comment's new reaction counts. A client
+// reconnecting with Last-Event-ID (or ?lastEventId=) first receives what it
+// missed, as far as the hub's history reaches. A client too slow to keep up
+// is disconnected and resumes the same way.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeInternalError(w, r, errors.New("streaming unsupported"))
+		return
+	}
+
+	topic := globalTopic
+	switch {
+	case r.URL.Query().Get("url") != "":
+		canonicalUrl, err := urlCanonicalizer.Canonicalize(r.URL.Query().Get("url"))
+		if err != nil {
+			writeInvalidField(w, r, "url", fieldInvalid)
+			return
+		}
+		topic = urlTopic(canonicalUrl)
+	case r.URL.Query().Get("siteDomain") != "":
+		siteDomain, err := urlCanonicalizer.CanonicalizeSiteDomain(r.URL.Query().Get("siteDomain"))
+		if err != nil {
+			writeInvalidField(w, r, "siteDomain", fieldInvalid)
+			return
+		}
+		topic = domainTopic(siteDomain)
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	var lastId uint64
+	if lastEventId != "" {
+		var err error
+		lastId, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			writeInvalidField(w, r, "Last-Event-ID", fieldInvalid)
+			return
+		}
+	}
+
+	sub := commentHub.Subscribe(topic, lastId, lastEventId != "")
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	for _, event := range sub.Backlog {
+		writeStreamEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			writeStreamEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeStreamEvent writes one event. Its data is single-line JSON, so it
+// needs no splitting into several data fields.
+func writeStreamEvent(w http.ResponseWriter, event pubsub.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, event.Data)
+}
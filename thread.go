@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"pageknock-backend/dynamo"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	defaultTreeDepth  = 3
+	maxTreeDepth      = 3
+	defaultReplyLimit = 10
+	maxReplyLimit     = 20
+	// maxReplyQueries bounds the reply queries of one tree request. Comments
+	// reached after it is spent are listed without their replies, as below
+	// the depth limit.
+	maxReplyQueries = 50
+)
+
+// handleGetCommentTree returns a page's comments as a tree. Without
+// parentCommentId the top-level comments of url are listed, newest first;
+// with it the replies to that comment are, oldest first, so one branch can be
+// paged on its own; a parent that is missing, on another page or hidden
+// answers 404. Each listed comment carries up to replyLimit of its
+// replies, recursively, down to depth levels in total.
+func handleGetCommentTree(w http.ResponseWriter, r *http.Request) {
+
+	enableCORS(w, r)
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if !requireFields(w, r, map[string]bool{"url": query.Get("url") != ""}) {
+		return
+	}
+
+	url, err := urlCanonicalizer.Canonicalize(query.Get("url"))
+	if err != nil {
+		writeInvalidField(w, r, "url", fieldInvalid)
+		return
+	}
+
+	depth, err := parseTreeParam(query.Get("depth"), defaultTreeDepth, maxTreeDepth)
+	if err != nil {
+		writeInvalidField(w, r, "depth", fieldInvalid)
+		return
+	}
+	replyLimit, err := parseTreeParam(query.Get("replyLimit"), defaultReplyLimit, maxReplyLimit)
+	if err != nil {
+		writeInvalidField(w, r, "replyLimit", fieldInvalid)
+		return
+	}
+
+	parentCommentId := query.Get("parentCommentId")
+	scope := "commentTree:" + url
+	if parentCommentId != "" {
+		// Replies are listed only under a comment that is listed itself.
+		parent, ok, err := commentRepo.GetCommentByID(parentCommentId)
+		if err != nil {
+			writeInternalError(w, r, fmt.Errorf("failed to fetch parent comment: %w", err))
+			return
+		}
+		if !ok || parent.Url != url || parent.Hidden {
+			writeError(w, r, http.StatusNotFound, codeCommentNotFound)
+			return
+		}
+		scope = repliesScope(url, parentCommentId)
+	}
+
+	page, err := parsePageRequest(scope, query.Get("cursor"), query.Get("limit"))
+	if err != nil {
+		writePageRequestError(w, r, err)
+		return
+	}
+
+	var records []dynamo.CommentItem
+	var lastKey map[string]types.AttributeValue
+	if parentCommentId != "" {
+		records, lastKey, err = commentRepo.GetReplies(url, parentCommentId, page)
+	} else {
+		records, lastKey, err = commentRepo.GetRootCommentsByURL(url, page)
+	}
+	if errors.Is(err, dynamo.ErrInvalidCursor) {
+		writePageRequestError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch comments: %w", err))
+		return
+	}
+
+	nextCursor, err := cursorCodec.Encode(scope, lastKey)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to encode cursor: %w", err))
+		return
+	}
+
+	queries := maxReplyQueries
+	comments, err := buildCommentTree(records, depth-1, replyLimit, &queries)
+	if err != nil {
+		writeInternalError(w, r, fmt.Errorf("failed to fetch replies: %w", err))
+		return
+	}
+
+	response := dynamo.CommentTreeListResponse{
+		Comments:   comments,
+		NextCursor: nextCursor,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// buildCommentTree attaches up to replyLimit replies to each comment, going
+// depth more levels down, with at most *queries reply queries, which it
+// counts down. Only comments with a replyCount are queried. Hidden comments
+// are left out together with their replies.
+func buildCommentTree(records []dynamo.CommentItem, depth int, replyLimit int, queries *int) ([]dynamo.CommentTreeResponse, error) {
+	nodes := make([]dynamo.CommentTreeResponse, 0, len(records))
+	for _, rec := range records {
+		if rec.Hidden {
+			continue
+		}
+		node := dynamo.CommentTreeResponse{
+			CommentResponse: dynamo.CommentResponse{
+				UnixTime:        rec.UnixTime,
+				Comment:         rec.Comment,
+				CommentId:       rec.CommentId,
+				UserID:          rec.UserID,
+				EditedAt:        rec.EditedAt,
+				ParentCommentId: rec.ParentCommentId,
+				ReplyCount:      rec.ReplyCount,
+				Reactions:       reactionCounts(rec.Reactions),
+			},
+			Replies: []dynamo.CommentTreeResponse{},
+		}
+
+		if depth > 0 && rec.ReplyCount > 0 && *queries > 0 {
+			*queries--
+			replies, lastKey, err := commentRepo.GetReplies(rec.Url, rec.CommentId, dynamo.PageRequest{Limit: int32(replyLimit)})
+			if err != nil {
+				return nil, err
+			}
+			node.Replies, err = buildCommentTree(replies, depth-1, replyLimit, queries)
+			if err != nil {
+				return nil, err
+			}
+			node.RepliesCursor, err = cursorCodec.Encode(repliesScope(rec.Url, rec.CommentId), lastKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func repliesScope(url string, parentCommentId string) string {
+	return "commentReplies:" + url + "#" + parentCommentId
+}
+
+func parseTreeParam(v string, defaultValue int, maxValue int) (int, error) {
+	if v == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid value")
+	}
+	return min(n, maxValue), nil
+}